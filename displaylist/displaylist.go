@@ -0,0 +1,54 @@
+// Package displaylist compiles what a drawing function draws into an OpenGL
+// display list once, for the lessons that replay the same geometry every
+// frame.
+package displaylist
+
+import (
+	"github.com/banthar/gl"
+)
+
+// A List replays what its drawing function drew with CallList. Whatever
+// the drawing depends on is returned by key; when that changes, the list
+// is compiled again.
+type List struct {
+	draw     func()
+	key      func() interface{}
+	list     uint
+	compiled interface{} // the key the list was compiled with
+	valid    bool
+}
+
+// A list of what draw draws, compiled the first time it is called. A nil
+// key means the drawing never changes.
+func New(draw func(), key func() interface{}) *List {
+	if key == nil {
+		key = func() interface{} { return nil }
+	}
+	return &List{draw: draw, key: key}
+}
+
+// Draw what the list holds, compiling it first if it is out of date
+func (d *List) Call() {
+	if k := d.key(); !d.valid || k != d.compiled {
+		d.compile(k)
+	}
+	gl.CallList(d.list)
+}
+
+func (d *List) compile(key interface{}) {
+	if d.list == 0 {
+		d.list = gl.GenLists(1)
+	}
+	gl.NewList(d.list, gl.COMPILE)
+	d.draw()
+	gl.EndList()
+	d.compiled, d.valid = key, true
+}
+
+// Free the display list
+func (d *List) Delete() {
+	if d.list != 0 {
+		gl.DeleteLists(d.list, 1)
+		d.list, d.valid = 0, false
+	}
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/banthar/Go-SDL/sdl"
-	"github.com/banthar/gl"
-	"math"
+	"github.com/manveru/opengl-go-tutorials/lesson08/render"
+	"github.com/manveru/opengl-go-tutorials/lesson08/render/glrender"
+	"github.com/manveru/opengl-go-tutorials/rgba"
 	"os"
 )
 
@@ -35,32 +37,13 @@ func resizeWindow(width, height int) {
 	}
 
 	// Setup our viewport
-	gl.Viewport(0, 0, width, height)
-
-	// change to the projection matrix and set our viewing volume.
-	gl.MatrixMode(gl.PROJECTION)
-	gl.LoadIdentity()
-
-	// aspect ratio
-	aspect := gl.GLdouble(gl.GLfloat(width) / gl.GLfloat(height))
-
-	// Set our perspective.
-	// This code is equivalent to using gluPerspective as in the original tutorial.
-	var fov, near, far gl.GLdouble
-	fov = 45.0
-	near = 0.1
-	far = 100.0
-	top := gl.GLdouble(math.Tan(float64(fov*math.Pi/360.0))) * near
-	bottom := -top
-	left := aspect * bottom
-	right := aspect * top
-	gl.Frustum(float64(left), float64(right), float64(bottom), float64(top), float64(near), float64(far))
-
-	// Make sure we're changing the model view and not the projection
-	gl.MatrixMode(gl.MODELVIEW)
+	renderer.Viewport(0, 0, width, height)
+
+	// Set our perspective, like gluPerspective does in the original tutorial
+	renderer.Perspective(45.0, float64(width)/float64(height), 0.1, 100.0)
 
 	// Reset the view
-	gl.LoadIdentity()
+	renderer.LoadIdentity()
 }
 
 // handle key press events
@@ -75,34 +58,22 @@ func handleKeyPress(keysym sdl.Keysym) {
 
 // general OpenGL initialization
 func initGL() {
-	// Enable Texture Mapping
-	gl.Enable(gl.TEXTURE_2D)
-
-	// enable smooth shading
-	gl.ShadeModel(gl.SMOOTH)
+	// Texture mapping, smooth shading and depth testing
+	renderer.Init()
 
 	// Set the background to black
-	gl.ClearColor(0.0, 0.0, 0.0, 0.5)
-
-	// Depth buffer setup
-	gl.ClearDepth(1.0)
-
-	// Enable depth testing
-	gl.Enable(gl.DEPTH_TEST)
-
-	// The type of test
-	gl.DepthFunc(gl.LEQUAL)
-
-	// Nicest perspective correction
-	gl.Hint(gl.PERSPECTIVE_CORRECTION_HINT, gl.NICEST)
+	renderer.ClearColor(0.0, 0.0, 0.0, 0.5)
 }
 
 var (
-	t0, frames uint32     // used to calculate fps
-	xrot       gl.GLfloat // X Rotation
-	yrot       gl.GLfloat // Y Rotation
-	zrot       gl.GLfloat // Z Rotation
-	texture    gl.Texture
+	t0, frames uint32  // used to calculate fps
+	xrot       float32 // X Rotation
+	yrot       float32 // Y Rotation
+	zrot       float32 // Z Rotation
+
+	renderer render.Renderer // What we draw with
+	texture  render.Texture
+	cube     render.Mesh
 )
 
 // load in bitmap as a GL texture
@@ -126,132 +97,84 @@ func LoadGLTexture(path string) {
 
 	// get the number of channels in the SDL surface
 	nOfColors := image.Format.BytesPerPixel
-	var textureFormat gl.GLenum
-
-	if nOfColors == 4 { // contains alpha channel
-		if image.Format.Rmask == 0x000000ff {
-			textureFormat = gl.RGBA
-		} else {
-			textureFormat = gl.BGRA
-		}
-	} else if nOfColors == 3 { // no alpha channel
-		if image.Format.Rmask == 0x000000ff {
-			textureFormat = gl.RGB
-		} else {
-			textureFormat = gl.BGR
-		}
-	} else {
+	if nOfColors != 4 && nOfColors != 3 {
 		fmt.Println("warning:", path, "is not truecolor, this will probably break")
 	}
 
-	// Create the texture
-	texture = gl.GenTexture()
-
-	// Typical texture generation using data from the bitmap
-	texture.Bind(gl.TEXTURE_2D)
-
 	fmt.Println("Generating image")
 	fmt.Println(image)
 
-	// Generate the texture
-	gl.TexImage2D(
-		gl.TEXTURE_2D,
-		0,
-		int(image.Format.BytesPerPixel),
-		int(image.W),
-		int(image.H),
-		0,
-		textureFormat,
-		gl.UNSIGNED_BYTE,
-		image.Pixels,
-	)
+	// Generate the texture with linear filtering, from RGBA whatever order
+	// the bitmap has its colors in
+	pixels := rgba.FromSurface(image)
+	texture = renderer.NewTexture(pixels, int(image.W), int(image.H), render.FILTER_LINEAR)
 	fmt.Println("Generated")
 
-	// linear filtering
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
-
 	// free up memory we have used.
 	image.Free()
 }
 
+// A vertex at x, y, z with normal nx, ny, nz and texture coordinates u, v
+func vertex(x, y, z, nx, ny, nz, u, v float32) render.Vertex {
+	return render.Vertex{X: x, Y: y, Z: z, NX: nx, NY: ny, NZ: nz, U: u, V: v}
+}
+
+// The textured cube, a quad for every face
+var cubeVertices = []render.Vertex{
+	// Front face
+	vertex(-1.0, -1.0, 1.0, 0.0, 0.0, 1.0, 0.0, 1.0), // Bottom left
+	vertex(1.0, -1.0, 1.0, 0.0, 0.0, 1.0, 1.0, 1.0),  // Bottom right
+	vertex(1.0, 1.0, 1.0, 0.0, 0.0, 1.0, 1.0, 0.0),   // Top right
+	vertex(-1.0, 1.0, 1.0, 0.0, 0.0, 1.0, 0.0, 0.0),  // Top left
+
+	// Back Face
+	vertex(-1.0, -1.0, -1.0, 0.0, 0.0, -1.0, 0.0, 0.0), // Bottom right
+	vertex(-1.0, 1.0, -1.0, 0.0, 0.0, -1.0, 0.0, 1.0),  // Top right
+	vertex(1.0, 1.0, -1.0, 0.0, 0.0, -1.0, 1.0, 1.0),   // Top left
+	vertex(1.0, -1.0, -1.0, 0.0, 0.0, -1.0, 1.0, 0.0),  // Bottom left
+
+	// Top Face
+	vertex(-1.0, 1.0, -1.0, 0.0, 1.0, 0.0, 1.0, 1.0), // Top left
+	vertex(-1.0, 1.0, 1.0, 0.0, 1.0, 0.0, 1.0, 0.0),  // Bottom left
+	vertex(1.0, 1.0, 1.0, 0.0, 1.0, 0.0, 0.0, 0.0),   // Bottom right
+	vertex(1.0, 1.0, -1.0, 0.0, 1.0, 0.0, 0.0, 1.0),  // Top right
+
+	// Bottom Face
+	vertex(-1.0, -1.0, -1.0, 0.0, -1.0, 0.0, 0.0, 1.0), // Top right
+	vertex(1.0, -1.0, -1.0, 0.0, -1.0, 0.0, 1.0, 1.0),  // Top left
+	vertex(1.0, -1.0, 1.0, 0.0, -1.0, 0.0, 1.0, 0.0),   // Bottom left
+	vertex(-1.0, -1.0, 1.0, 0.0, -1.0, 0.0, 0.0, 0.0),  // Bottom right
+
+	// Right face
+	vertex(1.0, -1.0, -1.0, 1.0, 0.0, 0.0, 0.0, 0.0), // Bottom right
+	vertex(1.0, 1.0, -1.0, 1.0, 0.0, 0.0, 0.0, 1.0),  // Top right
+	vertex(1.0, 1.0, 1.0, 1.0, 0.0, 0.0, 1.0, 1.0),   // Top left
+	vertex(1.0, -1.0, 1.0, 1.0, 0.0, 0.0, 1.0, 0.0),  // Bottom left
+
+	// Left Face
+	vertex(-1.0, -1.0, -1.0, -1.0, 0.0, 0.0, 1.0, 0.0), // Bottom left
+	vertex(-1.0, -1.0, 1.0, -1.0, 0.0, 0.0, 0.0, 0.0),  // Bottom right
+	vertex(-1.0, 1.0, 1.0, -1.0, 0.0, 0.0, 0.0, 1.0),   // Top right
+	vertex(-1.0, 1.0, -1.0, -1.0, 0.0, 0.0, 1.0, 1.0),  // Top left
+}
+
 // Here goes our drawing code
 func drawGLScene() {
 	// Clear the screen and depth buffer
-	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+	renderer.Clear()
 
 	// Move left 1.5 units and into the screen 6.0 units.
-	gl.LoadIdentity()
-	gl.Translatef(0.0, 0.0, -7.0)
+	renderer.LoadIdentity()
+	renderer.Translate(0.0, 0.0, -7.0)
 
-	gl.Rotatef(float32(xrot), 1.0, 0.0, 0.0) /* Rotate On The X Axis */
-	gl.Rotatef(float32(yrot), 0.0, 1.0, 0.0) /* Rotate On The Y Axis */
-	gl.Rotatef(float32(zrot), 0.0, 0.0, 1.0) /* Rotate On The Z Axis */
+	renderer.Rotate(xrot, 1.0, 0.0, 0.0) /* Rotate On The X Axis */
+	renderer.Rotate(yrot, 0.0, 1.0, 0.0) /* Rotate On The Y Axis */
+	renderer.Rotate(zrot, 0.0, 0.0, 1.0) /* Rotate On The Z Axis */
 
 	/* Select Our Texture */
-	gl.BindTexture(gl.TEXTURE_2D, uint(texture))
-
-	gl.Begin(gl.QUADS) // Draw a quad
-	/* Front Face */
-	gl.TexCoord2f(0.0, 1.0)
-	gl.Vertex3f(-1.0, -1.0, 1.0) // Bottom left
-	gl.TexCoord2f(1.0, 1.0)
-	gl.Vertex3f(1.0, -1.0, 1.0) // Bottom right
-	gl.TexCoord2f(1.0, 0.0)
-	gl.Vertex3f(1.0, 1.0, 1.0) // Top right
-	gl.TexCoord2f(0.0, 0.0)
-	gl.Vertex3f(-1.0, 1.0, 1.0) // Top left
-
-	/* Back Face */
-	gl.TexCoord2f(0.0, 0.0)
-	gl.Vertex3f(-1.0, -1.0, -1.0) // Bottom right
-	gl.TexCoord2f(0.0, 1.0)
-	gl.Vertex3f(-1.0, 1.0, -1.0) // Top right
-	gl.TexCoord2f(1.0, 1.0)
-	gl.Vertex3f(1.0, 1.0, -1.0) // Top left
-	gl.TexCoord2f(1.0, 0.0)
-	gl.Vertex3f(1.0, -1.0, -1.0) // Bottom left
-
-	/* Top Face */
-	gl.TexCoord2f(1.0, 1.0)
-	gl.Vertex3f(-1.0, 1.0, -1.0) // Top left
-	gl.TexCoord2f(1.0, 0.0)
-	gl.Vertex3f(-1.0, 1.0, 1.0) // Bottom left
-	gl.TexCoord2f(0.0, 0.0)
-	gl.Vertex3f(1.0, 1.0, 1.0) // Bottom right
-	gl.TexCoord2f(0.0, 1.0)
-	gl.Vertex3f(1.0, 1.0, -1.0) // Top right
-
-	/* Bottom Face */
-	gl.TexCoord2f(0.0, 1.0)
-	gl.Vertex3f(-1.0, -1.0, -1.0) // Top right
-	gl.TexCoord2f(1.0, 1.0)
-	gl.Vertex3f(1.0, -1.0, -1.0) // Top left
-	gl.TexCoord2f(1.0, 0.0)
-	gl.Vertex3f(1.0, -1.0, 1.0) // Bottom left
-	gl.TexCoord2f(0.0, 0.0)
-	gl.Vertex3f(-1.0, -1.0, 1.0) // Bottom right
-
-	/* Right face */
-	gl.TexCoord2f(0.0, 0.0)
-	gl.Vertex3f(1.0, -1.0, -1.0) // Bottom right
-	gl.TexCoord2f(0.0, 1.0)
-	gl.Vertex3f(1.0, 1.0, -1.0) // Top right
-	gl.TexCoord2f(1.0, 1.0)
-	gl.Vertex3f(1.0, 1.0, 1.0) // Top left
-	gl.TexCoord2f(1.0, 0.0)
-	gl.Vertex3f(1.0, -1.0, 1.0) // Bottom left
-
-	/* Left Face */
-	gl.TexCoord2f(1.0, 0.0)
-	gl.Vertex3f(-1.0, -1.0, -1.0) // Bottom left
-	gl.TexCoord2f(0.0, 0.0)
-	gl.Vertex3f(-1.0, -1.0, 1.0) // Bottom right
-	gl.TexCoord2f(0.0, 1.0)
-	gl.Vertex3f(-1.0, 1.0, 1.0) // Top right
-	gl.TexCoord2f(1.0, 1.0)
-	gl.Vertex3f(-1.0, 1.0, -1.0) // Top left
-	gl.End()                     // done drawing the quad
+	renderer.BindTexture(texture)
+
+	renderer.Draw(cube)
 
 	// Draw to the screen
 	sdl.GL_SwapBuffers()
@@ -273,6 +196,9 @@ func drawGLScene() {
 }
 
 func main() {
+	core := flag.Bool("core", false, "draw with shaders and buffers only, as an OpenGL core profile would")
+	flag.Parse()
+
 	// Initialize SDL
 	if sdl.Init(sdl.INIT_VIDEO) < 0 {
 		panic("Video initialization failed: " + sdl.GetError())
@@ -300,6 +226,13 @@ func main() {
 	// When this function is finished, clean up and exit.
 	defer Quit(0)
 
+	// Everything is drawn through the renderer from here on
+	if *core {
+		renderer = glrender.NewCore()
+	} else {
+		renderer = glrender.NewLegacy()
+	}
+
 	fmt.Println("Loading image")
 	LoadGLTexture("data/nehe.bmp")
 	fmt.Println("Image loaded")
@@ -307,6 +240,9 @@ func main() {
 	// Initialize OpenGL
 	initGL()
 
+	// the cube never changes, so it is uploaded once
+	cube = renderer.NewMesh(render.PRIMITIVE_QUADS, cubeVertices)
+
 	// Resize the initial window
 	resizeWindow(SCREEN_WIDTH, SCREEN_HEIGHT)
 
@@ -0,0 +1,43 @@
+// Phong lighting per pixel with the lights set up for the fixed-function
+// pipeline, plus a highlight in the color of each light
+#version 120
+
+uniform sampler2D crate;
+uniform bool lighting;
+uniform float shininess;
+uniform float specular;
+
+varying vec3 position;
+varying vec3 normal;
+
+void main()
+{
+	vec4 color = texture2D(crate, gl_TexCoord[0].st);
+	if (!lighting) {
+		gl_FragColor = color;
+		return;
+	}
+
+	vec3 n = normalize(normal);
+	vec3 toEye = normalize(-position);
+
+	vec4 light = gl_FrontLightModelProduct.sceneColor;
+	vec3 highlight = vec3(0.0);
+
+	// lesson07 lights the crate with lights 1 and 2
+	for (int i = 1; i <= 2; i++) {
+		vec3 toLight = normalize(gl_LightSource[i].position.xyz - position);
+		float diffuse = max(dot(n, toLight), 0.0);
+
+		light += gl_FrontLightProduct[i].ambient;
+		light += gl_FrontLightProduct[i].diffuse * diffuse;
+
+		if (diffuse > 0.0) {
+			vec3 reflected = reflect(-toLight, n);
+			float shine = pow(max(dot(reflected, toEye), 0.0), shininess);
+			highlight += gl_LightSource[i].diffuse.rgb * specular * shine;
+		}
+	}
+
+	gl_FragColor = vec4(color.rgb * light.rgb + highlight, color.a);
+}
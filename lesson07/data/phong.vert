@@ -0,0 +1,14 @@
+// Per-pixel lighting for the crate: hand the position and normal in eye
+// space to the fragment shader, which lights every pixel on its own
+#version 120
+
+varying vec3 position;
+varying vec3 normal;
+
+void main()
+{
+	position = vec3(gl_ModelViewMatrix * gl_Vertex);
+	normal = gl_NormalMatrix * gl_Normal;
+	gl_TexCoord[0] = gl_MultiTexCoord0;
+	gl_Position = ftransform();
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/banthar/Go-SDL/sdl"
 	"github.com/banthar/gl"
-	"github.com/banthar/glu"
-	"math"
+	"github.com/manveru/opengl-go-tutorials/lesson08/render"
+	"github.com/manveru/opengl-go-tutorials/lesson08/render/glrender"
+	"github.com/manveru/opengl-go-tutorials/rgba"
 	"os"
 )
 
@@ -24,23 +26,36 @@ var (
 	light    = false // Light is off at first
 	lPressed = false // L button pressed?
 	fPressed = false // F button pressed?
+	phong    = false // Per-pixel lighting from shaders instead of fixed-function?
+
+	xrot   float32        // X Rotation
+	yrot   float32        // Y Rotation
+	xspeed float32        // X Rotation Speed
+	yspeed float32        // Y Rotation Speed
+	z      float32 = -5.0 // Depth Into The Screen
+
+	light1 = render.Light{
+		Ambient:  [4]float32{0.0, 1.0, 0.0, 1.0}, // Ambient light values
+		Diffuse:  [4]float32{0.0, 1.0, 0.0, 1.0}, // Diffuse light values
+		Position: [4]float32{2.0, 0.0, 2.0, 1.0}, // Light position
+	}
 
-	xrot   gl.GLfloat        // X Rotation
-	yrot   gl.GLfloat        // Y Rotation
-	xspeed gl.GLfloat        // X Rotation Speed
-	yspeed gl.GLfloat        // Y Rotation Speed
-	z      gl.GLfloat = -5.0 // Depth Into The Screen
+	light2 = render.Light{
+		Ambient:  [4]float32{1.0, 0.0, 0.0, 1.0},  // Ambient light values
+		Diffuse:  [4]float32{1.0, 0.0, 0.0, 1.0},  // Diffuse light values
+		Position: [4]float32{-2.0, 0.0, 2.0, 1.0}, // Light position
+	}
 
-	lightAmbient1  = [4]float32{0.0, 1.0, 0.0, 1.0} // Ambient light values
-	lightDiffuse1  = [4]float32{0.0, 1.0, 0.0, 1.0} // Diffuse light values
-	lightPosition1 = [4]float32{2.0, 0.0, 2.0, 1.0} // Light position
+	shader    *Shader // Per-pixel lighting, nil if it couldn't be loaded
+	shininess = 32.0  // How sharp its highlights are
+	specular  = 0.5   // and how bright
 
-	lightAmbient2  = [4]float32{1.0, 0.0, 0.0, 1.0}  // Ambient light values
-	lightDiffuse2  = [4]float32{1.0, 0.0, 0.0, 1.0}  // Diffuse light values
-	lightPosition2 = [4]float32{-2.0, 0.0, 2.0, 1.0} // Light position
+	renderer render.Renderer   // What we draw with
+	filter   int               // Which filter to use
+	textures [3]render.Texture // Storage for 3 textures
+	cube     render.Mesh
 
-	filter   gl.GLuint     // Which filter to use
-	textures [3]gl.Texture // Storage for 3 textures
+	skybox *Skybox // drawn behind the cube, nil to clear to black
 )
 
 // release/destroy our resources and restoring the old desktop
@@ -60,32 +75,13 @@ func resizeWindow(width, height int) {
 	}
 
 	// Setup our viewport
-	gl.Viewport(0, 0, width, height)
-
-	// change to the projection matrix and set our viewing volume.
-	gl.MatrixMode(gl.PROJECTION)
-	gl.LoadIdentity()
-
-	// aspect ratio
-	aspect := gl.GLdouble(gl.GLfloat(width) / gl.GLfloat(height))
-
-	// Set our perspective.
-	// This code is equivalent to using gluPerspective as in the original tutorial.
-	var fov, near, far gl.GLdouble
-	fov = 45.0
-	near = 0.1
-	far = 100.0
-	top := gl.GLdouble(math.Tan(float64(fov*math.Pi/360.0))) * near
-	bottom := -top
-	left := aspect * bottom
-	right := aspect * top
-	gl.Frustum(float64(left), float64(right), float64(bottom), float64(top), float64(near), float64(far))
-
-	// Make sure we're changing the model view and not the projection
-	gl.MatrixMode(gl.MODELVIEW)
+	renderer.Viewport(0, 0, width, height)
+
+	// Set our perspective, like gluPerspective does in the original tutorial
+	renderer.Perspective(45.0, float64(width)/float64(height), 0.1, 100.0)
 
 	// Reset the view
-	gl.LoadIdentity()
+	renderer.LoadIdentity()
 }
 
 // handle key press events
@@ -98,10 +94,20 @@ func handleKeyPress(keysym sdl.Keysym) {
 		light = !light
 		if light {
 			p("light on")
-			gl.Enable(gl.LIGHTING)
 		} else {
 			p("light off")
-			gl.Disable(gl.LIGHTING)
+		}
+		renderer.Lighting(light)
+	case sdl.K_s: // s key switches between per-pixel and fixed-function lighting
+		if shader == nil {
+			p("no per-pixel lighting, the shaders didn't load")
+			break
+		}
+		phong = !phong
+		if phong {
+			p("per-pixel lighting")
+		} else {
+			p("fixed-function lighting")
 		}
 	case sdl.K_PAGEUP: // page up zooms into the scene
 		z -= 0.02
@@ -124,24 +130,12 @@ func handleKeyPress(keysym sdl.Keysym) {
 
 // general OpenGL initialization
 func initGL() {
-	gl.Enable(gl.TEXTURE_2D)
-	gl.ShadeModel(gl.SMOOTH)
-	gl.ClearColor(0.0, 0.0, 0.0, 0.5)
-	gl.ClearDepth(1.0)
-	gl.Enable(gl.DEPTH_TEST)
-	gl.DepthFunc(gl.LEQUAL)
-	gl.Hint(gl.PERSPECTIVE_CORRECTION_HINT, gl.NICEST)
-
-	// Setup the light
-	gl.Lightfv(gl.LIGHT1, gl.AMBIENT, lightAmbient1[:])   // ambient lighting
-	gl.Lightfv(gl.LIGHT1, gl.DIFFUSE, lightDiffuse1[:])   // make it diffuse
-	gl.Lightfv(gl.LIGHT1, gl.POSITION, lightPosition1[:]) // and place it
-	gl.Enable(gl.LIGHT1)                                  // and finally turn it on.
-
-	gl.Lightfv(gl.LIGHT2, gl.AMBIENT, lightAmbient2[:])   // ambient lighting
-	gl.Lightfv(gl.LIGHT2, gl.DIFFUSE, lightDiffuse2[:])   // make it diffuse
-	gl.Lightfv(gl.LIGHT2, gl.POSITION, lightPosition2[:]) // and place it
-	gl.Enable(gl.LIGHT2)                                  // and finally turn it on.
+	renderer.Init()
+	renderer.ClearColor(0.0, 0.0, 0.0, 0.5)
+
+	// Setup the lights and turn them on
+	renderer.Light(1, light1)
+	renderer.Light(2, light2)
 }
 
 // load in bitmap as a GL texture
@@ -163,169 +157,101 @@ func LoadGLTextures(path string) {
 
 	// get the number of channels in the SDL surface
 	nOfColors := image.Format.BytesPerPixel
-	var textureFormat gl.GLenum
-
-	if nOfColors == 4 { // contains alpha channel
-		if image.Format.Rmask == 0x000000ff {
-			textureFormat = gl.RGBA
-		} else {
-			textureFormat = gl.BGRA
-		}
-	} else if nOfColors == 3 { // no alpha channel
-		if image.Format.Rmask == 0x000000ff {
-			textureFormat = gl.RGB
-		} else {
-			textureFormat = gl.BGR
-		}
-	} else {
+	if nOfColors != 4 && nOfColors != 3 {
 		fmt.Println("warning:", path, "is not truecolor, this will probably break")
 	}
 
-	// Create the textures
-	gl.GenTextures(textures[:])
-
-	// First texture
-	gl.BindTexture(gl.TEXTURE_2D, uint(textures[0]))
-	gl.TexImage2D(
-		gl.TEXTURE_2D,
-		0,
-		3,
-		int(image.W),
-		int(image.H),
-		0,
-		textureFormat,
-		gl.UNSIGNED_BYTE,
-		image.Pixels,
-	)
-
-	// linear filtering
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
-
-	// Second texture
-	gl.BindTexture(gl.TEXTURE_2D, uint(textures[1]))
-	gl.TexImage2D(gl.TEXTURE_2D, 0,
-		3,
-		int(image.W),
-		int(image.H),
-		0, textureFormat, gl.UNSIGNED_BYTE,
-		image.Pixels,
-	)
-
-	// Mipmapped filtering
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
-
-	// Third texture
-	gl.BindTexture(gl.TEXTURE_2D, uint(textures[2]))
-	gl.TexImage2D(gl.TEXTURE_2D, 0,
-		3,
-		int(image.W),
-		int(image.H),
-		0, textureFormat, gl.UNSIGNED_BYTE,
-		image.Pixels,
-	)
-
-	// Mipmapped filtering
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR_MIPMAP_NEAREST)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
-
-	glu.Build2DMipmaps(
-		gl.TEXTURE_2D,
-		3,
-		int(image.W),
-		int(image.H),
-		textureFormat,
-		image.Pixels,
-	)
+	// the renderer takes RGBA whatever order the bitmap has its colors in
+	pixels := rgba.FromSurface(image)
+
+	// Create the textures: nearest, linear and mipmapped filtering
+	width, height := int(image.W), int(image.H)
+	textures[0] = renderer.NewTexture(pixels, width, height, render.FILTER_NEAREST)
+	textures[1] = renderer.NewTexture(pixels, width, height, render.FILTER_LINEAR)
+	textures[2] = renderer.NewTexture(pixels, width, height, render.FILTER_MIPMAP)
 
 	image.Free()
 }
 
-// Here goes our drawing code
-func drawGLScene() {
-	// Clear the screen and depth buffer
-	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
-
-	// Move left 1.5 units and into the screen 6.0 units.
-	gl.LoadIdentity()
-	gl.Translatef(0.0, 0.0, float32(z)) // translate by z
-
-	gl.Rotatef(float32(xrot), 1.0, 0.0, 0.0) /* Rotate On The X Axis */
-	gl.Rotatef(float32(yrot), 0.0, 1.0, 0.0) /* Rotate On The Y Axis */
-
-	/* Select Our Texture */
-	gl.BindTexture(gl.TEXTURE_2D, uint(textures[filter])) // based on filter
-
-	gl.Begin(gl.QUADS)
+// A vertex at x, y, z with normal nx, ny, nz and texture coordinates u, v
+func vertex(x, y, z, nx, ny, nz, u, v float32) render.Vertex {
+	return render.Vertex{X: x, Y: y, Z: z, NX: nx, NY: ny, NZ: nz, U: u, V: v}
+}
 
+// The textured cube, a quad for every face
+var cubeVertices = []render.Vertex{
 	// Front face
-	gl.Normal3f(0.0, 0.0, 1.0) // Normal Pointing Towards Viewer
-	gl.TexCoord2f(0.0, 1.0)
-	gl.Vertex3f(-1.0, -1.0, 1.0) // Bottom left
-	gl.TexCoord2f(1.0, 1.0)
-	gl.Vertex3f(1.0, -1.0, 1.0) // Bottom right
-	gl.TexCoord2f(1.0, 0.0)
-	gl.Vertex3f(1.0, 1.0, 1.0) // Top right
-	gl.TexCoord2f(0.0, 0.0)
-	gl.Vertex3f(-1.0, 1.0, 1.0) // Top left
+	vertex(-1.0, -1.0, 1.0, 0.0, 0.0, 1.0, 0.0, 1.0), // Bottom left
+	vertex(1.0, -1.0, 1.0, 0.0, 0.0, 1.0, 1.0, 1.0),  // Bottom right
+	vertex(1.0, 1.0, 1.0, 0.0, 0.0, 1.0, 1.0, 0.0),   // Top right
+	vertex(-1.0, 1.0, 1.0, 0.0, 0.0, 1.0, 0.0, 0.0),  // Top left
 
 	// Back Face
-	gl.Normal3f(0.0, 0.0, -1.0) // Normal Pointing Away From Viewer
-	gl.TexCoord2f(0.0, 0.0)
-	gl.Vertex3f(-1.0, -1.0, -1.0) // Bottom right
-	gl.TexCoord2f(0.0, 1.0)
-	gl.Vertex3f(-1.0, 1.0, -1.0) // Top right
-	gl.TexCoord2f(1.0, 1.0)
-	gl.Vertex3f(1.0, 1.0, -1.0) // Top left
-	gl.TexCoord2f(1.0, 0.0)
-	gl.Vertex3f(1.0, -1.0, -1.0) // Bottom left
+	vertex(-1.0, -1.0, -1.0, 0.0, 0.0, -1.0, 0.0, 0.0), // Bottom right
+	vertex(-1.0, 1.0, -1.0, 0.0, 0.0, -1.0, 0.0, 1.0),  // Top right
+	vertex(1.0, 1.0, -1.0, 0.0, 0.0, -1.0, 1.0, 1.0),   // Top left
+	vertex(1.0, -1.0, -1.0, 0.0, 0.0, -1.0, 1.0, 0.0),  // Bottom left
 
 	// Top Face
-	gl.Normal3f(0.0, 1.0, 0.0) // Normal Pointing Up
-	gl.TexCoord2f(1.0, 1.0)
-	gl.Vertex3f(-1.0, 1.0, -1.0) // Top left
-	gl.TexCoord2f(1.0, 0.0)
-	gl.Vertex3f(-1.0, 1.0, 1.0) // Bottom left
-	gl.TexCoord2f(0.0, 0.0)
-	gl.Vertex3f(1.0, 1.0, 1.0) // Bottom right
-	gl.TexCoord2f(0.0, 1.0)
-	gl.Vertex3f(1.0, 1.0, -1.0) // Top right
+	vertex(-1.0, 1.0, -1.0, 0.0, 1.0, 0.0, 1.0, 1.0), // Top left
+	vertex(-1.0, 1.0, 1.0, 0.0, 1.0, 0.0, 1.0, 0.0),  // Bottom left
+	vertex(1.0, 1.0, 1.0, 0.0, 1.0, 0.0, 0.0, 0.0),   // Bottom right
+	vertex(1.0, 1.0, -1.0, 0.0, 1.0, 0.0, 0.0, 1.0),  // Top right
 
 	// Bottom Face
-	gl.Normal3f(0.0, -1.0, 0.0) // Normal Pointing Down
-	gl.TexCoord2f(0.0, 1.0)
-	gl.Vertex3f(-1.0, -1.0, -1.0) // Top right
-	gl.TexCoord2f(1.0, 1.0)
-	gl.Vertex3f(1.0, -1.0, -1.0) // Top left
-	gl.TexCoord2f(1.0, 0.0)
-	gl.Vertex3f(1.0, -1.0, 1.0) // Bottom left
-	gl.TexCoord2f(0.0, 0.0)
-	gl.Vertex3f(-1.0, -1.0, 1.0) // Bottom right
+	vertex(-1.0, -1.0, -1.0, 0.0, -1.0, 0.0, 0.0, 1.0), // Top right
+	vertex(1.0, -1.0, -1.0, 0.0, -1.0, 0.0, 1.0, 1.0),  // Top left
+	vertex(1.0, -1.0, 1.0, 0.0, -1.0, 0.0, 1.0, 0.0),   // Bottom left
+	vertex(-1.0, -1.0, 1.0, 0.0, -1.0, 0.0, 0.0, 0.0),  // Bottom right
 
 	// Right face
-	gl.Normal3f(1.0, 0.0, 0.0) // Normal Pointing Right
-	gl.TexCoord2f(0.0, 0.0)
-	gl.Vertex3f(1.0, -1.0, -1.0) // Bottom right
-	gl.TexCoord2f(0.0, 1.0)
-	gl.Vertex3f(1.0, 1.0, -1.0) // Top right
-	gl.TexCoord2f(1.0, 1.0)
-	gl.Vertex3f(1.0, 1.0, 1.0) // Top left
-	gl.TexCoord2f(1.0, 0.0)
-	gl.Vertex3f(1.0, -1.0, 1.0) // Bottom left
+	vertex(1.0, -1.0, -1.0, 1.0, 0.0, 0.0, 0.0, 0.0), // Bottom right
+	vertex(1.0, 1.0, -1.0, 1.0, 0.0, 0.0, 0.0, 1.0),  // Top right
+	vertex(1.0, 1.0, 1.0, 1.0, 0.0, 0.0, 1.0, 1.0),   // Top left
+	vertex(1.0, -1.0, 1.0, 1.0, 0.0, 0.0, 1.0, 0.0),  // Bottom left
 
 	// Left Face
-	gl.Normal3f(-1.0, 0.0, 0.0) // Normal Pointing Left
-	gl.TexCoord2f(1.0, 0.0)
-	gl.Vertex3f(-1.0, -1.0, -1.0) // Bottom left
-	gl.TexCoord2f(0.0, 0.0)
-	gl.Vertex3f(-1.0, -1.0, 1.0) // Bottom right
-	gl.TexCoord2f(0.0, 1.0)
-	gl.Vertex3f(-1.0, 1.0, 1.0) // Top right
-	gl.TexCoord2f(1.0, 1.0)
-	gl.Vertex3f(-1.0, 1.0, -1.0) // Top left
-
-	gl.End()
+	vertex(-1.0, -1.0, -1.0, -1.0, 0.0, 0.0, 1.0, 0.0), // Bottom left
+	vertex(-1.0, -1.0, 1.0, -1.0, 0.0, 0.0, 0.0, 0.0),  // Bottom right
+	vertex(-1.0, 1.0, 1.0, -1.0, 0.0, 0.0, 0.0, 1.0),   // Top right
+	vertex(-1.0, 1.0, -1.0, -1.0, 0.0, 0.0, 1.0, 1.0),  // Top left
+}
+
+// Here goes our drawing code
+func drawGLScene() {
+	// Clear the screen and depth buffer
+	renderer.Clear()
+
+	// Move left 1.5 units and into the screen 6.0 units.
+	renderer.LoadIdentity()
+	if skybox != nil {
+		skybox.draw()
+	}
+	renderer.Translate(0.0, 0.0, z) // translate by z
+
+	renderer.Rotate(xrot, 1.0, 0.0, 0.0) /* Rotate On The X Axis */
+	renderer.Rotate(yrot, 0.0, 1.0, 0.0) /* Rotate On The Y Axis */
+
+	/* Select Our Texture */
+	renderer.BindTexture(textures[filter]) // based on filter
+
+	if phong {
+		lighting := 0
+		if light {
+			lighting = 1
+		}
+		shader.Use()
+		shader.Uniform("crate").Uniform1i(0)
+		shader.Uniform("lighting").Uniform1i(lighting)
+		shader.Uniform("shininess").Uniform1f(float32(shininess))
+		shader.Uniform("specular").Uniform1f(float32(specular))
+	}
+
+	renderer.Draw(cube)
+
+	if phong {
+		gl.ProgramUnuse()
+	}
 
 	sdl.GL_SwapBuffers()
 
@@ -345,6 +271,10 @@ func drawGLScene() {
 }
 
 func main() {
+	core := flag.Bool("core", false, "draw with shaders and buffers only, as an OpenGL core profile would")
+	skyboxPath := flag.String("skybox", "", "draw this skybox behind the cube, one image of the faces as a cross or six with * for their names")
+	flag.Parse()
+
 	// Initialize SDL
 	if sdl.Init(sdl.INIT_VIDEO) < 0 {
 		panic("Video initialization failed: " + sdl.GetError())
@@ -372,13 +302,47 @@ func main() {
 		Quit(1)
 	}
 
+	// Load the OpenGL functions newer than 1.1, which shaders need
+	functions := gl.Init() == 0
+
 	// When this function is finished, clean up and exit.
 	defer Quit(0)
 
+	// Everything but the per-pixel lighting is drawn through the renderer
+	// from here on
+	if *core {
+		renderer = glrender.NewCore()
+	} else {
+		renderer = glrender.NewLegacy()
+	}
+
 	LoadGLTextures("data/crate.bmp")
 
 	// Initialize OpenGL
 	initGL()
+
+	// The shaders for per-pixel lighting; we can do without them. They
+	// take their lights from the fixed-function pipeline, which the core
+	// renderer leaves alone.
+	var err error
+	if *core {
+		fmt.Println("warning: no per-pixel lighting with the core renderer")
+	} else if !functions {
+		fmt.Println("warning: no per-pixel lighting: loading OpenGL functions failed")
+	} else if shader, err = LoadShader("data/phong.vert", "data/phong.frag"); err != nil {
+		fmt.Println("warning: no per-pixel lighting:", err)
+	}
+
+	if *skyboxPath != "" {
+		if skybox, err = LoadSkybox(*skyboxPath); err != nil {
+			fmt.Println("Could not load the skybox:", err)
+			Quit(1)
+		}
+	}
+
+	// the cube never changes, so it is uploaded once
+	cube = renderer.NewMesh(render.PRIMITIVE_QUADS, cubeVertices)
+
 	// Resize the initial window
 	resizeWindow(SCREEN_WIDTH, SCREEN_HEIGHT)
 
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"github.com/banthar/gl"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// A Shader is a GLSL program linked from a vertex and a fragment shader
+// loaded from files. The locations of its uniforms are looked up the first
+// time they are used and kept.
+type Shader struct {
+	program  gl.Program
+	uniforms map[string]gl.UniformLocation
+}
+
+// Load, compile and link the shaders in two files. Errors point at the file
+// and line they are about.
+func LoadShader(vertexPath, fragmentPath string) (*Shader, error) {
+	vertex, err := compileShader(gl.VERTEX_SHADER, vertexPath)
+	if err != nil {
+		return nil, err
+	}
+	defer vertex.Delete()
+
+	fragment, err := compileShader(gl.FRAGMENT_SHADER, fragmentPath)
+	if err != nil {
+		return nil, err
+	}
+	defer fragment.Delete()
+
+	program := gl.CreateProgram()
+	program.AttachShader(vertex)
+	program.AttachShader(fragment)
+	program.Link()
+	if program.Get(gl.LINK_STATUS) == 0 {
+		log := program.GetInfoLog()
+		program.Delete()
+		return nil, fmt.Errorf("linking %s and %s:\n%s", vertexPath, fragmentPath, strings.TrimSpace(log))
+	}
+
+	return &Shader{program: program, uniforms: map[string]gl.UniformLocation{}}, nil
+}
+
+func compileShader(kind gl.GLenum, path string) (gl.Shader, error) {
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	shader := gl.CreateShader(kind)
+	shader.Source(string(source))
+	shader.Compile()
+	if shader.Get(gl.COMPILE_STATUS) == 0 {
+		log := shader.GetInfoLog()
+		shader.Delete()
+		return 0, fmt.Errorf("compiling %s:\n%s", path, shaderLog(path, log))
+	}
+	return shader, nil
+}
+
+// How drivers point at lines in their logs: "0:12(5): error: ..." (Mesa),
+// "ERROR: 0:12: ..." (AMD, Intel and Apple) and "0(12) : error ..." (NVIDIA)
+var logLines = []*regexp.Regexp{
+	regexp.MustCompile(`^\d+:(\d+)\(\d+\): (.*)$`),
+	regexp.MustCompile(`^(ERROR|WARNING): \d+:(\d+): (.*)$`),
+	regexp.MustCompile(`^\d+\((\d+)\) : (.*)$`),
+}
+
+// Rewrite a compile log so every message starts with file:line, like the
+// Go compiler's
+func shaderLog(path, log string) string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(log), "\n") {
+		line = strings.TrimSpace(line)
+		if m := logLines[0].FindStringSubmatch(line); m != nil {
+			line = fmt.Sprintf("%s:%s: %s", path, m[1], m[2])
+		} else if m := logLines[1].FindStringSubmatch(line); m != nil {
+			line = fmt.Sprintf("%s:%s: %s: %s", path, m[2], strings.ToLower(m[1]), m[3])
+		} else if m := logLines[2].FindStringSubmatch(line); m != nil {
+			line = fmt.Sprintf("%s:%s: %s", path, m[1], m[2])
+		} else if line != "" {
+			line = path + ": " + line
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Draw with the shader from now on
+func (s *Shader) Use() {
+	s.program.Use()
+}
+
+// The location of a uniform of the shader
+func (s *Shader) Uniform(name string) gl.UniformLocation {
+	location, ok := s.uniforms[name]
+	if !ok {
+		location = s.program.GetUniformLocation(name)
+		if location < 0 {
+			fmt.Println("warning: shader has no uniform", name)
+		}
+		s.uniforms[name] = location
+	}
+	return location
+}
+
+// Free the program
+func (s *Shader) Delete() {
+	s.program.Delete()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+)
+
+// Every driver's way of pointing at a line ends up as file:line
+func TestShaderLog(t *testing.T) {
+	tests := []struct {
+		name string
+		log  string
+		want string
+	}{
+		{"Mesa", "0:12(5): error: `normal' undeclared\n",
+			"phong.frag:12: error: `normal' undeclared"},
+		{"AMD", "ERROR: 0:7: 'vec5' : syntax error syntax error\n",
+			"phong.frag:7: error: 'vec5' : syntax error syntax error"},
+		{"NVIDIA", "0(31) : error C1008: undefined variable \"normal\"\n",
+			"phong.frag:31: error C1008: undefined variable \"normal\""},
+		{"warning and summary", "WARNING: 0:3: extension not supported\nERROR: 1 compilation errors.  No code generated.\n\n",
+			"phong.frag:3: warning: extension not supported\nphong.frag: ERROR: 1 compilation errors.  No code generated."},
+	}
+
+	for _, test := range tests {
+		if got := shaderLog("phong.frag", test.log); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
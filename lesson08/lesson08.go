@@ -1,11 +1,13 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/banthar/Go-SDL/sdl"
-	"github.com/banthar/gl"
-	"github.com/banthar/glu"
-	"math"
+	"github.com/manveru/opengl-go-tutorials/lesson08/render"
+	"github.com/manveru/opengl-go-tutorials/lesson08/render/glrender"
+	"github.com/manveru/opengl-go-tutorials/rgba"
+	"image/png"
 	"os"
 )
 
@@ -21,21 +23,31 @@ var (
 	surface    *sdl.Surface
 	t0, frames uint32 // used to calculate fps
 
-	light = false // Light is off at first
-	blend = false // Blending is off at first
-
-	xrot   gl.GLfloat        // X Rotation
-	yrot   gl.GLfloat        // Y Rotation
-	xspeed gl.GLfloat        // X Rotation Speed
-	yspeed gl.GLfloat        // Y Rotation Speed
-	z      gl.GLfloat = -5.0 // Depth Into The Screen
+	light         = false // Light is off at first
+	blend         = false // Blending is off at first
+	additive      = true  // Additive blending instead of alpha blending
+	premultiplied = false // Texture colors are multiplied by their alpha
+
+	xrot   float32        // X Rotation
+	yrot   float32        // Y Rotation
+	xspeed float32        // X Rotation Speed
+	yspeed float32        // Y Rotation Speed
+	z      float32 = -5.0 // Depth Into The Screen
+
+	light1 = render.Light{
+		Ambient:  [4]float32{0.5, 0.5, 0.5, 1.0}, // Ambient light values
+		Diffuse:  [4]float32{1.0, 1.0, 1.0, 1.0}, // Diffuse light values
+		Position: [4]float32{0.0, 0.0, 2.0, 1.0}, // Light position
+	}
 
-	lightAmbient  = [4]float32{0.5, 0.5, 0.5, 1.0} // Ambient light values
-	lightDiffuse  = [4]float32{1.0, 1.0, 1.0, 1.0} // Diffuse light values
-	lightPosition = [4]float32{0.0, 0.0, 2.0, 1.0} // Light position
+	renderer render.Renderer   // What we draw with
+	filter   int               // Which filter to use
+	textures [3]render.Texture // Storage for 3 textures
+	cube     render.Mesh
+	skybox   *Skybox // Drawn behind the cube, nil to clear to black
 
-	filter   gl.GLuint     // Which filter to use
-	textures [3]gl.Texture // Storage for 3 textures
+	alphaMode = rgba.ALPHA_LUMINANCE // How the glass gets its alpha
+	colorKey  = [3]uint8{0, 0, 0}    // Color made transparent by rgba.ALPHA_COLORKEY
 )
 
 // release/destroy our resources and restoring the old desktop
@@ -55,32 +67,13 @@ func resizeWindow(width, height int) {
 	}
 
 	// Setup our viewport
-	gl.Viewport(0, 0, int(width), int(height))
-
-	// change to the projection matrix and set our viewing volume.
-	gl.MatrixMode(gl.PROJECTION)
-	gl.LoadIdentity()
-
-	// aspect ratio
-	aspect := gl.GLdouble(gl.GLfloat(width) / gl.GLfloat(height))
-
-	// Set our perspective.
-	// This code is equivalent to using gluPerspective as in the original tutorial.
-	var fov, near, far gl.GLdouble
-	fov = 45.0
-	near = 0.1
-	far = 100.0
-	top := gl.GLdouble(math.Tan(float64(fov*math.Pi/360.0))) * near
-	bottom := -top
-	left := aspect * bottom
-	right := aspect * top
-	gl.Frustum(float64(left), float64(right), float64(bottom), float64(top), float64(near), float64(far))
-
-	// Make sure we're changing the model view and not the projection
-	gl.MatrixMode(gl.MODELVIEW)
+	renderer.Viewport(0, 0, width, height)
+
+	// Set our perspective, like gluPerspective does in the original tutorial
+	renderer.Perspective(45.0, float64(width)/float64(height), 0.1, 100.0)
 
 	// Reset the view
-	gl.LoadIdentity()
+	renderer.LoadIdentity()
 }
 
 // handle key press events
@@ -93,20 +86,42 @@ func handleKeyPress(keysym sdl.Keysym) {
 		light = !light
 		if light {
 			p("light on")
-			gl.Enable(gl.LIGHTING)
 		} else {
 			p("light off")
-			gl.Disable(gl.LIGHTING)
 		}
+		renderer.Lighting(light)
 	case sdl.K_b: // b key toggles blend
 		blend = !blend
-		if blend {
-			gl.Enable(gl.BLEND)
-			gl.Disable(gl.DEPTH_TEST)
+		renderer.Blending(blend)
+		renderer.DepthTest(!blend)
+	case sdl.K_a: // a key toggles between additive and alpha blending
+		additive = !additive
+		if additive {
+			p("additive blending")
 		} else {
-			gl.Disable(gl.BLEND)
-			gl.Enable(gl.DEPTH_TEST)
+			p("alpha blending")
 		}
+		setBlendFunc()
+	case sdl.K_m: // m key toggles premultiplied alpha, which needs new textures
+		premultiplied = !premultiplied
+		p("premultiplied alpha:", premultiplied)
+		for _, texture := range textures {
+			texture.Delete()
+		}
+		LoadGLTextures("data/glass.bmp", alphaMode)
+		setBlendFunc()
+	case sdl.K_k: // k key switches between luminance and color key alpha
+		if alphaMode == rgba.ALPHA_LUMINANCE {
+			alphaMode = rgba.ALPHA_COLORKEY
+			p("alpha from the color key")
+		} else {
+			alphaMode = rgba.ALPHA_LUMINANCE
+			p("alpha from the brightness")
+		}
+		for _, texture := range textures {
+			texture.Delete()
+		}
+		LoadGLTextures("data/glass.bmp", alphaMode)
 	case sdl.K_PAGEUP: // page up zooms into the scene
 		z -= 0.02
 	case sdl.K_PAGEDOWN: // zoom out of the scene
@@ -128,26 +143,41 @@ func handleKeyPress(keysym sdl.Keysym) {
 
 // general OpenGL initialization
 func initGL() {
-	gl.Enable(gl.TEXTURE_2D)
-	gl.ShadeModel(gl.SMOOTH)
-	gl.ClearColor(0.0, 0.0, 0.0, 0.5)
-	gl.ClearDepth(1.0)
-	gl.Enable(gl.DEPTH_TEST)
-	gl.DepthFunc(gl.LEQUAL)
-	gl.Hint(gl.PERSPECTIVE_CORRECTION_HINT, gl.NICEST)
-
-	// Setup the light
-	gl.Lightfv(gl.LIGHT1, gl.AMBIENT, lightAmbient[:])   // ambient lighting
-	gl.Lightfv(gl.LIGHT1, gl.DIFFUSE, lightDiffuse[:])   // make it diffuse
-	gl.Lightfv(gl.LIGHT1, gl.POSITION, lightPosition[:]) // and place it
-	gl.Enable(gl.LIGHT1)                                 // and finally turn it on.
-
-	gl.Color4f(1.0, 1.0, 1.0, 0.5)     // Full Brightness, 50% Alpha ( NEW )
-	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE) // Blending Function For Translucency Based On Source Alpha Value ( NEW )
+	renderer.Init()
+	renderer.ClearColor(0.0, 0.0, 0.0, 0.5)
+
+	// Setup the light and turn it on
+	renderer.Light(1, light1)
+
+	// Lighting and blending start out as the flags say
+	renderer.Lighting(light)
+	renderer.Blending(blend)
+	renderer.DepthTest(!blend)
+
+	setBlendFunc() // Blending Function For Translucency Based On Source Alpha Value ( NEW )
+}
+
+// Pick the blend function and color for the current blending mode.
+// Premultiplied textures already carry their alpha in the color, so the
+// source factor becomes ONE and the color has to be premultiplied as well.
+func setBlendFunc() {
+	src := render.BLEND_SRC_ALPHA
+	if premultiplied {
+		src = render.BLEND_ONE
+		renderer.Color(0.5, 0.5, 0.5, 0.5) // Half Brightness, 50% Alpha
+	} else {
+		renderer.Color(1.0, 1.0, 1.0, 0.5) // Full Brightness, 50% Alpha
+	}
+
+	if additive {
+		renderer.BlendFunc(src, render.BLEND_ONE)
+	} else {
+		renderer.BlendFunc(src, render.BLEND_ONE_MINUS_SRC_ALPHA)
+	}
 }
 
-// load in bitmap as a GL texture
-func LoadGLTextures(path string) {
+// load in bitmap as a GL texture, generating alpha as given by mode
+func LoadGLTextures(path string, mode int) {
 	image := sdl.Load(path)
 	if image == nil {
 		panic(sdl.GetError())
@@ -165,167 +195,73 @@ func LoadGLTextures(path string) {
 
 	// get the number of channels in the SDL surface
 	nOfColors := image.Format.BytesPerPixel
-	var textureFormat gl.GLenum
-
-	if nOfColors == 4 { // contains alpha channel
-		if image.Format.Rmask == 0x000000ff {
-			textureFormat = gl.RGBA
-		} else {
-			textureFormat = gl.BGRA
-		}
-	} else if nOfColors == 3 { // no alpha channel
-		if image.Format.Rmask == 0x000000ff {
-			textureFormat = gl.RGB
-		} else {
-			textureFormat = gl.BGR
-		}
-	} else {
+	if nOfColors != 4 && nOfColors != 3 {
 		fmt.Println("warning:", path, "is not truecolor, this will probably break")
 	}
 
-	// Create the textures
-	gl.GenTextures(textures[:])
-
-	// First texture
-	gl.BindTexture(gl.TEXTURE_2D, uint(textures[0]))
-	gl.TexImage2D(
-		gl.TEXTURE_2D,
-		0,
-		3,
-		int(image.W),
-		int(image.H),
-		0,
-		textureFormat,
-		gl.UNSIGNED_BYTE,
-		image.Pixels,
-	)
-
-	// linear filtering
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
-
-	// Second texture
-	gl.BindTexture(gl.TEXTURE_2D, uint(textures[1]))
-	gl.TexImage2D(gl.TEXTURE_2D, 0,
-		3,
-		int(image.W),
-		int(image.H),
-		0, textureFormat, gl.UNSIGNED_BYTE,
-		image.Pixels,
-	)
-
-	// Mipmapped filtering
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
-
-	// Third texture
-	gl.BindTexture(gl.TEXTURE_2D, uint(textures[2]))
-	gl.TexImage2D(gl.TEXTURE_2D, 0,
-		3,
-		int(image.W),
-		int(image.H),
-		0, textureFormat, gl.UNSIGNED_BYTE,
-		image.Pixels,
-	)
-
-	// Mipmapped filtering
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR_MIPMAP_NEAREST)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
-
-	glu.Build2DMipmaps(
-		gl.TEXTURE_2D,
-		3,
-		int(image.W),
-		int(image.H),
-		textureFormat,
-		image.Pixels,
-	)
-}
+	// we always hand RGBA to GL, so we can fill in the alpha ourselves
+	pixels := rgba.FromSurface(image)
 
-// Here goes our drawing code
-func drawGLScene() {
-	// Clear the screen and depth buffer
-	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
-
-	// Move left 1.5 units and into the screen 6.0 units.
-	gl.LoadIdentity()
-	gl.Translatef(0.0, 0.0, float32(z)) // translate by z
+	rgba.GenerateAlpha(pixels, mode, colorKey)
 
-	gl.Rotatef(float32(xrot), 1.0, 0.0, 0.0) /* Rotate On The X Axis */
-	gl.Rotatef(float32(yrot), 0.0, 1.0, 0.0) /* Rotate On The Y Axis */
+	if premultiplied {
+		rgba.Premultiply(pixels)
+	}
 
-	/* Select Our Texture */
-	gl.BindTexture(gl.TEXTURE_2D, uint(textures[filter])) // based on filter
+	// Create the textures: nearest, linear and mipmapped filtering
+	width, height := int(image.W), int(image.H)
+	textures[0] = renderer.NewTexture(pixels, width, height, render.FILTER_NEAREST)
+	textures[1] = renderer.NewTexture(pixels, width, height, render.FILTER_LINEAR)
+	textures[2] = renderer.NewTexture(pixels, width, height, render.FILTER_MIPMAP)
+}
 
-	gl.Begin(gl.QUADS)
+// A vertex at x, y, z with normal nx, ny, nz and texture coordinates u, v
+func vertex(x, y, z, nx, ny, nz, u, v float32) render.Vertex {
+	return render.Vertex{X: x, Y: y, Z: z, NX: nx, NY: ny, NZ: nz, U: u, V: v}
+}
 
+// The textured cube, a quad for every face
+var cubeVertices = []render.Vertex{
 	// Front face
-	gl.Normal3f(0.0, 0.0, 1.0) // Normal Pointing Towards Viewer
-	gl.TexCoord2f(0.0, 1.0)
-	gl.Vertex3f(-1.0, -1.0, 1.0) // Bottom left
-	gl.TexCoord2f(1.0, 1.0)
-	gl.Vertex3f(1.0, -1.0, 1.0) // Bottom right
-	gl.TexCoord2f(1.0, 0.0)
-	gl.Vertex3f(1.0, 1.0, 1.0) // Top right
-	gl.TexCoord2f(0.0, 0.0)
-	gl.Vertex3f(-1.0, 1.0, 1.0) // Top left
+	vertex(-1.0, -1.0, 1.0, 0.0, 0.0, 1.0, 0.0, 1.0), // Bottom left
+	vertex(1.0, -1.0, 1.0, 0.0, 0.0, 1.0, 1.0, 1.0),  // Bottom right
+	vertex(1.0, 1.0, 1.0, 0.0, 0.0, 1.0, 1.0, 0.0),   // Top right
+	vertex(-1.0, 1.0, 1.0, 0.0, 0.0, 1.0, 0.0, 0.0),  // Top left
 
 	// Back Face
-	gl.Normal3f(0.0, 0.0, -1.0) // Normal Pointing Away From Viewer
-	gl.TexCoord2f(0.0, 0.0)
-	gl.Vertex3f(-1.0, -1.0, -1.0) // Bottom right
-	gl.TexCoord2f(0.0, 1.0)
-	gl.Vertex3f(-1.0, 1.0, -1.0) // Top right
-	gl.TexCoord2f(1.0, 1.0)
-	gl.Vertex3f(1.0, 1.0, -1.0) // Top left
-	gl.TexCoord2f(1.0, 0.0)
-	gl.Vertex3f(1.0, -1.0, -1.0) // Bottom left
+	vertex(-1.0, -1.0, -1.0, 0.0, 0.0, -1.0, 0.0, 0.0), // Bottom right
+	vertex(-1.0, 1.0, -1.0, 0.0, 0.0, -1.0, 0.0, 1.0),  // Top right
+	vertex(1.0, 1.0, -1.0, 0.0, 0.0, -1.0, 1.0, 1.0),   // Top left
+	vertex(1.0, -1.0, -1.0, 0.0, 0.0, -1.0, 1.0, 0.0),  // Bottom left
 
 	// Top Face
-	gl.Normal3f(0.0, 1.0, 0.0) // Normal Pointing Up
-	gl.TexCoord2f(1.0, 1.0)
-	gl.Vertex3f(-1.0, 1.0, -1.0) // Top left
-	gl.TexCoord2f(1.0, 0.0)
-	gl.Vertex3f(-1.0, 1.0, 1.0) // Bottom left
-	gl.TexCoord2f(0.0, 0.0)
-	gl.Vertex3f(1.0, 1.0, 1.0) // Bottom right
-	gl.TexCoord2f(0.0, 1.0)
-	gl.Vertex3f(1.0, 1.0, -1.0) // Top right
+	vertex(-1.0, 1.0, -1.0, 0.0, 1.0, 0.0, 1.0, 1.0), // Top left
+	vertex(-1.0, 1.0, 1.0, 0.0, 1.0, 0.0, 1.0, 0.0),  // Bottom left
+	vertex(1.0, 1.0, 1.0, 0.0, 1.0, 0.0, 0.0, 0.0),   // Bottom right
+	vertex(1.0, 1.0, -1.0, 0.0, 1.0, 0.0, 0.0, 1.0),  // Top right
 
 	// Bottom Face
-	gl.Normal3f(0.0, -1.0, 0.0) // Normal Pointing Down
-	gl.TexCoord2f(0.0, 1.0)
-	gl.Vertex3f(-1.0, -1.0, -1.0) // Top right
-	gl.TexCoord2f(1.0, 1.0)
-	gl.Vertex3f(1.0, -1.0, -1.0) // Top left
-	gl.TexCoord2f(1.0, 0.0)
-	gl.Vertex3f(1.0, -1.0, 1.0) // Bottom left
-	gl.TexCoord2f(0.0, 0.0)
-	gl.Vertex3f(-1.0, -1.0, 1.0) // Bottom right
+	vertex(-1.0, -1.0, -1.0, 0.0, -1.0, 0.0, 0.0, 1.0), // Top right
+	vertex(1.0, -1.0, -1.0, 0.0, -1.0, 0.0, 1.0, 1.0),  // Top left
+	vertex(1.0, -1.0, 1.0, 0.0, -1.0, 0.0, 1.0, 0.0),   // Bottom left
+	vertex(-1.0, -1.0, 1.0, 0.0, -1.0, 0.0, 0.0, 0.0),  // Bottom right
 
 	// Right face
-	gl.Normal3f(1.0, 0.0, 0.0) // Normal Pointing Right
-	gl.TexCoord2f(0.0, 0.0)
-	gl.Vertex3f(1.0, -1.0, -1.0) // Bottom right
-	gl.TexCoord2f(0.0, 1.0)
-	gl.Vertex3f(1.0, 1.0, -1.0) // Top right
-	gl.TexCoord2f(1.0, 1.0)
-	gl.Vertex3f(1.0, 1.0, 1.0) // Top left
-	gl.TexCoord2f(1.0, 0.0)
-	gl.Vertex3f(1.0, -1.0, 1.0) // Bottom left
+	vertex(1.0, -1.0, -1.0, 1.0, 0.0, 0.0, 0.0, 0.0), // Bottom right
+	vertex(1.0, 1.0, -1.0, 1.0, 0.0, 0.0, 0.0, 1.0),  // Top right
+	vertex(1.0, 1.0, 1.0, 1.0, 0.0, 0.0, 1.0, 1.0),   // Top left
+	vertex(1.0, -1.0, 1.0, 1.0, 0.0, 0.0, 1.0, 0.0),  // Bottom left
 
 	// Left Face
-	gl.Normal3f(-1.0, 0.0, 0.0) // Normal Pointing Left
-	gl.TexCoord2f(1.0, 0.0)
-	gl.Vertex3f(-1.0, -1.0, -1.0) // Bottom left
-	gl.TexCoord2f(0.0, 0.0)
-	gl.Vertex3f(-1.0, -1.0, 1.0) // Bottom right
-	gl.TexCoord2f(0.0, 1.0)
-	gl.Vertex3f(-1.0, 1.0, 1.0) // Top right
-	gl.TexCoord2f(1.0, 1.0)
-	gl.Vertex3f(-1.0, 1.0, -1.0) // Top left
-
-	gl.End()
+	vertex(-1.0, -1.0, -1.0, -1.0, 0.0, 0.0, 1.0, 0.0), // Bottom left
+	vertex(-1.0, -1.0, 1.0, -1.0, 0.0, 0.0, 0.0, 0.0),  // Bottom right
+	vertex(-1.0, 1.0, 1.0, -1.0, 0.0, 0.0, 0.0, 1.0),   // Top right
+	vertex(-1.0, 1.0, -1.0, -1.0, 0.0, 0.0, 1.0, 1.0),  // Top left
+}
+
+// Here goes our drawing code
+func drawGLScene() {
+	drawScene()
 
 	sdl.GL_SwapBuffers()
 
@@ -344,7 +280,75 @@ func drawGLScene() {
 	}
 }
 
+// Draw a frame of the scene with the renderer
+func drawScene() {
+	// Clear the screen and depth buffer
+	renderer.Clear()
+
+	// Move left 1.5 units and into the screen 6.0 units.
+	renderer.LoadIdentity()
+	if skybox != nil {
+		skybox.draw()
+	}
+	renderer.Translate(0.0, 0.0, z) // translate by z
+
+	renderer.Rotate(xrot, 1.0, 0.0, 0.0) /* Rotate On The X Axis */
+	renderer.Rotate(yrot, 0.0, 1.0, 0.0) /* Rotate On The Y Axis */
+
+	/* Select Our Texture */
+	renderer.BindTexture(textures[filter]) // based on filter
+
+	renderer.Draw(cube)
+}
+
+// Draw a frame with the software renderer and write it to a PNG file,
+// without a window or graphics card. The cube is turned a little, so more
+// than its front shows.
+func renderSoftware(path, skyboxPath string) error {
+	software := render.NewSoftwareRenderer(SCREEN_WIDTH, SCREEN_HEIGHT)
+	renderer = software
+
+	LoadGLTextures("data/glass.bmp", alphaMode)
+	initGL()
+	if skyboxPath != "" {
+		var err error
+		if skybox, err = LoadSkybox(skyboxPath); err != nil {
+			return err
+		}
+	}
+	cube = renderer.NewMesh(render.PRIMITIVE_QUADS, cubeVertices)
+	resizeWindow(SCREEN_WIDTH, SCREEN_HEIGHT)
+
+	xrot, yrot = 30.0, 30.0
+	drawScene()
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, software.Image()); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
 func main() {
+	core := flag.Bool("core", false, "draw with shaders and buffers only, as an OpenGL core profile would")
+	software := flag.String("software", "", "draw a frame without OpenGL to this PNG file, and exit")
+	flag.BoolVar(&light, "light", light, "start with the light on")
+	flag.BoolVar(&blend, "blend", blend, "start with blending on")
+	skyboxPath := flag.String("skybox", "", "draw this skybox behind the cube, one image of the faces as a cross or six with * for their names")
+	flag.Parse()
+
+	if *software != "" {
+		if err := renderSoftware(*software, *skyboxPath); err != nil {
+			fmt.Println("Could not draw with the software renderer:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Initialize SDL
 	if sdl.Init(sdl.INIT_VIDEO) < 0 {
 		panic("Video initialization failed: " + sdl.GetError())
@@ -375,11 +379,30 @@ func main() {
 	// When this function is finished, clean up and exit.
 	defer Quit(0)
 
-	LoadGLTextures("data/glass.bmp")
+	// Everything is drawn through the renderer from here on
+	if *core {
+		renderer = glrender.NewCore()
+	} else {
+		renderer = glrender.NewLegacy()
+	}
+
+	// the glass gets its alpha from how bright it is
+	LoadGLTextures("data/glass.bmp", alphaMode)
 
 	// Initialize OpenGL
 	initGL()
 
+	if *skyboxPath != "" {
+		var err error
+		if skybox, err = LoadSkybox(*skyboxPath); err != nil {
+			fmt.Println("Could not load the skybox:", err)
+			Quit(1)
+		}
+	}
+
+	// the cube never changes, so it is uploaded once
+	cube = renderer.NewMesh(render.PRIMITIVE_QUADS, cubeVertices)
+
 	// Resize the initial window
 	resizeWindow(SCREEN_WIDTH, SCREEN_HEIGHT)
 
@@ -0,0 +1,347 @@
+package glrender
+
+import (
+	"fmt"
+	"github.com/banthar/gl"
+	"github.com/manveru/opengl-go-tutorials/lesson08/render"
+	"unsafe"
+)
+
+// The core renderer only uses what an OpenGL 3.2 core profile has:
+// shaders, vertex buffers and vertex array objects, with the matrices and
+// lighting of the fixed-function pipeline done by itself. SDL 1.2 can't
+// ask for a core profile, so it runs in the context SDL gives, but doesn't
+// touch anything a core profile would lack.
+type coreRenderer struct {
+	program  gl.Program
+	uniforms map[string]gl.UniformLocation
+
+	projection render.Mat4
+	modelview  []render.Mat4 // the stack, the current matrix last
+
+	color    [4]float32
+	lighting bool
+	lights   [render.MAX_LIGHTS]*render.Light // placed in eye space, nil when off
+}
+
+type coreTexture gl.Texture
+
+type coreMesh struct {
+	vertexArray  gl.VertexArray
+	vertexBuffer gl.Buffer
+	indexBuffer  gl.Buffer
+	count        int
+}
+
+// where the attributes of a vertex are passed to the vertex shader
+const (
+	ATTRIB_POSITION = iota
+	ATTRIB_NORMAL
+	ATTRIB_TEXCOORD
+)
+
+// Lighting happens per vertex, like in the fixed-function pipeline, with
+// the material OpenGL starts out with
+const coreVertexShader = `
+#version 150
+
+const int MAX_LIGHTS = 8;
+const vec3 materialAmbient = vec3(0.2);
+const vec4 materialDiffuse = vec4(0.8, 0.8, 0.8, 1.0);
+const vec3 sceneAmbient = vec3(0.2);
+
+uniform mat4 projection;
+uniform mat4 modelview;
+uniform mat3 normalMatrix;
+uniform vec4 color;
+uniform bool lighting;
+uniform bool lightOn[MAX_LIGHTS];
+uniform vec3 lightAmbient[MAX_LIGHTS];
+uniform vec3 lightDiffuse[MAX_LIGHTS];
+uniform vec4 lightPosition[MAX_LIGHTS]; // in eye space
+
+in vec3 position;
+in vec3 normal;
+in vec2 texCoord;
+
+out vec4 shade;
+out vec2 uv;
+
+void main()
+{
+	vec4 eye = modelview * vec4(position, 1.0);
+	gl_Position = projection * eye;
+	uv = texCoord;
+
+	if (!lighting) {
+		shade = color;
+		return;
+	}
+
+	vec3 n = normalize(normalMatrix * normal);
+	vec3 lit = sceneAmbient * materialAmbient;
+	for (int i = 0; i < MAX_LIGHTS; i++) {
+		if (!lightOn[i]) {
+			continue;
+		}
+		vec3 toLight = lightPosition[i].xyz - eye.xyz * lightPosition[i].w;
+		float diffuse = max(dot(n, normalize(toLight)), 0.0);
+		lit += lightAmbient[i] * materialAmbient + lightDiffuse[i] * materialDiffuse.rgb * diffuse;
+	}
+	shade = vec4(min(lit, vec3(1.0)), materialDiffuse.a);
+}
+`
+
+const coreFragmentShader = `
+#version 150
+
+uniform sampler2D tex;
+
+in vec4 shade;
+in vec2 uv;
+
+out vec4 fragColor;
+
+void main()
+{
+	fragColor = shade * texture(tex, uv);
+}
+`
+
+// A renderer on shaders and buffers alone. It panics if the OpenGL
+// functions or its shaders don't load.
+func NewCore() render.Renderer {
+	if gl.Init() != 0 {
+		panic("Loading OpenGL functions failed")
+	}
+
+	program := gl.CreateProgram()
+	program.AttachShader(compileCoreShader(gl.VERTEX_SHADER, "vertex", coreVertexShader))
+	program.AttachShader(compileCoreShader(gl.FRAGMENT_SHADER, "fragment", coreFragmentShader))
+	program.BindAttribLocation(ATTRIB_POSITION, "position")
+	program.BindAttribLocation(ATTRIB_NORMAL, "normal")
+	program.BindAttribLocation(ATTRIB_TEXCOORD, "texCoord")
+	program.Link()
+	if program.Get(gl.LINK_STATUS) == 0 {
+		panic("Linking the core renderer's shaders failed:\n" + program.GetInfoLog())
+	}
+
+	return &coreRenderer{
+		program:    program,
+		uniforms:   map[string]gl.UniformLocation{},
+		projection: render.Identity(),
+		modelview:  []render.Mat4{render.Identity()},
+		color:      [4]float32{1, 1, 1, 1},
+	}
+}
+
+func compileCoreShader(kind gl.GLenum, name, source string) gl.Shader {
+	shader := gl.CreateShader(kind)
+	shader.Source(source)
+	shader.Compile()
+	if shader.Get(gl.COMPILE_STATUS) == 0 {
+		panic("Compiling the core renderer's " + name + " shader failed:\n" + shader.GetInfoLog())
+	}
+	return shader
+}
+
+// The location of a uniform, looked up once
+func (r *coreRenderer) uniform(name string) gl.UniformLocation {
+	location, ok := r.uniforms[name]
+	if !ok {
+		location = r.program.GetUniformLocation(name)
+		r.uniforms[name] = location
+	}
+	return location
+}
+
+func (r *coreRenderer) Init() {
+	r.program.Use()
+	r.uniform("tex").Uniform1i(0)
+	gl.Enable(gl.DEPTH_TEST)
+	gl.DepthFunc(gl.LEQUAL)
+}
+
+func (r *coreRenderer) ClearColor(red, green, blue, alpha float32) {
+	gl.ClearColor(gl.GLclampf(red), gl.GLclampf(green), gl.GLclampf(blue), gl.GLclampf(alpha))
+}
+
+func (r *coreRenderer) Clear() {
+	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+}
+
+func (r *coreRenderer) Viewport(x, y, width, height int) {
+	gl.Viewport(x, y, width, height)
+}
+
+func (r *coreRenderer) Perspective(fovy, aspect, near, far float64) {
+	left, right, bottom, top := render.PerspectiveBounds(fovy, aspect, near, far)
+	r.projection = render.Frustum(left, right, bottom, top, near, far)
+}
+
+// the modelview matrix on top of the stack
+func (r *coreRenderer) top() *render.Mat4 {
+	return &r.modelview[len(r.modelview)-1]
+}
+
+func (r *coreRenderer) LoadIdentity() {
+	*r.top() = render.Identity()
+}
+
+func (r *coreRenderer) Translate(x, y, z float32) {
+	*r.top() = r.top().Mul(render.Translation(x, y, z))
+}
+
+func (r *coreRenderer) Rotate(angle, x, y, z float32) {
+	*r.top() = r.top().Mul(render.Rotation(angle, x, y, z))
+}
+
+func (r *coreRenderer) PushMatrix() {
+	r.modelview = append(r.modelview, *r.top())
+}
+
+func (r *coreRenderer) PopMatrix() {
+	if len(r.modelview) > 1 {
+		r.modelview = r.modelview[:len(r.modelview)-1]
+	}
+}
+
+func (r *coreRenderer) NewTexture(pixels []byte, width, height, filter int) render.Texture {
+	texture := gl.GenTexture()
+	texture.Bind(gl.TEXTURE_2D)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA, width, height, 0, gl.RGBA, gl.UNSIGNED_BYTE, pixels)
+
+	switch filter {
+	case render.FILTER_NEAREST:
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	case render.FILTER_LINEAR:
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	case render.FILTER_MIPMAP:
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR_MIPMAP_NEAREST)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+		gl.GenerateMipmap(gl.TEXTURE_2D)
+	}
+
+	return coreTexture(texture)
+}
+
+func (t coreTexture) Delete() {
+	gl.Texture(t).Delete()
+}
+
+func (r *coreRenderer) BindTexture(texture render.Texture) {
+	gl.Texture(texture.(coreTexture)).Bind(gl.TEXTURE_2D)
+}
+
+// Core profiles have no quads, so they are drawn as two triangles each,
+// sharing the vertices through the index buffer
+func (r *coreRenderer) NewMesh(primitive int, vertices []render.Vertex) render.Mesh {
+	var indices []uint32
+	if primitive == render.PRIMITIVE_QUADS {
+		for i := uint32(0); i+3 < uint32(len(vertices)); i += 4 {
+			indices = append(indices, i, i+1, i+2, i, i+2, i+3)
+		}
+	} else {
+		for i := range vertices {
+			indices = append(indices, uint32(i))
+		}
+	}
+
+	m := coreMesh{
+		vertexArray:  gl.GenVertexArray(),
+		vertexBuffer: gl.GenBuffer(),
+		indexBuffer:  gl.GenBuffer(),
+		count:        len(indices),
+	}
+	if len(indices) == 0 {
+		return m
+	}
+
+	// the vertex array remembers the buffers and where the attributes are
+	m.vertexArray.Bind()
+
+	stride := int(unsafe.Sizeof(render.Vertex{}))
+	m.vertexBuffer.Bind(gl.ARRAY_BUFFER)
+	gl.BufferData(gl.ARRAY_BUFFER, len(vertices)*stride, vertices, gl.STATIC_DRAW)
+
+	position := gl.AttribLocation(ATTRIB_POSITION)
+	position.AttribPointer(3, gl.FLOAT, false, stride, unsafe.Offsetof(render.Vertex{}.X))
+	position.EnableArray()
+	normal := gl.AttribLocation(ATTRIB_NORMAL)
+	normal.AttribPointer(3, gl.FLOAT, false, stride, unsafe.Offsetof(render.Vertex{}.NX))
+	normal.EnableArray()
+	texCoord := gl.AttribLocation(ATTRIB_TEXCOORD)
+	texCoord.AttribPointer(2, gl.FLOAT, false, stride, unsafe.Offsetof(render.Vertex{}.U))
+	texCoord.EnableArray()
+
+	m.indexBuffer.Bind(gl.ELEMENT_ARRAY_BUFFER)
+	gl.BufferData(gl.ELEMENT_ARRAY_BUFFER, len(indices)*4, indices, gl.STATIC_DRAW)
+
+	m.vertexArray.Unbind()
+	return m
+}
+
+func (m coreMesh) Delete() {
+	m.vertexArray.Delete()
+	m.vertexBuffer.Delete()
+	m.indexBuffer.Delete()
+}
+
+func (r *coreRenderer) Draw(mesh render.Mesh) {
+	m := mesh.(coreMesh)
+	if m.count == 0 {
+		return
+	}
+
+	modelview := *r.top()
+	r.uniform("projection").UniformMatrix4fv(false, r.projection)
+	r.uniform("modelview").UniformMatrix4fv(false, modelview)
+	r.uniform("normalMatrix").UniformMatrix3fv(false, modelview.NormalMatrix())
+	r.uniform("color").Uniform4f(r.color[0], r.color[1], r.color[2], r.color[3])
+	r.uniform("lighting").Uniform1i(boolInt(r.lighting))
+
+	if r.lighting {
+		for i, light := range r.lights {
+			r.uniform(fmt.Sprintf("lightOn[%d]", i)).Uniform1i(boolInt(light != nil))
+			if light == nil {
+				continue
+			}
+			a, d, p := light.Ambient, light.Diffuse, light.Position
+			r.uniform(fmt.Sprintf("lightAmbient[%d]", i)).Uniform3f(a[0], a[1], a[2])
+			r.uniform(fmt.Sprintf("lightDiffuse[%d]", i)).Uniform3f(d[0], d[1], d[2])
+			r.uniform(fmt.Sprintf("lightPosition[%d]", i)).Uniform4f(p[0], p[1], p[2], p[3])
+		}
+	}
+
+	m.vertexArray.Bind()
+	gl.DrawElements(gl.TRIANGLES, m.count, gl.UNSIGNED_INT, uintptr(0))
+	m.vertexArray.Unbind()
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (r *coreRenderer) Color(red, green, blue, alpha float32) {
+	r.color = [4]float32{red, green, blue, alpha}
+}
+
+// Lights are placed with the modelview matrix they are set with, like
+// glLight places them
+func (r *coreRenderer) Light(i int, light render.Light) {
+	light.Position = r.top().Transform(light.Position)
+	r.lights[i] = &light
+}
+
+func (r *coreRenderer) Lighting(on bool)  { r.lighting = on }
+func (r *coreRenderer) Blending(on bool)  { enable(gl.BLEND, on) }
+func (r *coreRenderer) DepthTest(on bool) { enable(gl.DEPTH_TEST, on) }
+
+func (r *coreRenderer) BlendFunc(src, dst int) {
+	gl.BlendFunc(blendFactors[src], blendFactors[dst])
+}
@@ -0,0 +1,156 @@
+// Package glrender draws with OpenGL through the render.Renderer of
+// lessons 06 to 09: the legacy renderer on the fixed-function pipeline, and
+// the core renderer on what an OpenGL 3.2 core profile has. Each needs the
+// context SDL made before it is created.
+package glrender
+
+import (
+	"github.com/banthar/gl"
+	"github.com/banthar/glu"
+	"github.com/manveru/opengl-go-tutorials/displaylist"
+	"github.com/manveru/opengl-go-tutorials/lesson08/render"
+)
+
+// The legacy renderer hands everything to the fixed-function pipeline, as
+// the lessons did before there were renderers. Meshes are compiled into
+// display lists.
+type legacyRenderer struct{}
+
+type legacyTexture gl.Texture
+
+type legacyMesh struct {
+	list *displaylist.List
+}
+
+// A renderer on the fixed-function pipeline
+func NewLegacy() render.Renderer {
+	return legacyRenderer{}
+}
+
+func (legacyRenderer) Init() {
+	gl.Enable(gl.TEXTURE_2D)
+	gl.ShadeModel(gl.SMOOTH)
+	gl.ClearDepth(1.0)
+	gl.Enable(gl.DEPTH_TEST)
+	gl.DepthFunc(gl.LEQUAL)
+	gl.Hint(gl.PERSPECTIVE_CORRECTION_HINT, gl.NICEST)
+}
+
+func (legacyRenderer) ClearColor(r, g, b, a float32) {
+	gl.ClearColor(gl.GLclampf(r), gl.GLclampf(g), gl.GLclampf(b), gl.GLclampf(a))
+}
+
+func (legacyRenderer) Clear() {
+	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+}
+
+func (legacyRenderer) Viewport(x, y, width, height int) {
+	gl.Viewport(x, y, width, height)
+}
+
+func (legacyRenderer) Perspective(fovy, aspect, near, far float64) {
+	gl.MatrixMode(gl.PROJECTION)
+	gl.LoadIdentity()
+	left, right, bottom, top := render.PerspectiveBounds(fovy, aspect, near, far)
+	gl.Frustum(left, right, bottom, top, near, far)
+	gl.MatrixMode(gl.MODELVIEW)
+}
+
+func (legacyRenderer) LoadIdentity()                 { gl.LoadIdentity() }
+func (legacyRenderer) Translate(x, y, z float32)     { gl.Translatef(x, y, z) }
+func (legacyRenderer) Rotate(angle, x, y, z float32) { gl.Rotatef(angle, x, y, z) }
+func (legacyRenderer) PushMatrix()                   { gl.PushMatrix() }
+func (legacyRenderer) PopMatrix()                    { gl.PopMatrix() }
+
+func (legacyRenderer) NewTexture(pixels []byte, width, height, filter int) render.Texture {
+	texture := gl.GenTexture()
+	texture.Bind(gl.TEXTURE_2D)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, 4, width, height, 0, gl.RGBA, gl.UNSIGNED_BYTE, pixels)
+
+	switch filter {
+	case render.FILTER_NEAREST:
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	case render.FILTER_LINEAR:
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	case render.FILTER_MIPMAP:
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR_MIPMAP_NEAREST)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+		glu.Build2DMipmaps(gl.TEXTURE_2D, 4, width, height, gl.RGBA, pixels)
+	}
+
+	return legacyTexture(texture)
+}
+
+func (t legacyTexture) Delete() {
+	gl.Texture(t).Delete()
+}
+
+func (legacyRenderer) BindTexture(texture render.Texture) {
+	gl.Texture(texture.(legacyTexture)).Bind(gl.TEXTURE_2D)
+}
+
+func (legacyRenderer) NewMesh(primitive int, vertices []render.Vertex) render.Mesh {
+	mode := gl.GLenum(gl.TRIANGLES)
+	if primitive == render.PRIMITIVE_QUADS {
+		mode = gl.QUADS
+	}
+
+	draw := func() {
+		gl.Begin(mode)
+		for _, v := range vertices {
+			gl.Normal3f(v.NX, v.NY, v.NZ)
+			gl.TexCoord2f(v.U, v.V)
+			gl.Vertex3f(v.X, v.Y, v.Z)
+		}
+		gl.End()
+	}
+	// the vertices never change and the texture is bound outside of the
+	// list, so it is only compiled once, reloaded textures and all
+	return legacyMesh{displaylist.New(draw, nil)}
+}
+
+func (m legacyMesh) Delete() {
+	m.list.Delete()
+}
+
+func (legacyRenderer) Draw(mesh render.Mesh) {
+	mesh.(legacyMesh).list.Call()
+}
+
+func (legacyRenderer) Color(r, g, b, a float32) {
+	gl.Color4f(r, g, b, a)
+}
+
+func (legacyRenderer) Light(i int, light render.Light) {
+	id := gl.GLenum(gl.LIGHT0 + i)
+	gl.Lightfv(id, gl.AMBIENT, light.Ambient[:])
+	gl.Lightfv(id, gl.DIFFUSE, light.Diffuse[:])
+	gl.Lightfv(id, gl.POSITION, light.Position[:])
+	gl.Enable(id)
+}
+
+func (legacyRenderer) Lighting(on bool)  { enable(gl.LIGHTING, on) }
+func (legacyRenderer) Blending(on bool)  { enable(gl.BLEND, on) }
+func (legacyRenderer) DepthTest(on bool) { enable(gl.DEPTH_TEST, on) }
+
+func (legacyRenderer) BlendFunc(src, dst int) {
+	gl.BlendFunc(blendFactors[src], blendFactors[dst])
+}
+
+// the BLEND_ factors as OpenGL knows them
+var blendFactors = []gl.GLenum{
+	render.BLEND_ZERO:                gl.ZERO,
+	render.BLEND_ONE:                 gl.ONE,
+	render.BLEND_SRC_ALPHA:           gl.SRC_ALPHA,
+	render.BLEND_ONE_MINUS_SRC_ALPHA: gl.ONE_MINUS_SRC_ALPHA,
+}
+
+func enable(capability gl.GLenum, on bool) {
+	if on {
+		gl.Enable(capability)
+	} else {
+		gl.Disable(capability)
+	}
+}
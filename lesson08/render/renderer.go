@@ -0,0 +1,187 @@
+// Package render is what lessons 06 to 09 draw with: a Renderer interface
+// that OpenGL is one way to implement, and a software renderer that needs
+// no OpenGL at all. The OpenGL renderers are in render/glrender.
+package render
+
+import (
+	"math"
+)
+
+// What a mesh is made of
+const (
+	PRIMITIVE_TRIANGLES = iota
+	PRIMITIVE_QUADS
+)
+
+// How textures are filtered
+const (
+	FILTER_NEAREST = iota
+	FILTER_LINEAR
+	FILTER_MIPMAP // linear between the texels of the nearest mipmap
+)
+
+// Factors of the blend function
+const (
+	BLEND_ZERO = iota
+	BLEND_ONE
+	BLEND_SRC_ALPHA
+	BLEND_ONE_MINUS_SRC_ALPHA
+)
+
+// A Renderer is what the lesson draws with, so the same code runs on the
+// fixed-function pipeline of old OpenGL and on shaders and buffers alone,
+// as a core profile has it. Matrices, lights and blending work like they
+// do in OpenGL: the matrices apply to what is drawn after changing them,
+// and lights are placed with the modelview matrix they are set with.
+type Renderer interface {
+	// Set up depth testing and the rest of the state the lesson starts with
+	Init()
+
+	ClearColor(r, g, b, a float32)
+	Clear() // the color and depth buffers
+	Viewport(x, y, width, height int)
+
+	Perspective(fovy, aspect, near, far float64) // the projection matrix
+	LoadIdentity()                               // the modelview matrix from here on
+	Translate(x, y, z float32)
+	Rotate(angle, x, y, z float32) // in degrees, around the axis x, y, z
+	PushMatrix()
+	PopMatrix()
+
+	// Textures take RGBA pixels, width*height*4 of them
+	NewTexture(pixels []byte, width, height, filter int) Texture
+	BindTexture(texture Texture)
+
+	// Meshes are uploaded once and drawn with the bound texture and the
+	// color, which lighting replaces
+	NewMesh(primitive int, vertices []Vertex) Mesh
+	Draw(mesh Mesh)
+	Color(r, g, b, a float32)
+
+	Light(i int, light Light) // i from 0 to MAX_LIGHTS-1
+	Lighting(on bool)
+	Blending(on bool)
+	BlendFunc(src, dst int)
+	DepthTest(on bool)
+}
+
+// how many lights a renderer has, as many as OpenGL promises
+const MAX_LIGHTS = 8
+
+// A Texture or Mesh is made by a renderer and only drawn with that one
+type Texture interface {
+	Delete()
+}
+
+type Mesh interface {
+	Delete()
+}
+
+// A Vertex of a mesh
+type Vertex struct {
+	X, Y, Z    float32
+	NX, NY, NZ float32
+	U, V       float32
+}
+
+// A Light is a point light if Position[3] is 1, or shines from the
+// direction of Position if it is 0
+type Light struct {
+	Ambient, Diffuse [4]float32
+	Position         [4]float32
+}
+
+// A Mat4 is a 4x4 matrix in the column-major order OpenGL takes
+type Mat4 [16]float32
+
+func Identity() Mat4 {
+	return Mat4{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1}
+}
+
+// The product a*b, which applies b first
+func (a Mat4) Mul(b Mat4) Mat4 {
+	var m Mat4
+	for col := 0; col < 4; col++ {
+		for row := 0; row < 4; row++ {
+			var sum float32
+			for k := 0; k < 4; k++ {
+				sum += a[k*4+row] * b[col*4+k]
+			}
+			m[col*4+row] = sum
+		}
+	}
+	return m
+}
+
+// Transform a point or direction, as w is 1 or 0
+func (a Mat4) Transform(v [4]float32) [4]float32 {
+	var r [4]float32
+	for row := 0; row < 4; row++ {
+		for k := 0; k < 4; k++ {
+			r[row] += a[k*4+row] * v[k]
+		}
+	}
+	return r
+}
+
+// The matrices of glTranslate, glRotate and glFrustum
+func Translation(x, y, z float32) Mat4 {
+	m := Identity()
+	m[12], m[13], m[14] = x, y, z
+	return m
+}
+
+func Rotation(angle, x, y, z float32) Mat4 {
+	l := float32(math.Sqrt(float64(x*x + y*y + z*z)))
+	if l == 0 {
+		return Identity()
+	}
+	x, y, z = x/l, y/l, z/l
+	a := float64(angle) * math.Pi / 180
+	c, s := float32(math.Cos(a)), float32(math.Sin(a))
+	return Mat4{
+		x*x*(1-c) + c, y*x*(1-c) + z*s, x*z*(1-c) - y*s, 0,
+		x*y*(1-c) - z*s, y*y*(1-c) + c, y*z*(1-c) + x*s, 0,
+		x*z*(1-c) + y*s, y*z*(1-c) - x*s, z*z*(1-c) + c, 0,
+		0, 0, 0, 1,
+	}
+}
+
+func Frustum(left, right, bottom, top, near, far float64) Mat4 {
+	return Mat4{
+		float32(2 * near / (right - left)), 0, 0, 0,
+		0, float32(2 * near / (top - bottom)), 0, 0,
+		float32((right + left) / (right - left)), float32((top + bottom) / (top - bottom)), float32(-(far + near) / (far - near)), -1,
+		0, 0, float32(-2 * far * near / (far - near)), 0,
+	}
+}
+
+// The frustum gluPerspective makes, fovy in degrees
+func PerspectiveBounds(fovy, aspect, near, far float64) (left, right, bottom, top float64) {
+	top = math.Tan(fovy*math.Pi/360.0) * near
+	bottom = -top
+	return aspect * bottom, aspect * top, bottom, top
+}
+
+// The matrix normals are transformed with: the inverse transpose of the
+// upper left 3x3 of a, so scaling doesn't bend them
+func (a Mat4) NormalMatrix() [9]float32 {
+	m00, m01, m02 := a[0], a[4], a[8]
+	m10, m11, m12 := a[1], a[5], a[9]
+	m20, m21, m22 := a[2], a[6], a[10]
+
+	// cofactors, which are the inverse transpose times the determinant
+	c00, c01, c02 := m11*m22-m12*m21, m12*m20-m10*m22, m10*m21-m11*m20
+	c10, c11, c12 := m02*m21-m01*m22, m00*m22-m02*m20, m01*m20-m00*m21
+	c20, c21, c22 := m01*m12-m02*m11, m02*m10-m00*m12, m00*m11-m01*m10
+
+	det := m00*c00 + m01*c01 + m02*c02
+	if det == 0 {
+		det = 1
+	}
+	return [9]float32{
+		c00 / det, c10 / det, c20 / det,
+		c01 / det, c11 / det, c21 / det,
+		c02 / det, c12 / det, c22 / det,
+	}
+}
@@ -0,0 +1,473 @@
+package render
+
+import (
+	"image"
+	"math"
+)
+
+// The SoftwareRenderer draws into an image in memory without OpenGL, so
+// lessons can run where there is no graphics card. It does what the
+// fixed-function pipeline does for the lessons: triangles and quads,
+// smooth shading, lighting per vertex, perspective correct textures with
+// nearest or linear filtering, depth testing like LEQUAL and blending.
+// Mipmapped textures are filtered linearly without mipmaps.
+type SoftwareRenderer struct {
+	image *image.RGBA
+	depth []float32 // from 0 at the near plane to 1 at the far plane
+
+	clearColor [4]float32
+	viewport   image.Rectangle // in OpenGL's coordinates, y up
+
+	projection Mat4
+	modelview  []Mat4 // the stack, the current matrix last
+
+	color     [4]float32
+	texture   *softwareTexture
+	lighting  bool
+	lights    [MAX_LIGHTS]*Light // placed in eye space, nil when off
+	blending  bool
+	src, dst  int
+	depthTest bool
+}
+
+type softwareTexture struct {
+	pixels        []byte // RGBA, the first row at the bottom like in OpenGL
+	width, height int
+	linear        bool
+}
+
+type softwareMesh struct {
+	triangles [][3]Vertex
+}
+
+// A corner of a triangle on its way to the screen
+type softwareVertex struct {
+	clip  [4]float32 // position in clip space
+	shade [4]float32 // the color, lit or not
+	u, v  float32
+}
+
+func NewSoftwareRenderer(width, height int) *SoftwareRenderer {
+	return &SoftwareRenderer{
+		image:      image.NewRGBA(image.Rect(0, 0, width, height)),
+		depth:      make([]float32, width*height),
+		viewport:   image.Rect(0, 0, width, height),
+		projection: Identity(),
+		modelview:  []Mat4{Identity()},
+		color:      [4]float32{1, 1, 1, 1},
+		src:        BLEND_ONE,
+		dst:        BLEND_ZERO,
+	}
+}
+
+// The image drawn into, with its first row at the top
+func (r *SoftwareRenderer) Image() *image.RGBA {
+	return r.image
+}
+
+func (r *SoftwareRenderer) Init() {
+	r.depthTest = true
+}
+
+func (r *SoftwareRenderer) ClearColor(red, green, blue, alpha float32) {
+	r.clearColor = [4]float32{red, green, blue, alpha}
+}
+
+func (r *SoftwareRenderer) Clear() {
+	c := toBytes(r.clearColor)
+	for i := 0; i < len(r.image.Pix); i += 4 {
+		copy(r.image.Pix[i:i+4], c[:])
+	}
+	for i := range r.depth {
+		r.depth[i] = 1
+	}
+}
+
+func (r *SoftwareRenderer) Viewport(x, y, width, height int) {
+	r.viewport = image.Rect(x, y, x+width, y+height)
+}
+
+func (r *SoftwareRenderer) Perspective(fovy, aspect, near, far float64) {
+	left, right, bottom, top := PerspectiveBounds(fovy, aspect, near, far)
+	r.projection = Frustum(left, right, bottom, top, near, far)
+}
+
+// the modelview matrix on top of the stack
+func (r *SoftwareRenderer) top() *Mat4 {
+	return &r.modelview[len(r.modelview)-1]
+}
+
+func (r *SoftwareRenderer) LoadIdentity() {
+	*r.top() = Identity()
+}
+
+func (r *SoftwareRenderer) Translate(x, y, z float32) {
+	*r.top() = r.top().Mul(Translation(x, y, z))
+}
+
+func (r *SoftwareRenderer) Rotate(angle, x, y, z float32) {
+	*r.top() = r.top().Mul(Rotation(angle, x, y, z))
+}
+
+func (r *SoftwareRenderer) PushMatrix() {
+	r.modelview = append(r.modelview, *r.top())
+}
+
+func (r *SoftwareRenderer) PopMatrix() {
+	if len(r.modelview) > 1 {
+		r.modelview = r.modelview[:len(r.modelview)-1]
+	}
+}
+
+func (r *SoftwareRenderer) NewTexture(pixels []byte, width, height, filter int) Texture {
+	return &softwareTexture{
+		pixels: append([]byte(nil), pixels...),
+		width:  width,
+		height: height,
+		linear: filter != FILTER_NEAREST,
+	}
+}
+
+func (t *softwareTexture) Delete() {}
+
+func (r *SoftwareRenderer) BindTexture(texture Texture) {
+	r.texture = texture.(*softwareTexture)
+}
+
+func (r *SoftwareRenderer) NewMesh(primitive int, vertices []Vertex) Mesh {
+	m := &softwareMesh{}
+	if primitive == PRIMITIVE_QUADS {
+		for i := 0; i+3 < len(vertices); i += 4 {
+			q := vertices[i : i+4]
+			m.triangles = append(m.triangles, [3]Vertex{q[0], q[1], q[2]}, [3]Vertex{q[0], q[2], q[3]})
+		}
+	} else {
+		for i := 0; i+2 < len(vertices); i += 3 {
+			m.triangles = append(m.triangles, [3]Vertex{vertices[i], vertices[i+1], vertices[i+2]})
+		}
+	}
+	return m
+}
+
+func (m *softwareMesh) Delete() {}
+
+func (r *SoftwareRenderer) Draw(mesh Mesh) {
+	modelview := *r.top()
+	normals := modelview.NormalMatrix()
+
+	for _, triangle := range mesh.(*softwareMesh).triangles {
+		var corners [3]softwareVertex
+		for i, v := range triangle {
+			eye := modelview.Transform([4]float32{v.X, v.Y, v.Z, 1})
+			corners[i] = softwareVertex{
+				clip:  r.projection.Transform(eye),
+				shade: r.shade(eye, normals, v),
+				u:     v.U,
+				v:     v.V,
+			}
+		}
+
+		// what is left of the triangle in front of the near plane, as a fan
+		polygon := clipNear(corners[:])
+		for i := 2; i < len(polygon); i++ {
+			r.rasterize(polygon[0], polygon[i-1], polygon[i])
+		}
+	}
+}
+
+// The color of a vertex: the current color, or the lighting of it with the
+// material fixed-function OpenGL starts out with
+func (r *SoftwareRenderer) shade(eye [4]float32, normals [9]float32, v Vertex) [4]float32 {
+	if !r.lighting {
+		return r.color
+	}
+
+	const materialAmbient, materialDiffuse, sceneAmbient = 0.2, 0.8, 0.2
+
+	n := [3]float32{
+		normals[0]*v.NX + normals[3]*v.NY + normals[6]*v.NZ,
+		normals[1]*v.NX + normals[4]*v.NY + normals[7]*v.NZ,
+		normals[2]*v.NX + normals[5]*v.NY + normals[8]*v.NZ,
+	}
+	n = normalize3(n)
+
+	lit := [3]float32{sceneAmbient * materialAmbient, sceneAmbient * materialAmbient, sceneAmbient * materialAmbient}
+	for _, light := range r.lights {
+		if light == nil {
+			continue
+		}
+		p := light.Position
+		toLight := normalize3([3]float32{p[0] - eye[0]*p[3], p[1] - eye[1]*p[3], p[2] - eye[2]*p[3]})
+		diffuse := n[0]*toLight[0] + n[1]*toLight[1] + n[2]*toLight[2]
+		if diffuse < 0 {
+			diffuse = 0
+		}
+		for c := 0; c < 3; c++ {
+			lit[c] += light.Ambient[c]*materialAmbient + light.Diffuse[c]*materialDiffuse*diffuse
+		}
+	}
+	return [4]float32{clamp01(lit[0]), clamp01(lit[1]), clamp01(lit[2]), 1}
+}
+
+func normalize3(v [3]float32) [3]float32 {
+	l := float32(math.Sqrt(float64(v[0]*v[0] + v[1]*v[1] + v[2]*v[2])))
+	if l == 0 {
+		return v
+	}
+	return [3]float32{v[0] / l, v[1] / l, v[2] / l}
+}
+
+// Cut away the part of a polygon behind the near plane, where z < -w,
+// as that would come out mirrored after dividing by w
+func clipNear(polygon []softwareVertex) []softwareVertex {
+	distance := func(v softwareVertex) float32 { return v.clip[2] + v.clip[3] }
+
+	var clipped []softwareVertex
+	for i, a := range polygon {
+		b := polygon[(i+1)%len(polygon)]
+		da, db := distance(a), distance(b)
+		if da >= 0 {
+			clipped = append(clipped, a)
+		}
+		if (da >= 0) != (db >= 0) {
+			clipped = append(clipped, lerpVertex(a, b, da/(da-db)))
+		}
+	}
+	return clipped
+}
+
+func lerpVertex(a, b softwareVertex, t float32) softwareVertex {
+	var v softwareVertex
+	for i := range v.clip {
+		v.clip[i] = a.clip[i] + (b.clip[i]-a.clip[i])*t
+		v.shade[i] = a.shade[i] + (b.shade[i]-a.shade[i])*t
+	}
+	v.u = a.u + (b.u-a.u)*t
+	v.v = a.v + (b.v-a.v)*t
+	return v
+}
+
+// A corner of a triangle on the screen, with what is interpolated across
+// the triangle divided by w, so it can be interpolated linearly there
+type screenVertex struct {
+	x, y, z float32 // in window coordinates, y up, z from 0 to 1
+	invW    float32
+	shade   [4]float32 // over w
+	uOverW  float32
+	vOverW  float32
+}
+
+func (r *SoftwareRenderer) toScreen(v softwareVertex) screenVertex {
+	invW := 1 / v.clip[3]
+	vp := r.viewport
+	s := screenVertex{
+		x:      float32(vp.Min.X) + (v.clip[0]*invW+1)/2*float32(vp.Dx()),
+		y:      float32(vp.Min.Y) + (v.clip[1]*invW+1)/2*float32(vp.Dy()),
+		z:      (v.clip[2]*invW + 1) / 2,
+		invW:   invW,
+		uOverW: v.u * invW,
+		vOverW: v.v * invW,
+	}
+	for i := range s.shade {
+		s.shade[i] = v.shade[i] * invW
+	}
+	return s
+}
+
+// Fill the pixels whose centers lie in a triangle
+func (r *SoftwareRenderer) rasterize(a, b, c softwareVertex) {
+	p0, p1, p2 := r.toScreen(a), r.toScreen(b), r.toScreen(c)
+
+	area := edge(p0, p1, p2.x, p2.y)
+	if area == 0 {
+		return
+	}
+	if area < 0 {
+		// wind counterclockwise, so the weights inside are positive
+		p1, p2, area = p2, p1, -area
+	}
+	own0, own1, own2 := ownsEdge(p1, p2), ownsEdge(p2, p0), ownsEdge(p0, p1)
+
+	// the pixels the triangle may cover, inside the viewport and image
+	bounds := r.viewport.Intersect(image.Rect(0, 0, r.image.Rect.Dx(), r.image.Rect.Dy()))
+	minX := maxInt(bounds.Min.X, int(math.Floor(float64(min3(p0.x, p1.x, p2.x)))))
+	maxX := minInt(bounds.Max.X-1, int(math.Ceil(float64(max3(p0.x, p1.x, p2.x)))))
+	minY := maxInt(bounds.Min.Y, int(math.Floor(float64(min3(p0.y, p1.y, p2.y)))))
+	maxY := minInt(bounds.Max.Y-1, int(math.Ceil(float64(max3(p0.y, p1.y, p2.y)))))
+
+	height := r.image.Rect.Dy()
+	for y := minY; y <= maxY; y++ {
+		for x := minX; x <= maxX; x++ {
+			px, py := float32(x)+0.5, float32(y)+0.5
+
+			// barycentric weights, all positive inside. Pixels right on
+			// an edge belong to one of the triangles sharing it, so
+			// blending doesn't draw them twice.
+			w0 := edge(p1, p2, px, py) / area
+			w1 := edge(p2, p0, px, py) / area
+			w2 := edge(p0, p1, px, py) / area
+			if w0 < 0 || w1 < 0 || w2 < 0 ||
+				w0 == 0 && !own0 || w1 == 0 && !own1 || w2 == 0 && !own2 {
+				continue
+			}
+
+			z := w0*p0.z + w1*p1.z + w2*p2.z
+			i := (height-1-y)*r.image.Rect.Dx() + x
+			if r.depthTest && z > r.depth[i] {
+				continue
+			}
+
+			// undo the division by w, which makes it perspective correct
+			w := 1 / (w0*p0.invW + w1*p1.invW + w2*p2.invW)
+			var color [4]float32
+			for k := range color {
+				color[k] = (w0*p0.shade[k] + w1*p1.shade[k] + w2*p2.shade[k]) * w
+			}
+			if r.texture != nil {
+				u := (w0*p0.uOverW + w1*p1.uOverW + w2*p2.uOverW) * w
+				v := (w0*p0.vOverW + w1*p1.vOverW + w2*p2.vOverW) * w
+				texel := r.texture.sample(u, v)
+				for k := range color {
+					color[k] *= texel[k]
+				}
+			}
+
+			if r.depthTest {
+				r.depth[i] = z
+			}
+			r.plot(i, color)
+		}
+	}
+}
+
+// Twice the signed area of the triangle a, b, (x, y). It is always worked
+// out from the same end of an edge, so triangles sharing it get exactly
+// opposite values and agree on which side a pixel is.
+func edge(a, b screenVertex, x, y float32) float32 {
+	if b.y < a.y || b.y == a.y && b.x < a.x {
+		return -edge(b, a, x, y)
+	}
+	return (b.x-a.x)*(y-a.y) - (b.y-a.y)*(x-a.x)
+}
+
+// Whether the pixels right on the edge from a to b of a counterclockwise
+// triangle belong to it: they do on its left edges, which go down, and on
+// its top edges, which go left
+func ownsEdge(a, b screenVertex) bool {
+	return b.y < a.y || b.y == a.y && b.x < a.x
+}
+
+// Write a color to the pixel at i, blended with what is there
+func (r *SoftwareRenderer) plot(i int, color [4]float32) {
+	pixel := r.image.Pix[i*4 : i*4+4]
+	if r.blending {
+		var dst [4]float32
+		for k := range dst {
+			dst[k] = float32(pixel[k]) / 255
+		}
+		srcFactor, dstFactor := blendFactor(r.src, color, dst), blendFactor(r.dst, color, dst)
+		for k := range color {
+			color[k] = color[k]*srcFactor + dst[k]*dstFactor
+		}
+	}
+	c := toBytes(color)
+	copy(pixel, c[:])
+}
+
+// What a color is multiplied with when blending
+func blendFactor(factor int, src, dst [4]float32) float32 {
+	switch factor {
+	case BLEND_ONE:
+		return 1
+	case BLEND_SRC_ALPHA:
+		return src[3]
+	case BLEND_ONE_MINUS_SRC_ALPHA:
+		return 1 - src[3]
+	}
+	return 0
+}
+
+// The color of a texture at u, v, repeating outside of 0 to 1
+func (t *softwareTexture) sample(u, v float32) [4]float32 {
+	x, y := u*float32(t.width), v*float32(t.height)
+	if !t.linear {
+		return t.texel(int(math.Floor(float64(x))), int(math.Floor(float64(y))))
+	}
+
+	// between the four texels around, whose centers are half a texel in
+	x, y = x-0.5, y-0.5
+	x0, y0 := math.Floor(float64(x)), math.Floor(float64(y))
+	fx, fy := x-float32(x0), y-float32(y0)
+	a, b := t.texel(int(x0), int(y0)), t.texel(int(x0)+1, int(y0))
+	c, d := t.texel(int(x0), int(y0)+1), t.texel(int(x0)+1, int(y0)+1)
+
+	var color [4]float32
+	for k := range color {
+		top := a[k] + (b[k]-a[k])*fx
+		bottom := c[k] + (d[k]-c[k])*fx
+		color[k] = top + (bottom-top)*fy
+	}
+	return color
+}
+
+func (t *softwareTexture) texel(x, y int) [4]float32 {
+	x, y = wrap(x, t.width), wrap(y, t.height)
+	i := (y*t.width + x) * 4
+	p := t.pixels[i : i+4]
+	return [4]float32{float32(p[0]) / 255, float32(p[1]) / 255, float32(p[2]) / 255, float32(p[3]) / 255}
+}
+
+func wrap(i, n int) int {
+	i %= n
+	if i < 0 {
+		i += n
+	}
+	return i
+}
+
+func (r *SoftwareRenderer) Color(red, green, blue, alpha float32) {
+	r.color = [4]float32{red, green, blue, alpha}
+}
+
+func (r *SoftwareRenderer) Light(i int, light Light) {
+	light.Position = r.top().Transform(light.Position)
+	r.lights[i] = &light
+}
+
+func (r *SoftwareRenderer) Lighting(on bool)       { r.lighting = on }
+func (r *SoftwareRenderer) Blending(on bool)       { r.blending = on }
+func (r *SoftwareRenderer) BlendFunc(src, dst int) { r.src, r.dst = src, dst }
+func (r *SoftwareRenderer) DepthTest(on bool)      { r.depthTest = on }
+
+func toBytes(color [4]float32) [4]byte {
+	var b [4]byte
+	for k := range color {
+		b[k] = byte(clamp01(color[k])*255 + 0.5)
+	}
+	return b
+}
+
+func clamp01(f float32) float32 {
+	return float32(math.Max(0, math.Min(1, float64(f))))
+}
+
+func min3(a, b, c float32) float32 {
+	return float32(math.Min(float64(a), math.Min(float64(b), float64(c))))
+}
+func max3(a, b, c float32) float32 {
+	return float32(math.Max(float64(a), math.Max(float64(b), float64(c))))
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
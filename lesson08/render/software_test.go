@@ -0,0 +1,162 @@
+package render
+
+import (
+	"image/color"
+	"testing"
+)
+
+const TEST_SIZE = 16
+
+// A renderer cleared to black, drawing straight in normalized device
+// coordinates, as both matrices start out as the identity
+func newTestRenderer() *SoftwareRenderer {
+	r := NewSoftwareRenderer(TEST_SIZE, TEST_SIZE)
+	r.Init()
+	r.ClearColor(0, 0, 0, 1)
+	r.Clear()
+	return r
+}
+
+// A quad over the whole screen at depth z, with the texture over all of it
+func screenQuad(r *SoftwareRenderer, z float32) Mesh {
+	return r.NewMesh(PRIMITIVE_QUADS, []Vertex{
+		{X: -1, Y: -1, Z: z, U: 0, V: 0},
+		{X: 1, Y: -1, Z: z, U: 1, V: 0},
+		{X: 1, Y: 1, Z: z, U: 1, V: 1},
+		{X: -1, Y: 1, Z: z, U: 0, V: 1},
+	})
+}
+
+// Whether every pixel is as want says, reporting the first that isn't
+func checkPixels(t *testing.T, name string, r *SoftwareRenderer, want func(x, y int) color.RGBA) {
+	for y := 0; y < TEST_SIZE; y++ {
+		for x := 0; x < TEST_SIZE; x++ {
+			if got, want := r.Image().RGBAAt(x, y), want(x, y); got != want {
+				t.Errorf("%s: pixel %d,%d is %v, want %v", name, x, y, got, want)
+				return
+			}
+		}
+	}
+}
+
+// Every pixel is drawn exactly once, also along the diagonal the two
+// triangles of a quad share, and only those whose centers are inside
+func TestCoverage(t *testing.T) {
+	r := newTestRenderer()
+	r.Blending(true)
+	r.BlendFunc(BLEND_ONE, BLEND_ONE)
+	r.Color(0.2, 0, 0, 1)
+	r.Draw(screenQuad(r, 0))
+	checkPixels(t, "quad", r, func(x, y int) color.RGBA {
+		return color.RGBA{51, 0, 0, 255}
+	})
+
+	// the left half, to the edge between the pixels in the middle
+	r = newTestRenderer()
+	r.Draw(r.NewMesh(PRIMITIVE_TRIANGLES, []Vertex{
+		{X: -1, Y: -1}, {X: 0, Y: -1}, {X: 0, Y: 1},
+		{X: -1, Y: -1}, {X: 0, Y: 1}, {X: -1, Y: 1},
+	}))
+	checkPixels(t, "left half", r, func(x, y int) color.RGBA {
+		if x < TEST_SIZE/2 {
+			return color.RGBA{255, 255, 255, 255}
+		}
+		return color.RGBA{0, 0, 0, 255}
+	})
+}
+
+// The depth test passes like LEQUAL: what is as near as what is there
+// already is drawn, what is further away isn't
+func TestDepth(t *testing.T) {
+	tests := []struct {
+		name  string
+		z     float32
+		color [4]float32
+		want  color.RGBA
+	}{
+		{"first", 0, [4]float32{1, 0, 0, 1}, color.RGBA{255, 0, 0, 255}},
+		{"as near", 0, [4]float32{0, 1, 0, 1}, color.RGBA{0, 255, 0, 255}},
+		{"further", 0.5, [4]float32{0, 0, 1, 1}, color.RGBA{0, 255, 0, 255}},
+		{"nearer", -0.5, [4]float32{1, 1, 1, 1}, color.RGBA{255, 255, 255, 255}},
+	}
+
+	r := newTestRenderer()
+	for _, test := range tests {
+		r.Color(test.color[0], test.color[1], test.color[2], test.color[3])
+		r.Draw(screenQuad(r, test.z))
+		checkPixels(t, test.name, r, func(x, y int) color.RGBA { return test.want })
+	}
+
+	// without the test the last one drawn wins
+	r.DepthTest(false)
+	r.Color(0, 0, 1, 1)
+	r.Draw(screenQuad(r, 0.9))
+	checkPixels(t, "no depth test", r, func(x, y int) color.RGBA { return color.RGBA{0, 0, 255, 255} })
+}
+
+// SRC_ALPHA, ONE adds the color times its alpha to what is there
+func TestBlendAdditive(t *testing.T) {
+	r := newTestRenderer()
+	r.ClearColor(0.2, 0.2, 0.2, 1)
+	r.Clear()
+	r.Blending(true)
+	r.BlendFunc(BLEND_SRC_ALPHA, BLEND_ONE)
+
+	r.Color(1, 0, 0, 0.5)
+	r.Draw(screenQuad(r, 0))
+	checkPixels(t, "once", r, func(x, y int) color.RGBA { return color.RGBA{179, 51, 51, 255} })
+
+	// again on top, which only passes the depth test as it is LEQUAL,
+	// saturates red
+	r.Draw(screenQuad(r, 0))
+	checkPixels(t, "twice", r, func(x, y int) color.RGBA { return color.RGBA{255, 51, 51, 255} })
+}
+
+// A 2x2 texture over the screen, each texel a quarter of it. Textures
+// start at the bottom like in OpenGL, images at the top.
+func TestTexture(t *testing.T) {
+	r := newTestRenderer()
+	r.BindTexture(r.NewTexture([]byte{
+		255, 0, 0, 255, 0, 255, 0, 255, // red, green at the bottom
+		0, 0, 255, 255, 255, 255, 255, 255, // blue, white at the top
+	}, 2, 2, FILTER_NEAREST))
+	r.Draw(screenQuad(r, 0))
+
+	checkPixels(t, "nearest", r, func(x, y int) color.RGBA {
+		left, top := x < TEST_SIZE/2, y < TEST_SIZE/2
+		switch {
+		case left && top:
+			return color.RGBA{0, 0, 255, 255}
+		case top:
+			return color.RGBA{255, 255, 255, 255}
+		case left:
+			return color.RGBA{255, 0, 0, 255}
+		}
+		return color.RGBA{0, 255, 0, 255}
+	})
+}
+
+// A floor running from in front of the camera to behind it is cut at the
+// near plane. Drawn uncut, the part behind would come out mirrored at the
+// top of the screen. It is a little wider than 2, so no pixel center is
+// right on its side edges.
+func TestClipNear(t *testing.T) {
+	r := newTestRenderer()
+	r.Perspective(90, 1, 1, 10)
+	r.Draw(r.NewMesh(PRIMITIVE_QUADS, []Vertex{
+		{X: -1.1, Y: -1, Z: 5}, {X: 1.1, Y: -1, Z: 5}, {X: 1.1, Y: -1, Z: -5}, {X: -1.1, Y: -1, Z: -5},
+	}))
+
+	// looking at y = -1 from 0 at 90 degrees, the floor is seen from the
+	// bottom of the screen at the near plane up to 0.2 below the middle at
+	// z -5
+	checkPixels(t, "floor", r, func(x, y int) color.RGBA {
+		ndcX := 2*(float32(x)+0.5)/TEST_SIZE - 1
+		ndcY := 1 - 2*(float32(y)+0.5)/TEST_SIZE
+		distance := -1 / ndcY // to where the ray through the pixel hits the floor
+		if ndcY < 0 && distance <= 5 && ndcX*distance > -1.1 && ndcX*distance < 1.1 {
+			return color.RGBA{255, 255, 255, 255}
+		}
+		return color.RGBA{0, 0, 0, 255}
+	})
+}
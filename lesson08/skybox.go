@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/manveru/opengl-go-tutorials/lesson08/render"
+	"github.com/manveru/opengl-go-tutorials/rgba"
+	"github.com/manveru/opengl-go-tutorials/sky"
+)
+
+// A Skybox is a cube of six textures around the camera, drawn behind
+// everything else instead of clearing to black
+type Skybox struct {
+	faces  [6]render.Texture
+	meshes [6]render.Mesh
+}
+
+// Load a skybox from six images if path has a *, which is replaced by the
+// names of the faces, or else from one image holding them as a cross
+func LoadSkybox(path string) (*Skybox, error) {
+	faces, err := sky.Load(path, rgba.Load)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Skybox{}
+	for face, f := range faces {
+		s.addFace(face, f.Pixels, f.Width, f.Height)
+	}
+	return s, nil
+}
+
+// Make the texture and mesh of a face. Renderers repeat textures, so the
+// texture coordinates stay half a texel inside the edges, where filtering
+// would blend in the other side.
+func (s *Skybox) addFace(face int, pixels []byte, width, height int) {
+	s.faces[face] = renderer.NewTexture(pixels, width, height, render.FILTER_LINEAR)
+
+	du, dv := 0.5/float32(width), 0.5/float32(height)
+	texCoords := [4][2]float32{{du, dv}, {1 - du, dv}, {1 - du, 1 - dv}, {du, 1 - dv}}
+
+	var vertices []render.Vertex
+	for i, corner := range sky.Corners[face] {
+		vertices = append(vertices, render.Vertex{
+			X: corner[0], Y: corner[1], Z: corner[2],
+			U: texCoords[i][0], V: texCoords[i][1],
+		})
+	}
+	s.meshes[face] = renderer.NewMesh(render.PRIMITIVE_QUADS, vertices)
+}
+
+// Draw the sky around the camera, unlit and without depth, so everything
+// drawn after it is in front. The modelview matrix should only turn the
+// camera and not move it, to keep the sky centred on it.
+func (s *Skybox) draw() {
+	renderer.Lighting(false)
+	renderer.Blending(false)
+	renderer.DepthTest(false)
+	renderer.Color(1.0, 1.0, 1.0, 1.0)
+
+	for face := range s.faces {
+		renderer.BindTexture(s.faces[face])
+		renderer.Draw(s.meshes[face])
+	}
+
+	// back to what the keys have set
+	renderer.Lighting(light)
+	renderer.Blending(blend)
+	renderer.DepthTest(!blend)
+	setBlendFunc()
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/banthar/Go-SDL/sdl"
-	"github.com/banthar/gl"
-	"math"
+	"github.com/manveru/opengl-go-tutorials/lesson08/render"
+	"github.com/manveru/opengl-go-tutorials/lesson08/render/glrender"
+	"github.com/manveru/opengl-go-tutorials/rgba"
 	"math/rand"
 	"os"
 )
@@ -18,8 +20,8 @@ const (
 )
 
 type Star struct {
-	r, g, b     gl.GLubyte
-	dist, angle gl.GLfloat
+	r, g, b     uint8
+	dist, angle float32
 }
 
 var (
@@ -30,15 +32,24 @@ var (
 	stars   = [50]*Star{}
 	num     = len(stars)
 
-	zoom gl.GLfloat = -15.0
-	tilt gl.GLfloat = 90.0
-	spin gl.GLfloat
+	zoom float32 = -15.0
+	tilt float32 = 90.0
+	spin float32
 
-	texture gl.Texture
+	renderer render.Renderer // what we draw with
+	texture  render.Texture
+	quad     render.Mesh // a star, drawn once for every one of them
+
+	alphaMode     = rgba.ALPHA_NONE   // how the star gets its alpha
+	colorKey      = [3]uint8{0, 0, 0} // color made transparent by rgba.ALPHA_COLORKEY
+	premultiplied = false             // texture colors are multiplied by their alpha
+	additive      = true              // additive blending instead of alpha blending
+
+	skybox *Skybox // drawn behind the stars, nil to clear to black
 )
 
-// Load bitmap from path as GL texture
-func LoadGLTexture(path string) {
+// Load bitmap from path as GL texture, generating alpha as given by mode
+func LoadGLTexture(path string, mode int) {
 	image := sdl.Load(path)
 	if image == nil {
 		panic(sdl.GetError())
@@ -56,43 +67,42 @@ func LoadGLTexture(path string) {
 
 	// get the number of channels in the SDL surface
 	nOfColors := image.Format.BytesPerPixel
-	var textureFormat gl.GLenum
-
-	if nOfColors == 4 { // contains alpha channel
-		if image.Format.Rmask == 0x000000ff {
-			textureFormat = gl.RGBA
-		} else {
-			textureFormat = gl.BGRA
-		}
-	} else if nOfColors == 3 { // no alpha channel
-		if image.Format.Rmask == 0x000000ff {
-			textureFormat = gl.RGB
-		} else {
-			textureFormat = gl.BGR
-		}
-	} else {
+	if nOfColors != 4 && nOfColors != 3 {
 		fmt.Println("warning:", path, "is not truecolor, this will probably break")
 	}
 
-	texture = gl.GenTexture()
+	// we always hand RGBA to GL, so we can fill in the alpha ourselves
+	pixels := rgba.FromSurface(image)
 
-	// Typical texture generation using data from the bitmap
-	gl.BindTexture(gl.TEXTURE_2D, uint(texture))
+	rgba.GenerateAlpha(pixels, mode, colorKey)
 
-	// Generate the texture
-	gl.TexImage2D(gl.TEXTURE_2D, 0, int(image.Format.BytesPerPixel),
-		int(image.W), int(image.H),
-		0, textureFormat, gl.UNSIGNED_BYTE, image.Pixels,
-	)
+	if premultiplied {
+		rgba.Premultiply(pixels)
+	}
 
-	// linear filtering
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	// Generate the texture, with linear filtering
+	texture = renderer.NewTexture(pixels, int(image.W), int(image.H), render.FILTER_LINEAR)
 
 	// free up memory we have used.
 	image.Free()
 }
 
+// Pick the blend function for the current blending mode.
+// Premultiplied textures already carry their alpha in the color, so the
+// source factor becomes ONE.
+func setBlendFunc() {
+	src := render.BLEND_SRC_ALPHA
+	if premultiplied {
+		src = render.BLEND_ONE
+	}
+
+	if additive {
+		renderer.BlendFunc(src, render.BLEND_ONE)
+	} else {
+		renderer.BlendFunc(src, render.BLEND_ONE_MINUS_SRC_ALPHA)
+	}
+}
+
 // release/destroy our resources and restoring the old desktop
 func Quit(status int) {
 	// clean up the window
@@ -110,32 +120,13 @@ func resizeWindow(width, height int) {
 	}
 
 	// Setup our viewport
-	gl.Viewport(0, 0, width, height)
-
-	// change to the projection matrix and set our viewing volume.
-	gl.MatrixMode(gl.PROJECTION)
-	gl.LoadIdentity()
-
-	// aspect ratio
-	aspect := gl.GLdouble(gl.GLfloat(width) / gl.GLfloat(height))
-
-	// Set our perspective.
-	// This code is equivalent to using gluPerspective as in the original tutorial.
-	var fov, near, far gl.GLdouble
-	fov = 45.0
-	near = 0.1
-	far = 100.0
-	top := gl.GLdouble(math.Tan(float64(fov*math.Pi/360.0))) * near
-	bottom := -top
-	left := aspect * bottom
-	right := aspect * top
-	gl.Frustum(float64(left), float64(right), float64(bottom), float64(top), float64(near), float64(far))
-
-	// Make sure we're changing the model view and not the projection
-	gl.MatrixMode(gl.MODELVIEW)
+	renderer.Viewport(0, 0, width, height)
+
+	// Set our perspective, like gluPerspective does in the original tutorial
+	renderer.Perspective(45.0, float64(width)/float64(height), 0.1, 100.0)
 
 	// Reset the view
-	gl.LoadIdentity()
+	renderer.LoadIdentity()
 }
 
 // handle key press events
@@ -143,6 +134,34 @@ func handleKeyPress(keysym sdl.Keysym) {
 	switch keysym.Sym {
 	case sdl.K_t:
 		twinkle = !twinkle
+	case sdl.K_a: // a toggles between additive and alpha blending
+		additive = !additive
+		if additive {
+			p("additive blending")
+		} else {
+			p("alpha blending")
+		}
+		setBlendFunc()
+	case sdl.K_m: // m toggles premultiplied alpha, which needs a new texture
+		premultiplied = !premultiplied
+		p("premultiplied alpha:", premultiplied)
+		texture.Delete()
+		LoadGLTexture("data/star.bmp", alphaMode)
+		setBlendFunc()
+	case sdl.K_k: // k cycles through opaque, luminance and color key alpha
+		switch alphaMode {
+		case rgba.ALPHA_NONE:
+			alphaMode = rgba.ALPHA_LUMINANCE
+			p("alpha from the brightness")
+		case rgba.ALPHA_LUMINANCE:
+			alphaMode = rgba.ALPHA_COLORKEY
+			p("alpha from the color key")
+		default:
+			alphaMode = rgba.ALPHA_NONE
+			p("opaque star")
+		}
+		texture.Delete()
+		LoadGLTexture("data/star.bmp", alphaMode)
 	case sdl.K_UP:
 		tilt -= 0.5
 	case sdl.K_DOWN:
@@ -160,69 +179,70 @@ func handleKeyPress(keysym sdl.Keysym) {
 
 // general OpenGL initialization
 func initGL() {
-	LoadGLTexture("data/star.bmp")
-
-	gl.Enable(gl.TEXTURE_2D)
-	gl.Enable(gl.BLEND)
-	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE)
-	gl.ShadeModel(gl.SMOOTH)
-	gl.ClearColor(0.0, 0.0, 0.0, 0.5)
-	gl.ClearDepth(1.0)
-	gl.Hint(gl.PERSPECTIVE_CORRECTION_HINT, gl.NICEST)
+	// the star is opaque at first: adding it with SRC_ALPHA, ONE already
+	// leaves the black around it out, and an alpha from its brightness
+	// would only make it dimmer
+	LoadGLTexture("data/star.bmp", alphaMode)
+
+	renderer.Init()
+	renderer.Blending(true)
+	renderer.DepthTest(false) // the stars are blended in any order
+	setBlendFunc()
+	renderer.ClearColor(0.0, 0.0, 0.0, 0.5)
+
+	quad = renderer.NewMesh(render.PRIMITIVE_QUADS, []render.Vertex{
+		{X: -1.0, Y: -1.0, U: 0.0, V: 0.0},
+		{X: 1.0, Y: -1.0, U: 1.0, V: 0.0},
+		{X: 1.0, Y: 1.0, U: 1.0, V: 1.0},
+		{X: -1.0, Y: 1.0, U: 0.0, V: 1.0},
+	})
+}
+
+// Set the color stars are drawn with, opaque
+func starColor(r, g, b uint8) {
+	renderer.Color(float32(r)/255, float32(g)/255, float32(b)/255, 1.0)
 }
 
 // Here goes our drawing code
 func drawGLScene() {
 	// Clear the screen and depth buffer
-	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
-	gl.BindTexture(gl.TEXTURE_2D, uint(texture))
+	renderer.Clear()
+
+	if skybox != nil {
+		renderer.LoadIdentity()
+		skybox.draw()
+	}
+
+	renderer.BindTexture(texture)
 
 	for loop, star := range stars {
-		gl.LoadIdentity()
-		gl.Translatef(0.0, 0.0, float32(zoom))
-		gl.Rotatef(float32(tilt), 1.0, 0.0, 0.0)
-		gl.Rotatef(float32(star.angle), 0.0, 1.0, 0.0)
-		gl.Translatef(float32(star.dist), 0.0, 0.0)
-		gl.Rotatef(float32(-star.angle), 0.0, 1.0, 0.0)
-		gl.Rotatef(float32(-tilt), 1.0, 0.0, 0.0)
+		renderer.LoadIdentity()
+		renderer.Translate(0.0, 0.0, zoom)
+		renderer.Rotate(tilt, 1.0, 0.0, 0.0)
+		renderer.Rotate(star.angle, 0.0, 1.0, 0.0)
+		renderer.Translate(star.dist, 0.0, 0.0)
+		renderer.Rotate(-star.angle, 0.0, 1.0, 0.0)
+		renderer.Rotate(-tilt, 1.0, 0.0, 0.0)
 
 		if twinkle {
 			other := stars[(num-loop)-1]
-			gl.Color4ub(uint8(other.r), uint8(other.g), uint8(other.b), 255)
-			gl.Begin(gl.QUADS)
-			gl.TexCoord2f(0.0, 0.0)
-			gl.Vertex3f(-1.0, -1.0, 0.0)
-			gl.TexCoord2f(1.0, 0.0)
-			gl.Vertex3f(1.0, -1.0, 0.0)
-			gl.TexCoord2f(1.0, 1.0)
-			gl.Vertex3f(1.0, 1.0, 0.0)
-			gl.TexCoord2f(0.0, 1.0)
-			gl.Vertex3f(-1.0, 1.0, 0.0)
-			gl.End()
+			starColor(other.r, other.g, other.b)
+			renderer.Draw(quad)
 		}
 
-		gl.Rotatef(float32(spin), 0.0, 0.0, 1.0)
-		gl.Color4ub(uint8(star.r), uint8(star.g), uint8(star.b), 255)
-		gl.Begin(gl.QUADS)
-		gl.TexCoord2f(0.0, 0.0)
-		gl.Vertex3f(-1.0, -1.0, 0.0)
-		gl.TexCoord2f(1.0, 0.0)
-		gl.Vertex3f(1.0, -1.0, 0.0)
-		gl.TexCoord2f(1.0, 1.0)
-		gl.Vertex3f(1.0, 1.0, 0.0)
-		gl.TexCoord2f(0.0, 1.0)
-		gl.Vertex3f(-1.0, 1.0, 0.0)
-		gl.End()
+		renderer.Rotate(spin, 0.0, 0.0, 1.0)
+		starColor(star.r, star.g, star.b)
+		renderer.Draw(quad)
 
 		spin += 0.01
-		star.angle += gl.GLfloat(loop) / gl.GLfloat(num)
+		star.angle += float32(loop) / float32(num)
 		star.dist -= 0.01
 
 		if star.dist < 0.0 {
 			star.dist += 5.0
-			star.r = gl.GLubyte(rand.Float32() * 255)
-			star.g = gl.GLubyte(rand.Float32() * 255)
-			star.b = gl.GLubyte(rand.Float32() * 255)
+			star.r = uint8(rand.Float32() * 255)
+			star.g = uint8(rand.Float32() * 255)
+			star.b = uint8(rand.Float32() * 255)
 		}
 	}
 
@@ -246,15 +266,19 @@ func initStars() {
 	for loop, _ := range stars {
 		stars[loop] = &Star{
 			angle: 0.0,
-			dist:  (gl.GLfloat(loop) / gl.GLfloat(num)) * 5.0,
-			r:     gl.GLubyte(rand.Float32() * 255),
-			g:     gl.GLubyte(rand.Float32() * 255),
-			b:     gl.GLubyte(rand.Float32() * 255),
+			dist:  (float32(loop) / float32(num)) * 5.0,
+			r:     uint8(rand.Float32() * 255),
+			g:     uint8(rand.Float32() * 255),
+			b:     uint8(rand.Float32() * 255),
 		}
 	}
 }
 
 func main() {
+	core := flag.Bool("core", false, "draw with shaders and buffers only, as an OpenGL core profile would")
+	skyboxPath := flag.String("skybox", "", "draw this skybox behind the stars, one image of the faces as a cross or six with * for their names")
+	flag.Parse()
+
 	if sdl.Init(sdl.INIT_VIDEO) < 0 {
 		panic("Video initialization failed: " + sdl.GetError())
 	}
@@ -274,8 +298,24 @@ func main() {
 	}
 
 	sdl.GL_SetAttribute(sdl.GL_DOUBLEBUFFER, 1)
+
+	// Everything is drawn through the renderer from here on
+	if *core {
+		renderer = glrender.NewCore()
+	} else {
+		renderer = glrender.NewLegacy()
+	}
+
 	initGL()
 	initStars()
+
+	if *skyboxPath != "" {
+		var err error
+		if skybox, err = LoadSkybox(*skyboxPath); err != nil {
+			fmt.Println("Could not load the skybox:", err)
+			Quit(1)
+		}
+	}
 	p(1)
 
 	resizeWindow(SCREEN_WIDTH, SCREEN_HEIGHT)
@@ -0,0 +1,699 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/banthar/gl"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// A binary world file stores a world ready to be used, so loading it is
+// little more than copying numbers. All values are little endian:
+//
+//	header     magic "LW10", version, number of materials, vertices,
+//	           triangles and sectors (uint32 each)
+//	materials  name and texture (uint16 length + bytes), color (4 float32),
+//	           blend mode (uint8)
+//	sectors    name (uint16 length + bytes), number of triangles and
+//	           portals (uint32 each), and for each portal the target
+//	           sector and number of corners (uint32 each), followed by
+//	           the corners (3 float32 each)
+//	vertices   x y z u v nx ny nz (float32 each)
+//	indices    3 vertex indices per triangle (uint32 each)
+//	material   index per triangle (int32), -1 for the default material
+//	bsp        for each sector the number of BSP nodes and of vertices
+//	           made by cutting triangles (uint32 each), those vertices
+//	           (8 float32 each), and the nodes in preorder: the plane
+//	           (normal and distance, 4 float32), the number of triangles
+//	           (uint32), for each the index of the sector triangle it
+//	           comes from (uint32) and whether it is a piece of it (uint8)
+//	           with the 3 indices of its vertices (uint32 each) if it is,
+//	           and which children follow (uint8, 1 front, 2 back)
+//	lights     number of lights (uint32), and for each its position,
+//	           color and radius (7 float32)
+//	entities   number of entities (uint32), and for each its kind (uint8),
+//	           name (uint16 length + bytes), sector and number of
+//	           triangles (uint32 each), the index of each triangle in the
+//	           sector (uint32), the move from closed to open, speed and
+//	           how far open it is (5 float32), the corners of the trigger
+//	           box (6 float32) and the entity it opens (int32, -1 for none)
+//	fog        whether the world has fog (uint8), and if it has its mode
+//	           (uint8), density, start, end and color (6 float32)
+//	checksum   CRC-32 (IEEE) of everything before it (uint32)
+//
+// Triangles are stored sector by sector. Version 1 files have no sector
+// count or table, and load as a single sector. Materials in files before
+// version 3 have no blend mode, and are blended if they are see-through.
+// Files before version 4 have no BSP trees, they are built when needed,
+// files before version 5 have no lights, before version 6 no entities and
+// before version 7 no fog.
+// Pickups already taken aren't stored, their triangles are gone.
+const (
+	BINARY_WORLD_MAGIC   = "LW10"
+	BINARY_WORLD_VERSION = 7
+
+	binaryHeaderSize = 4 * 5
+	binaryVertexSize = 4 * 8
+	binaryLightSize  = 4 * 7
+	binaryEntitySize = 1 + 2 + 4 + 4 + 4*5 + 4*6 + 4 // without name and triangles
+)
+
+var (
+	errNotBinaryWorld = errors.New("not a binary world file")
+	errBadChecksum    = errors.New("checksum mismatch, the file is damaged")
+)
+
+// Load a binary world file. Texture paths are relative to its directory.
+func LoadBinaryWorld(path string) (*World, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	world, err := ReadBinaryWorld(file, filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return world, nil
+}
+
+// Write a world as binary world. Texture paths are made relative to dir,
+// the directory the file is written to.
+func WriteBinaryWorld(w io.Writer, world *World, dir string) error {
+	triangles := world.Triangles()
+	vertices, indices := distinctVertices(triangles)
+
+	// the default material isn't stored, it's whatever the reader uses
+	var materials []*Material
+	materialIndex := map[*Material]int32{nil: -1}
+	for _, triangle := range triangles {
+		if _, ok := materialIndex[triangle.material]; !ok {
+			materialIndex[triangle.material] = int32(len(materials))
+			materials = append(materials, triangle.material)
+		}
+	}
+
+	sectorIndex := map[*Sector]uint32{}
+	for i, sector := range world.sectors {
+		sectorIndex[sector] = uint32(i)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(BINARY_WORLD_MAGIC)
+	binary.Write(&buf, binary.LittleEndian, []uint32{
+		BINARY_WORLD_VERSION, uint32(len(materials)), uint32(len(vertices)),
+		uint32(len(triangles)), uint32(len(world.sectors)),
+	})
+
+	writeString := func(s string) error {
+		if len(s) > math.MaxUint16 {
+			return fmt.Errorf("%q is too long", s)
+		}
+		binary.Write(&buf, binary.LittleEndian, uint16(len(s)))
+		buf.WriteString(s)
+		return nil
+	}
+
+	for _, material := range materials {
+		texture := material.texture
+		if texture != "" {
+			relative, err := relativePath(dir, texture)
+			if err != nil {
+				return err
+			}
+			texture = filepath.ToSlash(relative)
+		}
+
+		if err := writeString(material.name); err != nil {
+			return err
+		}
+		if err := writeString(texture); err != nil {
+			return err
+		}
+		binary.Write(&buf, binary.LittleEndian, material.color)
+		binary.Write(&buf, binary.LittleEndian, uint8(material.blend))
+	}
+
+	for _, sector := range world.sectors {
+		if err := writeString(sector.name); err != nil {
+			return err
+		}
+		binary.Write(&buf, binary.LittleEndian, []uint32{uint32(len(sector.triangles)), uint32(len(sector.portals))})
+		for _, portal := range sector.portals {
+			target, ok := sectorIndex[portal.target]
+			if !ok {
+				return fmt.Errorf("sector %q has a portal leading out of the world", sector.name)
+			}
+			binary.Write(&buf, binary.LittleEndian, []uint32{target, uint32(len(portal.points))})
+			binary.Write(&buf, binary.LittleEndian, portal.points)
+		}
+	}
+
+	for _, v := range vertices {
+		binary.Write(&buf, binary.LittleEndian, [8]gl.GLfloat{v.x, v.y, v.z, v.u, v.v, v.nx, v.ny, v.nz})
+	}
+
+	for _, triangle := range triangles {
+		for _, vertex := range triangle.vertices {
+			binary.Write(&buf, binary.LittleEndian, uint32(indices[vertex]))
+		}
+	}
+
+	for _, triangle := range triangles {
+		binary.Write(&buf, binary.LittleEndian, materialIndex[triangle.material])
+	}
+
+	for _, sector := range world.sectors {
+		writeBSP(&buf, sector)
+	}
+
+	binary.Write(&buf, binary.LittleEndian, uint32(len(world.lights)))
+	for _, light := range world.lights {
+		binary.Write(&buf, binary.LittleEndian, [7]float32{
+			float32(light.pos[0]), float32(light.pos[1]), float32(light.pos[2]),
+			float32(light.color[0]), float32(light.color[1]), float32(light.color[2]),
+			float32(light.radius),
+		})
+	}
+
+	if err := writeEntities(&buf, world, sectorIndex, writeString); err != nil {
+		return err
+	}
+
+	if fog := world.fog; fog != nil {
+		buf.Write([]byte{1, uint8(fog.mode)})
+		binary.Write(&buf, binary.LittleEndian, [6]float32{
+			float32(fog.density), float32(fog.start), float32(fog.end),
+			fog.color[0], fog.color[1], fog.color[2],
+		})
+	} else {
+		buf.WriteByte(0)
+	}
+
+	binary.Write(&buf, binary.LittleEndian, crc32.ChecksumIEEE(buf.Bytes()))
+
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// Read a binary world. All vertices and triangles are allocated in one
+// block each, so big worlds don't turn into lots of small allocations.
+func ReadBinaryWorld(r io.Reader, dir string) (*World, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < binaryHeaderSize+4 || string(data[:4]) != BINARY_WORLD_MAGIC {
+		return nil, errNotBinaryWorld
+	}
+
+	payload, checksum := data[:len(data)-4], data[len(data)-4:]
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(checksum) {
+		return nil, errBadChecksum
+	}
+
+	d := &binaryDecoder{data: payload, offset: 4}
+	version := d.uint32()
+	if version < 1 || version > BINARY_WORLD_VERSION {
+		return nil, fmt.Errorf("unsupported binary world version %d, want at most %d", version, BINARY_WORLD_VERSION)
+	}
+	nMaterials, nVertices, nTriangles := int(d.uint32()), int(d.uint32()), int(d.uint32())
+	nSectors := 1
+	if version >= 2 {
+		nSectors = int(d.uint32())
+	}
+
+	materials := []*Material{}
+	for i := 0; i < nMaterials && d.err == nil; i++ {
+		material := &Material{name: d.string(), texture: d.string()}
+		if material.texture != "" {
+			material.texture = filepath.Join(dir, filepath.FromSlash(material.texture))
+		}
+		for c := range material.color {
+			material.color[c] = d.float()
+		}
+		if version >= 3 {
+			material.blend = int(d.uint8())
+			if material.blend >= len(blendNames) {
+				return nil, fmt.Errorf("material %q has unknown blend mode %d", material.name, material.blend)
+			}
+		} else if material.color[3] < 1 {
+			material.blend = BLEND_ALPHA
+		}
+		materials = append(materials, material)
+	}
+
+	// every sector takes at least 10 bytes, which keeps a damaged count
+	// from allocating lots of memory
+	if nSectors < 0 || nSectors > (len(payload)-d.offset)/10+1 {
+		return nil, fmt.Errorf("invalid number of sectors %d", nSectors)
+	}
+
+	// sectors are created up front, as portals may lead to later ones
+	world := &World{sectors: make([]*Sector, nSectors)}
+	for i := range world.sectors {
+		world.sectors[i] = &Sector{name: "default"}
+	}
+
+	counts := []int{nTriangles} // triangles in each sector
+	if version >= 2 {
+		counts = make([]int, nSectors)
+		for i, sector := range world.sectors {
+			sector.name = d.string()
+			counts[i] = int(d.uint32())
+			nPortals := int(d.uint32())
+			for j := 0; j < nPortals && d.err == nil; j++ {
+				target, nPoints := int(d.uint32()), int(d.uint32())
+				if target >= nSectors || nPoints < 3 || nPoints*12 > len(payload)-d.offset {
+					return nil, fmt.Errorf("sector %q has an invalid portal", sector.name)
+				}
+				portal := &Portal{target: world.sectors[target], points: make([][3]gl.GLfloat, nPoints)}
+				for k := range portal.points {
+					portal.points[k] = [3]gl.GLfloat{d.float(), d.float(), d.float()}
+				}
+				sector.portals = append(sector.portals, portal)
+			}
+		}
+	}
+
+	// check the sizes before allocating anything based on them
+	need := nVertices*binaryVertexSize + nTriangles*4*4
+	left := len(payload) - d.offset
+	if version >= 4 && left > need {
+		// the BSP trees follow
+		left = need
+	}
+	if d.err != nil || nVertices < 0 || nTriangles < 0 || left != need {
+		return nil, fmt.Errorf("expected %d vertices and %d triangles, but the size doesn't match", nVertices, nTriangles)
+	}
+
+	vertices := make([]Vertex, nVertices)
+	for i := range vertices {
+		v := &vertices[i]
+		v.x, v.y, v.z = d.float(), d.float(), d.float()
+		v.u, v.v = d.float(), d.float()
+		v.nx, v.ny, v.nz = d.float(), d.float(), d.float()
+	}
+
+	triangles := make([]Triangle, nTriangles)
+	for i := range triangles {
+		for c := range triangles[i].vertices {
+			index := int(d.uint32())
+			if index >= nVertices {
+				return nil, fmt.Errorf("triangle %d uses vertex %d of %d", i, index, nVertices)
+			}
+			triangles[i].vertices[c] = &vertices[index]
+		}
+	}
+
+	for i := range triangles {
+		index := int(int32(d.uint32()))
+		if index >= nMaterials || index < -1 {
+			return nil, fmt.Errorf("triangle %d uses material %d of %d", i, index, nMaterials)
+		}
+		if index >= 0 {
+			triangles[i].material = materials[index]
+		}
+	}
+
+	// hand out the triangles to the sectors in order
+	next := 0
+	for i, sector := range world.sectors {
+		if counts[i] < 0 || counts[i] > len(triangles)-next {
+			return nil, fmt.Errorf("sector %q has more triangles than the world", sector.name)
+		}
+		sector.triangles = make([]*Triangle, counts[i])
+		for j := range sector.triangles {
+			sector.triangles[j] = &triangles[next]
+			next++
+		}
+	}
+	if next != len(triangles) {
+		return nil, fmt.Errorf("%d triangles don't belong to any sector", len(triangles)-next)
+	}
+
+	if version >= 4 {
+		for _, sector := range world.sectors {
+			if err := readBSP(d, sector); err != nil {
+				return nil, fmt.Errorf("sector %q: %v", sector.name, err)
+			}
+		}
+	}
+
+	if version >= 5 {
+		nLights := int(d.uint32())
+		if nLights*binaryLightSize > len(payload)-d.offset {
+			return nil, fmt.Errorf("invalid number of lights %d", nLights)
+		}
+		for i := 0; i < nLights && d.err == nil; i++ {
+			light := &Light{}
+			for c := range light.pos {
+				light.pos[c] = float64(d.float())
+			}
+			for c := range light.color {
+				light.color[c] = float64(d.float())
+			}
+			if light.radius = float64(d.float()); !(light.radius > 0) {
+				return nil, fmt.Errorf("light %d has a radius of %v", i, light.radius)
+			}
+			world.lights = append(world.lights, light)
+		}
+	}
+
+	if version >= 6 {
+		if err := readEntities(d, world); err != nil {
+			return nil, err
+		}
+	}
+
+	if version >= 7 && d.uint8() != 0 {
+		fog := defaultFog
+		if fog.mode = int(d.uint8()); fog.mode >= len(fogNames) {
+			return nil, fmt.Errorf("unknown fog mode %d", fog.mode)
+		}
+		fog.density, fog.start, fog.end = float64(d.float()), float64(d.float()), float64(d.float())
+		for c := range fog.color {
+			fog.color[c] = float32(d.float())
+		}
+		if !(fog.density >= 0) || !(fog.end > fog.start) {
+			return nil, fmt.Errorf("invalid fog of density %v from %v to %v", fog.density, fog.start, fog.end)
+		}
+		world.fog = &fog
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	if d.offset != len(payload) {
+		return nil, fmt.Errorf("%d bytes too many", len(payload)-d.offset)
+	}
+
+	return world, nil
+}
+
+// Write the entities of a world, but for pickups already taken
+func writeEntities(buf *bytes.Buffer, world *World, sectorIndex map[*Sector]uint32, writeString func(string) error) error {
+	var entities []*Entity
+	entityIndex := map[*Entity]int32{nil: -1}
+	for _, e := range world.entities {
+		if !e.taken {
+			entityIndex[e] = int32(len(entities))
+			entities = append(entities, e)
+		}
+	}
+
+	binary.Write(buf, binary.LittleEndian, uint32(len(entities)))
+	for _, e := range entities {
+		sector, ok := sectorIndex[e.sector]
+		if !ok {
+			return fmt.Errorf("%s %s is in no sector of the world", entityNames[e.kind], e.name)
+		}
+		triangleIndex := map[*Triangle]uint32{}
+		for i, triangle := range e.sector.triangles {
+			triangleIndex[triangle] = uint32(i)
+		}
+
+		buf.WriteByte(uint8(e.kind))
+		if err := writeString(e.name); err != nil {
+			return err
+		}
+		binary.Write(buf, binary.LittleEndian, []uint32{sector, uint32(len(e.triangles))})
+		for _, triangle := range e.triangles {
+			i, ok := triangleIndex[triangle]
+			if !ok {
+				return fmt.Errorf("%s %s has a triangle outside its sector", entityNames[e.kind], e.name)
+			}
+			binary.Write(buf, binary.LittleEndian, i)
+		}
+		binary.Write(buf, binary.LittleEndian, [11]float32{
+			float32(e.move[0]), float32(e.move[1]), float32(e.move[2]), float32(e.speed), float32(e.position),
+			float32(e.box.min[0]), float32(e.box.min[1]), float32(e.box.min[2]),
+			float32(e.box.max[0]), float32(e.box.max[1]), float32(e.box.max[2]),
+		})
+		target, ok := entityIndex[e.target]
+		if !ok {
+			return fmt.Errorf("trigger %s opens an entity outside the world", e.name)
+		}
+		binary.Write(buf, binary.LittleEndian, target)
+	}
+	return nil
+}
+
+// Read the entities of a world, checking them as the world parser does
+func readEntities(d *binaryDecoder, world *World) error {
+	nEntities := int(d.uint32())
+	if nEntities < 0 || nEntities*binaryEntitySize > len(d.data)-d.offset {
+		return fmt.Errorf("invalid number of entities %d", nEntities)
+	}
+
+	targets := make([]int, nEntities)
+	for i := 0; i < nEntities && d.err == nil; i++ {
+		e := &Entity{kind: int(d.uint8()), name: d.string()}
+		if e.kind >= len(entityNames) {
+			return fmt.Errorf("entity %q has unknown kind %d", e.name, e.kind)
+		}
+		if world.Entity(e.name) != nil {
+			return fmt.Errorf("entity %q exists twice", e.name)
+		}
+		what := entityNames[e.kind] + " " + e.name
+
+		sector, nTriangles := int(d.uint32()), int(d.uint32())
+		if sector >= len(world.sectors) {
+			return fmt.Errorf("%s is in sector %d of %d", what, sector, len(world.sectors))
+		}
+		e.sector = world.sectors[sector]
+		if nTriangles > len(e.sector.triangles) || (nTriangles == 0) != (e.kind == ENTITY_TRIGGER) {
+			return fmt.Errorf("%s has %d triangles", what, nTriangles)
+		}
+		for j := 0; j < nTriangles && d.err == nil; j++ {
+			index := int(d.uint32())
+			if index >= len(e.sector.triangles) || e.sector.triangles[index].entity != nil {
+				return fmt.Errorf("%s has an invalid triangle %d", what, index)
+			}
+			triangle := e.sector.triangles[index]
+			triangle.entity = e
+			e.triangles = append(e.triangles, triangle)
+		}
+
+		e.move = vec3{float64(d.float()), float64(d.float()), float64(d.float())}
+		e.speed, e.position = float64(d.float()), float64(d.float())
+		e.box.min = vec3{float64(d.float()), float64(d.float()), float64(d.float())}
+		e.box.max = vec3{float64(d.float()), float64(d.float()), float64(d.float())}
+		targets[i] = int(int32(d.uint32()))
+		if e.kind == ENTITY_DOOR || e.kind == ENTITY_LIFT {
+			if !(e.move.length() > 0) || !(e.speed > 0) || !(e.position >= 0 && e.position <= 1) {
+				return fmt.Errorf("%s has an invalid move", what)
+			}
+			// one left open when the world was written closes again
+			if e.position > 0 {
+				e.state = MOVER_CLOSING
+			}
+		}
+		world.entities = append(world.entities, e)
+	}
+	if d.err != nil {
+		return d.err
+	}
+
+	for i, e := range world.entities {
+		if target := targets[i]; e.kind != ENTITY_TRIGGER {
+			if target != -1 {
+				return fmt.Errorf("%s %s opens another entity", entityNames[e.kind], e.name)
+			}
+		} else if target < 0 || target >= nEntities || world.entities[target].kind != ENTITY_DOOR && world.entities[target].kind != ENTITY_LIFT {
+			return fmt.Errorf("trigger %s has target %d, which is no door or lift", e.name, target)
+		} else {
+			e.target = world.entities[target]
+		}
+	}
+	return nil
+}
+
+// Write the BSP tree of a sector
+func writeBSP(buf *bytes.Buffer, sector *Sector) {
+	sourceIndex := map[*Triangle]uint32{}
+	for i, triangle := range sector.triangles {
+		sourceIndex[triangle] = uint32(i)
+	}
+
+	// only pieces of cut triangles have vertices of their own
+	var nodes []*BSPNode
+	var vertices []*Vertex
+	vertexIndex := map[*Vertex]uint32{}
+	var collect func(n *BSPNode)
+	collect = func(n *BSPNode) {
+		if n == nil {
+			return
+		}
+		nodes = append(nodes, n)
+		for _, triangle := range n.triangles {
+			if triangle.Triangle == triangle.source {
+				continue
+			}
+			for _, vertex := range triangle.vertices {
+				if _, ok := vertexIndex[vertex]; !ok {
+					vertexIndex[vertex] = uint32(len(vertices))
+					vertices = append(vertices, vertex)
+				}
+			}
+		}
+		collect(n.front)
+		collect(n.back)
+	}
+	collect(sector.BSP())
+
+	binary.Write(buf, binary.LittleEndian, []uint32{uint32(len(nodes)), uint32(len(vertices))})
+	for _, v := range vertices {
+		binary.Write(buf, binary.LittleEndian, [8]gl.GLfloat{v.x, v.y, v.z, v.u, v.v, v.nx, v.ny, v.nz})
+	}
+
+	for _, n := range nodes {
+		binary.Write(buf, binary.LittleEndian, [4]float32{
+			float32(n.normal[0]), float32(n.normal[1]), float32(n.normal[2]), float32(n.dist),
+		})
+		binary.Write(buf, binary.LittleEndian, uint32(len(n.triangles)))
+		for _, triangle := range n.triangles {
+			binary.Write(buf, binary.LittleEndian, sourceIndex[triangle.source])
+			if triangle.Triangle == triangle.source {
+				buf.WriteByte(0)
+				continue
+			}
+			buf.WriteByte(1)
+			for _, vertex := range triangle.vertices {
+				binary.Write(buf, binary.LittleEndian, vertexIndex[vertex])
+			}
+		}
+
+		var children uint8
+		if n.front != nil {
+			children |= 1
+		}
+		if n.back != nil {
+			children |= 2
+		}
+		buf.WriteByte(children)
+	}
+}
+
+// Read the BSP tree of a sector written by writeBSP
+func readBSP(d *binaryDecoder, sector *Sector) error {
+	nNodes, nVertices := int(d.uint32()), int(d.uint32())
+	// every node takes at least 21 bytes and every vertex 32
+	left := len(d.data) - d.offset
+	if d.err != nil || nNodes < 0 || nVertices < 0 || nNodes > left/21 || nVertices > left/binaryVertexSize {
+		return errors.New("invalid BSP tree size")
+	}
+
+	vertices := make([]Vertex, nVertices)
+	for i := range vertices {
+		v := &vertices[i]
+		v.x, v.y, v.z = d.float(), d.float(), d.float()
+		v.u, v.v = d.float(), d.float()
+		v.nx, v.ny, v.nz = d.float(), d.float(), d.float()
+	}
+
+	read := 0
+	var node func() (*BSPNode, error)
+	node = func() (*BSPNode, error) {
+		if read == nNodes {
+			return nil, errors.New("BSP tree has more nodes than stored")
+		}
+		read++
+
+		n := &BSPNode{normal: vec3{float64(d.float()), float64(d.float()), float64(d.float())}}
+		n.dist = float64(d.float())
+		count := int(d.uint32())
+		if d.err != nil || count < 0 || count > (len(d.data)-d.offset)/5 {
+			return nil, errors.New("invalid BSP node")
+		}
+		for i := 0; i < count; i++ {
+			index := int(d.uint32())
+			if index >= len(sector.triangles) {
+				return nil, fmt.Errorf("BSP node uses triangle %d of %d", index, len(sector.triangles))
+			}
+			source := sector.triangles[index]
+			triangle := bspTriangle{source, source}
+			if d.uint8() != 0 {
+				triangle.Triangle = &Triangle{material: source.material}
+				for c := range triangle.vertices {
+					index := int(d.uint32())
+					if index >= nVertices {
+						return nil, fmt.Errorf("BSP node uses vertex %d of %d", index, nVertices)
+					}
+					triangle.vertices[c] = &vertices[index]
+				}
+			}
+			n.triangles = append(n.triangles, triangle)
+		}
+
+		children := d.uint8()
+		var err error
+		if children&1 != 0 {
+			if n.front, err = node(); err != nil {
+				return nil, err
+			}
+		}
+		if children&2 != 0 {
+			if n.back, err = node(); err != nil {
+				return nil, err
+			}
+		}
+		return n, d.err
+	}
+
+	if nNodes == 0 {
+		return d.err
+	}
+	var err error
+	if sector.bsp, err = node(); err != nil {
+		return err
+	}
+	if read != nNodes {
+		return fmt.Errorf("BSP tree has %d nodes, %d stored", read, nNodes)
+	}
+	return nil
+}
+
+// binaryDecoder reads little endian values from a byte slice. Reading past
+// the end sets err and returns zeros from then on.
+type binaryDecoder struct {
+	data   []byte
+	offset int
+	err    error
+}
+
+func (d *binaryDecoder) next(n int) []byte {
+	if d.err != nil || d.offset+n > len(d.data) {
+		d.err = io.ErrUnexpectedEOF
+		return make([]byte, n)
+	}
+	b := d.data[d.offset : d.offset+n]
+	d.offset += n
+	return b
+}
+
+func (d *binaryDecoder) uint8() uint8 {
+	return d.next(1)[0]
+}
+
+func (d *binaryDecoder) uint32() uint32 {
+	return binary.LittleEndian.Uint32(d.next(4))
+}
+
+func (d *binaryDecoder) float() gl.GLfloat {
+	return gl.GLfloat(math.Float32frombits(d.uint32()))
+}
+
+func (d *binaryDecoder) string() string {
+	n := int(binary.LittleEndian.Uint16(d.next(2)))
+	return string(d.next(n))
+}
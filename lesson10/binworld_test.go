@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// Compile the world at path to a binary world in memory
+func compileWorld(tb testing.TB, path string) (*World, []byte) {
+	world, err := LoadWorld(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteBinaryWorld(&buf, world, "data"); err != nil {
+		tb.Fatal(err)
+	}
+	return world, buf.Bytes()
+}
+
+func TestBinaryWorldRoundTrip(t *testing.T) {
+	for _, name := range []string{"world.txt", "sectors.txt", "levels.txt"} {
+		world, data := compileWorld(t, "data/"+name)
+		loaded, err := ReadBinaryWorld(bytes.NewReader(data), "data")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if len(loaded.sectors) != len(world.sectors) {
+			t.Fatalf("%s: got %d sectors back, want %d", name, len(loaded.sectors), len(world.sectors))
+		}
+		for s, sector := range world.sectors {
+			got := loaded.sectors[s]
+			if got.name != sector.name || len(got.triangles) != len(sector.triangles) || len(got.portals) != len(sector.portals) {
+				t.Errorf("%s: got sector %s with %d triangles and %d portals, want %s with %d and %d", name,
+					got.name, len(got.triangles), len(got.portals), sector.name, len(sector.triangles), len(sector.portals))
+				continue
+			}
+			for i, triangle := range sector.triangles {
+				if err := sameTriangle(got.triangles[i], triangle); err != "" {
+					t.Errorf("%s: sector %s triangle %d: %s", name, sector.name, i, err)
+					break
+				}
+			}
+			for i, portal := range sector.portals {
+				if got.portals[i].target.name != portal.target.name {
+					t.Errorf("%s: sector %s portal %d leads to %s, want %s", name, sector.name, i, got.portals[i].target.name, portal.target.name)
+				}
+			}
+			if got.bsp == nil {
+				t.Errorf("%s: sector %s has no BSP tree", name, sector.name)
+			}
+		}
+
+		if len(loaded.lights) != len(world.lights) {
+			t.Errorf("%s: got %d lights back, want %d", name, len(loaded.lights), len(world.lights))
+		} else {
+			for i, light := range world.lights {
+				got := loaded.lights[i]
+				if got.pos != light.pos || got.color != light.color || got.radius != light.radius {
+					t.Errorf("%s: got light %d %+v, want %+v", name, i, got, light)
+				}
+			}
+		}
+
+		if len(loaded.entities) != len(world.entities) {
+			t.Errorf("%s: got %d entities back, want %d", name, len(loaded.entities), len(world.entities))
+		} else {
+			for i, e := range world.entities {
+				got := loaded.entities[i]
+				if got.kind != e.kind || got.name != e.name || got.sector.name != e.sector.name ||
+					got.move != e.move || got.speed != e.speed || got.box != e.box || len(got.triangles) != len(e.triangles) {
+					t.Errorf("%s: got entity %d %s %s, want %s %s", name, i, entityNames[got.kind], got.name, entityNames[e.kind], e.name)
+					continue
+				}
+				for j, triangle := range got.triangles {
+					if triangle.entity != got || sameTriangle(triangle, e.triangles[j]) != "" {
+						t.Errorf("%s: %s triangle %d isn't the one written", name, e.name, j)
+					}
+				}
+				if e.target != nil && (got.target == nil || got.target.name != e.target.name) {
+					t.Errorf("%s: trigger %s doesn't open %s", name, e.name, e.target.name)
+				}
+			}
+		}
+
+		// writing it again gives the same file
+		var again bytes.Buffer
+		if err := WriteBinaryWorld(&again, loaded, "data"); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(again.Bytes(), data) {
+			t.Errorf("%s: the binary world changed after reading it", name)
+		}
+	}
+}
+
+func TestBinaryWorldFog(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"none", twoTriangles},
+		{"linear", "FOG linear 0.1 1 10 0.5 0.25 0\n" + twoTriangles},
+		{"exp2", "FOG exp2 0.35 0 20\n" + twoTriangles},
+	}
+
+	for _, test := range tests {
+		world, err := ParseWorld(strings.NewReader(test.src), "data")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := WriteBinaryWorld(&buf, world, "data"); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := ReadBinaryWorld(&buf, "data")
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		got, want := loaded.fog, world.fog
+		if (got == nil) != (want == nil) {
+			t.Errorf("%s: got fog %v, want %v", test.name, got != nil, want != nil)
+		} else if want != nil && (got.mode != want.mode || got.density != want.density ||
+			got.start != want.start || got.end != want.end || got.color != want.color) {
+			t.Errorf("%s: got fog %+v, want %+v", test.name, got, want)
+		}
+	}
+}
+
+// Every changed byte is caught by the checksum
+func TestBinaryWorldCorrupted(t *testing.T) {
+	_, data := compileWorld(t, "data/sectors.txt")
+	damaged := make([]byte, len(data))
+
+	for i := range data {
+		copy(damaged, data)
+		damaged[i] ^= 0x10
+		_, err := ReadBinaryWorld(bytes.NewReader(damaged), "data")
+		if i < len(BINARY_WORLD_MAGIC) {
+			if err != errNotBinaryWorld {
+				t.Errorf("byte %d of the magic changed: got error %v, want %v", i, err, errNotBinaryWorld)
+			}
+		} else if err != errBadChecksum {
+			t.Errorf("byte %d changed: got error %v, want %v", i, err, errBadChecksum)
+		}
+	}
+}
+
+// Damage that the checksum was updated for, as a buggy writer would make,
+// is an error too, not a crash
+func TestBinaryWorldInconsistent(t *testing.T) {
+	_, data := compileWorld(t, "data/sectors.txt")
+	damaged := make([]byte, len(data))
+	random := rand.New(rand.NewSource(1))
+
+	for i := len(BINARY_WORLD_MAGIC); i < len(data)-4; i++ {
+		copy(damaged, data)
+		damaged[i] = byte(random.Intn(256))
+		payload := damaged[:len(damaged)-4]
+		binary.LittleEndian.PutUint32(damaged[len(payload):], crc32.ChecksumIEEE(payload))
+
+		// most bytes are coordinates that may change freely, so only
+		// check this doesn't panic
+		ReadBinaryWorld(bytes.NewReader(damaged), "data")
+	}
+}
+
+func TestBinaryWorldTruncated(t *testing.T) {
+	_, data := compileWorld(t, "data/world.txt")
+
+	for n := 0; n < len(data); n++ {
+		if _, err := ReadBinaryWorld(bytes.NewReader(data[:n]), "data"); err == nil {
+			t.Fatalf("the first %d of %d bytes loaded without error", n, len(data))
+		}
+	}
+
+	// with a matching checksum the sizes still have to add up
+	for n := binaryHeaderSize; n < len(data)-4; n++ {
+		truncated := append(append([]byte{}, data[:n]...), 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(truncated[n:], crc32.ChecksumIEEE(truncated[:n]))
+		if _, err := ReadBinaryWorld(bytes.NewReader(truncated), "data"); err == nil {
+			t.Fatalf("the first %d of %d bytes with a checksum loaded without error", n, len(data))
+		}
+	}
+}
+
+// Compare loading a big world from its text and its binary form, both
+// from memory to leave the disk out
+func benchmarkLoading(b *testing.B, binaryWorld bool) {
+	world := randomWorld(100000, rand.New(rand.NewSource(1)))
+
+	var buf bytes.Buffer
+	write, read := WriteWorld, ParseWorld
+	if binaryWorld {
+		write, read = WriteBinaryWorld, ReadBinaryWorld
+	}
+	if err := write(&buf, world, "data"); err != nil {
+		b.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := read(bytes.NewReader(data), "data"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoadTextWorld(b *testing.B) {
+	benchmarkLoading(b, false)
+}
+
+func BenchmarkLoadBinaryWorld(b *testing.B) {
+	benchmarkLoading(b, true)
+}
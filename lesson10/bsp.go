@@ -0,0 +1,314 @@
+package main
+
+import (
+	"github.com/banthar/gl"
+	"math"
+)
+
+const (
+	// points closer to a plane than this are on it
+	BSP_EPSILON = 1e-5
+
+	// how many triangles are tried as splitting plane for each node
+	BSP_CANDIDATES = 32
+
+	// how much worse a split triangle is than uneven sides
+	BSP_SPLIT_COST = 8
+)
+
+// A BSPNode is a node of a binary space partitioning tree. Its plane
+// splits space in two: everything in front of it is in the front subtree,
+// everything behind it in the back one, and the triangles lying in the
+// plane are kept at the node. Triangles crossing the plane are split.
+//
+// A missing front child is open space, a missing back child is solid, so
+// triangles need to face the open space for Solid to make sense. Drawing
+// order and ray queries work no matter which way triangles face.
+type BSPNode struct {
+	normal      vec3
+	dist        float64 // of the plane from the origin, along normal
+	triangles   []bspTriangle
+	front, back *BSPNode
+}
+
+// A triangle in a BSP tree, and the triangle it was cut from
+type bspTriangle struct {
+	*Triangle
+	source *Triangle
+}
+
+// Build a BSP tree of the triangles. Triangles crossing a splitting plane
+// are cut into new triangles, the ones given aren't changed.
+func CompileBSP(triangles []*Triangle) *BSPNode {
+	// triangles without area have no plane and can't be seen anyway
+	var valid []bspTriangle
+	for _, triangle := range triangles {
+		if triangle.faceNormal() != (vec3{}) {
+			valid = append(valid, bspTriangle{triangle, triangle})
+		}
+	}
+	return compileBSP(valid)
+}
+
+func compileBSP(triangles []bspTriangle) *BSPNode {
+	if len(triangles) == 0 {
+		return nil
+	}
+
+	splitter := chooseSplitter(triangles)
+	normal := splitter.faceNormal()
+	node := &BSPNode{normal: normal, dist: normal.dot(splitter.vertices[0].pos())}
+
+	var front, back []bspTriangle
+	for _, triangle := range triangles {
+		switch node.classify(triangle.Triangle) {
+		case 0:
+			node.triangles = append(node.triangles, triangle)
+		case 1:
+			front = append(front, triangle)
+		case -1:
+			back = append(back, triangle)
+		default:
+			f, b := node.split(triangle)
+			front = append(front, f...)
+			back = append(back, b...)
+		}
+	}
+
+	node.front = compileBSP(front)
+	node.back = compileBSP(back)
+	return node
+}
+
+// Pick the triangle whose plane splits the fewest triangles while keeping
+// both sides about even, trying a few spread over the list
+func chooseSplitter(triangles []bspTriangle) *Triangle {
+	step := len(triangles)/BSP_CANDIDATES + 1
+
+	var best *Triangle
+	bestCost := math.MaxInt32
+	for i := 0; i < len(triangles); i += step {
+		normal := triangles[i].faceNormal()
+		node := &BSPNode{normal: normal, dist: normal.dot(triangles[i].vertices[0].pos())}
+
+		var front, back, splits int
+		for _, triangle := range triangles {
+			switch node.classify(triangle.Triangle) {
+			case 1:
+				front++
+			case -1:
+				back++
+			case 2:
+				splits++
+			}
+		}
+
+		balance := front - back
+		if balance < 0 {
+			balance = -balance
+		}
+		if cost := splits*BSP_SPLIT_COST + balance; cost < bestCost {
+			best, bestCost = triangles[i].Triangle, cost
+		}
+	}
+
+	return best
+}
+
+// The distance of p in front of the plane of the node, negative behind it
+func (n *BSPNode) distance(p vec3) float64 {
+	return n.normal.dot(p) - n.dist
+}
+
+// Where a triangle is: 0 in the plane, 1 in front, -1 behind, 2 on both sides
+func (n *BSPNode) classify(triangle *Triangle) int {
+	var front, back bool
+	for _, vertex := range triangle.vertices {
+		d := n.distance(vertex.pos())
+		front = front || d > BSP_EPSILON
+		back = back || d < -BSP_EPSILON
+	}
+
+	switch {
+	case front && back:
+		return 2
+	case front:
+		return 1
+	case back:
+		return -1
+	}
+	return 0
+}
+
+// Cut a triangle crossing the plane into the triangles in front and behind
+func (n *BSPNode) split(triangle bspTriangle) (front, back []bspTriangle) {
+	var frontCorners, backCorners []*Vertex
+
+	for i, a := range triangle.vertices {
+		b := triangle.vertices[(i+1)%3]
+		da, db := n.distance(a.pos()), n.distance(b.pos())
+
+		if da >= -BSP_EPSILON {
+			frontCorners = append(frontCorners, a)
+		}
+		if da <= BSP_EPSILON {
+			backCorners = append(backCorners, a)
+		}
+
+		if da > BSP_EPSILON && db < -BSP_EPSILON || da < -BSP_EPSILON && db > BSP_EPSILON {
+			v := lerpVertex(a, b, da/(da-db))
+			frontCorners = append(frontCorners, v)
+			backCorners = append(backCorners, v)
+		}
+	}
+
+	return fan(triangle, frontCorners), fan(triangle, backCorners)
+}
+
+// A vertex part of the way from a to b
+func lerpVertex(a, b *Vertex, t float64) *Vertex {
+	l := func(x, y gl.GLfloat) gl.GLfloat { return x + (y-x)*gl.GLfloat(t) }
+	return &Vertex{
+		x: l(a.x, b.x), y: l(a.y, b.y), z: l(a.z, b.z),
+		u: l(a.u, b.u), v: l(a.v, b.v),
+		nx: l(a.nx, b.nx), ny: l(a.ny, b.ny), nz: l(a.nz, b.nz),
+	}
+}
+
+// Turn a convex polygon cut from a triangle back into triangles like it
+func fan(from bspTriangle, corners []*Vertex) []bspTriangle {
+	var triangles []bspTriangle
+	for i := 2; i < len(corners); i++ {
+		piece := &Triangle{
+			vertices: [3]*Vertex{corners[0], corners[i-1], corners[i]},
+			material: from.material,
+		}
+		triangles = append(triangles, bspTriangle{piece, from.source})
+	}
+	return triangles
+}
+
+// Whether p is in solid space, behind the triangles
+func (n *BSPNode) Solid(p vec3) bool {
+	for n != nil {
+		if n.distance(p) >= 0 {
+			if n.front == nil {
+				return false
+			}
+			n = n.front
+		} else {
+			if n.back == nil {
+				return true
+			}
+			n = n.back
+		}
+	}
+	return false
+}
+
+// Visit all triangles so that the ones further from eye come first, which
+// is the order see-through triangles have to be drawn in
+func (n *BSPNode) BackToFront(eye vec3, visit func(*Triangle)) {
+	if n == nil {
+		return
+	}
+
+	near, far := n.front, n.back
+	if n.distance(eye) < 0 {
+		near, far = far, near
+	}
+
+	far.BackToFront(eye, visit)
+	for _, triangle := range n.triangles {
+		visit(triangle.Triangle)
+	}
+	near.BackToFront(eye, visit)
+}
+
+// Find the first triangle hit on the way from origin along dir, up to a
+// distance of maxDist. Returns the triangle of the sector that was hit,
+// even if the tree holds a piece of it, and the distance to the hit.
+func (n *BSPNode) Trace(origin, dir vec3, maxDist float64) (*Triangle, float64) {
+	// the end of the ray has to be somewhere to tell the sides it is on
+	maxDist = math.Min(maxDist, math.MaxFloat32)
+	return n.trace(origin, dir, 0, maxDist)
+}
+
+func (n *BSPNode) trace(origin, dir vec3, tmin, tmax float64) (*Triangle, float64) {
+	if n == nil || tmin > tmax {
+		return nil, 0
+	}
+
+	da := n.distance(origin.add(dir.scale(tmin)))
+	db := n.distance(origin.add(dir.scale(tmax)))
+
+	near, far := n.front, n.back
+	if da < 0 {
+		near, far = far, near
+	}
+
+	// staying on one side of the plane, nothing here can be hit
+	if da > BSP_EPSILON && db > BSP_EPSILON || da < -BSP_EPSILON && db < -BSP_EPSILON {
+		return near.trace(origin, dir, tmin, tmax)
+	}
+
+	// crossing it, the near side comes first, then the plane, then the far
+	// side
+	if da > BSP_EPSILON && db < -BSP_EPSILON || da < -BSP_EPSILON && db > BSP_EPSILON {
+		split := tmin + (tmax-tmin)*da/(da-db)
+		if hit, t := near.trace(origin, dir, tmin, split); hit != nil {
+			return hit, t
+		}
+		if hit, t := n.traceHere(origin, dir, tmin, tmax); hit != nil {
+			return hit, t
+		}
+		return far.trace(origin, dir, split, tmax)
+	}
+
+	// starting or ending in the plane, or running along it, either side
+	// may be hit anywhere
+	hit, closest := n.traceHere(origin, dir, tmin, tmax)
+	if hit == nil {
+		closest = tmax
+	}
+	for _, child := range []*BSPNode{near, far} {
+		if h, t := child.trace(origin, dir, tmin, closest); h != nil {
+			hit, closest = h, t
+		}
+	}
+	return hit, closest
+}
+
+// The closest of the triangles at this node hit between tmin and tmax
+func (n *BSPNode) traceHere(origin, dir vec3, tmin, tmax float64) (*Triangle, float64) {
+	var hit *Triangle
+	closest := tmax
+	for _, triangle := range n.triangles {
+		if t, ok := rayTriangle(origin, dir, triangle.corners()); ok && t >= tmin && t <= closest {
+			hit, closest = triangle.source, t
+		}
+	}
+	return hit, closest
+}
+
+// The BSP tree of a sector, built when first needed. The triangles of
+// entities are left out, they move.
+func (s *Sector) BSP() *BSPNode {
+	if s.bsp == nil {
+		if triangles := s.staticTriangles(); len(triangles) > 0 {
+			s.bsp = CompileBSP(triangles)
+		}
+	}
+	return s.bsp
+}
+
+// Throw away the BSP trees, BVHs and meshes after the triangles changed
+func (w *World) Invalidate() {
+	for _, sector := range w.sectors {
+		sector.bsp, sector.index = nil, nil
+		sector.dropMeshes()
+	}
+	for _, e := range w.entities {
+		e.dropMeshes()
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// Rays in many directions, spread over the sphere
+func testDirections(n int) []vec3 {
+	dirs := make([]vec3, n)
+	for i := range dirs {
+		// a spiral from pole to pole
+		y := 1 - 2*(float64(i)+0.5)/float64(n)
+		r := math.Sqrt(1 - y*y)
+		a := float64(i) * math.Pi * (3 - math.Sqrt(5))
+		dirs[i] = vec3{r * math.Cos(a), y, r * math.Sin(a)}
+	}
+	return dirs
+}
+
+// places to look from in the hallway world: the middle of the room, its
+// corners, in two doorways, a hallway, outside and above
+var testEyes = []vec3{
+	{0, 0.25, 0}, {1.5, 0.5, 1.5}, {-1.9, 0.1, -1.9}, {0, 0.5, 2}, {-2, 0.3, 0.1},
+	{0.2, 0.7, -2.6}, {-5, 0.5, 0.3}, {0.1, 3, 0.2},
+}
+
+func TestCompileBSP(t *testing.T) {
+	world, sector := loadHallway(t)
+
+	// every triangle is in the tree, maybe cut into pieces with the same
+	// area in total
+	area := func(tri [3]vec3) float64 {
+		return tri[1].sub(tri[0]).cross(tri[2].sub(tri[0])).length() / 2
+	}
+	pieces := map[*Triangle]float64{}
+	var walk func(n *BSPNode)
+	walk = func(n *BSPNode) {
+		if n == nil {
+			return
+		}
+		for _, triangle := range n.triangles {
+			if math.Abs(n.distance(triangle.Triangle.corners()[0])) > BSP_EPSILON {
+				t.Errorf("a triangle at a node isn't in its plane")
+			}
+			pieces[triangle.source] += area(triangle.corners())
+		}
+		walk(n.front)
+		walk(n.back)
+	}
+	walk(sector.BSP())
+
+	if len(pieces) != len(sector.triangles) {
+		t.Fatalf("the tree holds %d triangles, want %d", len(pieces), len(world.Triangles()))
+	}
+	for i, triangle := range sector.triangles {
+		if got, want := pieces[triangle], area(triangle.corners()); math.Abs(got-want) > 1e-6 {
+			t.Errorf("triangle %d: pieces cover %v, want %v", i, got, want)
+		}
+	}
+}
+
+// Trace has to find what looking at every triangle but those of entities
+// finds, and the BVH what looking at all of them finds
+func TestTrace(t *testing.T) {
+	world, _ := loadHallway(t)
+	sectors, _ := SetupWorld("data/sectors.txt")
+
+	closest := func(triangles []*Triangle, eye, dir vec3) (*Triangle, float64) {
+		var hit *Triangle
+		closest := math.Inf(1)
+		for _, triangle := range triangles {
+			if d, ok := rayTriangle(eye, dir, triangle.corners()); ok && d < closest {
+				hit, closest = triangle, d
+			}
+		}
+		return hit, closest
+	}
+
+	for _, w := range []*World{world, sectors} {
+		for _, sector := range w.sectors {
+			root, index := sector.BSP(), sector.Index()
+			static := sector.staticTriangles()
+			for _, eye := range testEyes {
+				for _, dir := range testDirections(300) {
+					want, wantD := closest(static, eye, dir)
+					hit, d := root.Trace(eye, dir, math.Inf(1))
+					if (hit == nil) != (want == nil) || hit != nil && math.Abs(d-wantD) > 1e-6 {
+						t.Fatalf("sector %s: ray from %v along %.3f hit at %v, want %v", sector.name, eye, dir, d, wantD)
+					}
+
+					want, wantD = closest(sector.triangles, eye, dir)
+					bvhHit, bvhD := index.Raycast(eye, dir, math.Inf(1))
+					if (bvhHit == nil) != (want == nil) || bvhHit != nil && math.Abs(bvhD-wantD) > 1e-6 {
+						t.Fatalf("sector %s: ray from %v along %.3f hit the BVH at %v, want %v", sector.name, eye, dir, bvhD, wantD)
+					}
+
+					// a shorter ray stops before the hit
+					if hit != nil {
+						if short, _ := root.Trace(eye, dir, d/2); short != nil {
+							t.Fatalf("sector %s: ray from %v along %.3f hit something closer than %v", sector.name, eye, dir, d)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// Along any ray from the eye, what is further away has to be visited first
+func TestBackToFront(t *testing.T) {
+	_, sector := loadHallway(t)
+	root := sector.BSP()
+
+	for _, eye := range testEyes {
+		var order []*Triangle
+		root.BackToFront(eye, func(triangle *Triangle) {
+			order = append(order, triangle)
+		})
+
+		for _, dir := range testDirections(1000) {
+			last, lastD := -1, math.Inf(1)
+			for i, piece := range order {
+				d, ok := rayTriangle(eye, dir, piece.corners())
+				if !ok {
+					continue
+				}
+				if d > lastD+1e-9 {
+					t.Fatalf("from %v along %.3f piece %d at %v is visited after piece %d at %v", eye, dir, i, d, last, lastD)
+				}
+				last, lastD = i, d
+			}
+		}
+	}
+}
+
+// A closed box facing inwards is open inside and solid outside
+func TestSolid(t *testing.T) {
+	var src strings.Builder
+	quad := func(a, b, c, d vec3) {
+		for _, v := range []vec3{a, b, c, a, c, d} {
+			fmt.Fprintf(&src, "%g %g %g 0 0\n", v[0], v[1], v[2])
+		}
+	}
+	corner := func(i int) vec3 {
+		return vec3{float64(i&1)*2 - 1, float64(i>>1&1)*2 - 1, float64(i>>2&1)*2 - 1}
+	}
+	// the faces of the box, counter-clockwise seen from inside
+	for _, face := range [][4]int{
+		{0, 1, 3, 2}, {4, 6, 7, 5}, {0, 4, 5, 1}, {2, 3, 7, 6}, {0, 2, 6, 4}, {1, 5, 7, 3},
+	} {
+		quad(corner(face[0]), corner(face[1]), corner(face[2]), corner(face[3]))
+	}
+
+	world, err := ParseWorld(strings.NewReader(src.String()), "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := world.sectors[0].BSP()
+
+	for _, p := range []vec3{{0, 0, 0}, {0.9, 0.9, 0.9}, {-0.5, 0.2, 0.99}} {
+		if root.Solid(p) {
+			t.Errorf("%v inside the box is solid", p)
+		}
+	}
+	for _, p := range []vec3{{0, 0, 2}, {1.1, 0, 0}, {0, -5, 0}, {3, 3, 3}} {
+		if !root.Solid(p) {
+			t.Errorf("%v outside the box is open", p)
+		}
+	}
+}
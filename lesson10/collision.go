@@ -0,0 +1,348 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// A Body is the shape the player occupies: a vertical capsule standing on
+// the feet, whose bottom is lifted a little so small steps and the floor
+// don't get in the way when walking.
+type Body struct {
+	radius float64 // of the capsule
+	height float64 // from the feet to the top of the head
+	step   float64 // height of ledges that can be walked over
+}
+
+// Our player, who sees the world from EYE_HEIGHT above the feet
+var player = Body{radius: 0.1, height: 0.3, step: 0.05}
+
+const (
+	// how often we push out of walls per move, corners need more than one
+	COLLISION_ITERATIONS = 4
+)
+
+// The segment in the middle of the capsule, for a body with its feet at pos
+func (b Body) segment(feet vec3) (bottom, top vec3) {
+	low := b.step + b.radius
+	high := math.Max(b.height-b.radius, low)
+	return feet.add(vec3{0, low, 0}), feet.add(vec3{0, high, 0})
+}
+
+// The sectors whose triangles we may touch while in sector s: itself and
+// those right behind its portals
+func (s *Sector) neighbourhood() []*Sector {
+	sectors := []*Sector{s}
+	for _, portal := range s.portals {
+		sectors = append(sectors, portal.target)
+	}
+	return sectors
+}
+
+// The sectors whose triangles a body in sector current may touch. Without
+// a sector to start from that may be any of them.
+func (w *World) nearSectors(current *Sector) []*Sector {
+	if current == nil {
+		return w.sectors
+	}
+	return current.neighbourhood()
+}
+
+// Move a body with its feet at from by move, while in sector current, or
+// anywhere if it is nil. When it runs into walls it slides along them
+// instead of stopping, and the place it ends up at is returned. Only the
+// horizontal position changes.
+func (w *World) Slide(current *Sector, body Body, from, move vec3) vec3 {
+	// long moves are split up into steps shorter than the radius, so we
+	// can't pass through thin walls or land right on one and be pushed out
+	// on the wrong side
+	steps := int(math.Floor(move.length()/body.radius)) + 1
+
+	pos := from
+	for i := 0; i < steps; i++ {
+		pos = w.pushOut(current, body, pos.add(move.scale(1/float64(steps))))
+	}
+
+	return pos
+}
+
+// Push a body with its feet at pos out of any triangle it overlaps. The
+// triangles are found in the BVHs: the capsule has a size, which the BSP
+// trees can't be asked about, and whether a point is solid in them depends
+// on which way triangles face, which the worlds of the tutorial mix up.
+func (w *World) pushOut(current *Sector, body Body, pos vec3) vec3 {
+	sectors := w.nearSectors(current)
+
+	for i := 0; i < COLLISION_ITERATIONS; i++ {
+		moved := false
+
+		for _, triangle := range touching(sectors, body, pos) {
+			bottom, top := body.segment(pos)
+			onBody, onTriangle := closestSegmentTriangle(bottom, top, triangle.corners())
+
+			away := onBody.sub(onTriangle)
+			if away.length() >= body.radius {
+				continue
+			}
+			away[1] = 0 // floors and ceilings are somebody else's business
+			distance := away.length()
+
+			if distance == 0 {
+				// we are right in the wall, leave the way its face points
+				away = triangle.faceNormal()
+				away[1] = 0
+				if away.length() == 0 {
+					continue
+				}
+				away = away.normalize()
+			} else {
+				away = away.scale(1 / distance)
+			}
+
+			pos = pos.add(away.scale(body.radius - distance))
+			moved = true
+		}
+
+		if !moved {
+			break
+		}
+	}
+
+	return pos
+}
+
+// The triangles of sectors a body with its feet at pos overlaps, those it
+// is deepest in first. Walls are made of several triangles, and pushing out
+// of the edge between two of them before the one we are in pushes sideways.
+func touching(sectors []*Sector, body Body, pos vec3) []*Triangle {
+	bottom, top := body.segment(pos)
+	center := bottom.add(top).scale(0.5)
+	reach := top.sub(bottom).length()/2 + body.radius
+
+	var triangles []*Triangle
+	var depths []float64
+	for _, sector := range sectors {
+		// only triangles near the capsule can touch it
+		for _, triangle := range sector.Index().Overlap(center, reach) {
+			onBody, onTriangle := closestSegmentTriangle(bottom, top, triangle.corners())
+			if distance := onBody.sub(onTriangle).length(); distance < body.radius {
+				triangles = append(triangles, triangle)
+				depths = append(depths, distance)
+			}
+		}
+	}
+
+	sort.Stable(byDepth{triangles, depths})
+	return triangles
+}
+
+// sorts triangles by how far they are from a body, nearest first
+type byDepth struct {
+	triangles []*Triangle
+	depths    []float64
+}
+
+func (s byDepth) Len() int           { return len(s.triangles) }
+func (s byDepth) Less(i, j int) bool { return s.depths[i] < s.depths[j] }
+func (s byDepth) Swap(i, j int) {
+	s.triangles[i], s.triangles[j] = s.triangles[j], s.triangles[i]
+	s.depths[i], s.depths[j] = s.depths[j], s.depths[i]
+}
+
+// The corners of a triangle as vectors
+func (t *Triangle) corners() [3]vec3 {
+	return [3]vec3{t.vertices[0].pos(), t.vertices[1].pos(), t.vertices[2].pos()}
+}
+
+// The normal of the plane a triangle lies in, facing the side its corners
+// are counter-clockwise from
+func (t *Triangle) faceNormal() vec3 {
+	c := t.corners()
+	return c[1].sub(c[0]).cross(c[2].sub(c[0])).normalize()
+}
+
+// The point of a triangle closest to p
+func closestPointTriangle(p vec3, tri [3]vec3) vec3 {
+	// from Christer Ericson's Real-Time Collision Detection
+	a, b, c := tri[0], tri[1], tri[2]
+	ab, ac, ap := b.sub(a), c.sub(a), p.sub(a)
+
+	d1, d2 := ab.dot(ap), ac.dot(ap)
+	if d1 <= 0 && d2 <= 0 {
+		return a
+	}
+
+	bp := p.sub(b)
+	d3, d4 := ab.dot(bp), ac.dot(bp)
+	if d3 >= 0 && d4 <= d3 {
+		return b
+	}
+
+	vc := d1*d4 - d3*d2
+	if vc <= 0 && d1 >= 0 && d3 <= 0 {
+		return a.add(ab.scale(d1 / (d1 - d3)))
+	}
+
+	cp := p.sub(c)
+	d5, d6 := ab.dot(cp), ac.dot(cp)
+	if d6 >= 0 && d5 <= d6 {
+		return c
+	}
+
+	vb := d5*d2 - d1*d6
+	if vb <= 0 && d2 >= 0 && d6 <= 0 {
+		return a.add(ac.scale(d2 / (d2 - d6)))
+	}
+
+	va := d3*d6 - d5*d4
+	if va <= 0 && d4-d3 >= 0 && d5-d6 >= 0 {
+		return b.add(c.sub(b).scale((d4 - d3) / ((d4 - d3) + (d5 - d6))))
+	}
+
+	denom := 1 / (va + vb + vc)
+	return a.add(ab.scale(vb * denom)).add(ac.scale(vc * denom))
+}
+
+// The closest points between the segments p1-q1 and p2-q2
+func closestSegmentSegment(p1, q1, p2, q2 vec3) (vec3, vec3) {
+	d1, d2, r := q1.sub(p1), q2.sub(p2), p1.sub(p2)
+	a, e, f := d1.dot(d1), d2.dot(d2), d2.dot(r)
+
+	clamp := func(x float64) float64 { return math.Max(0, math.Min(1, x)) }
+
+	var s, t float64
+	switch {
+	case a == 0 && e == 0:
+		return p1, p2
+	case a == 0:
+		t = clamp(f / e)
+	default:
+		c := d1.dot(r)
+		if e == 0 {
+			s = clamp(-c / a)
+		} else {
+			b := d1.dot(d2)
+			if denom := a*e - b*b; denom != 0 {
+				s = clamp((b*f - c*e) / denom)
+			}
+			t = (b*s + f) / e
+			if t < 0 {
+				t, s = 0, clamp(-c/a)
+			} else if t > 1 {
+				t, s = 1, clamp((b-c)/a)
+			}
+		}
+	}
+
+	return p1.add(d1.scale(s)), p2.add(d2.scale(t))
+}
+
+// The closest points between the segment p-q and a triangle
+func closestSegmentTriangle(p, q vec3, tri [3]vec3) (onSegment, onTriangle vec3) {
+	// where the segment passes through the triangle they touch
+	normal := tri[1].sub(tri[0]).cross(tri[2].sub(tri[0]))
+	dp, dq := p.sub(tri[0]).dot(normal), q.sub(tri[0]).dot(normal)
+	if dp != dq && (dp <= 0) != (dq <= 0) {
+		hit := p.add(q.sub(p).scale(dp / (dp - dq)))
+		if closestPointTriangle(hit, tri).sub(hit).length() < 1e-9 {
+			return hit, hit
+		}
+	}
+
+	// otherwise the closest points involve an end of the segment or an
+	// edge of the triangle
+	best := math.Inf(1)
+	try := func(s, t vec3) {
+		if d := s.sub(t).length(); d < best {
+			best, onSegment, onTriangle = d, s, t
+		}
+	}
+
+	try(p, closestPointTriangle(p, tri))
+	try(q, closestPointTriangle(q, tri))
+	for i := range tri {
+		try(closestSegmentSegment(p, q, tri[i], tri[(i+1)%3]))
+	}
+
+	return onSegment, onTriangle
+}
+
+// Triangles steeper than this, the cosine of the angle to level ground,
+// are walls; the rest are floors or ceilings
+const MIN_LEVEL_NORMAL = 0.7
+
+// The height at which the vertical line through x, z passes through a
+// triangle, if it does
+func verticalHit(tri [3]vec3, x, z float64) (float64, bool) {
+	a, b, c := tri[0], tri[1], tri[2]
+
+	// barycentric coordinates of x, z in the triangle seen from above
+	det := (b[2]-c[2])*(a[0]-c[0]) + (c[0]-b[0])*(a[2]-c[2])
+	if det == 0 {
+		return 0, false
+	}
+	l1 := ((b[2]-c[2])*(x-c[0]) + (c[0]-b[0])*(z-c[2])) / det
+	l2 := ((c[2]-a[2])*(x-c[0]) + (a[0]-c[0])*(z-c[2])) / det
+	l3 := 1 - l1 - l2
+	if l1 < 0 || l2 < 0 || l3 < 0 {
+		return 0, false
+	}
+
+	return l1*a[1] + l2*b[1] + l3*c[1], true
+}
+
+// Whether a triangle is flat enough to be a floor or ceiling
+func (t *Triangle) level() bool {
+	return math.Abs(t.faceNormal()[1]) >= MIN_LEVEL_NORMAL
+}
+
+// The height of the nearest floor or ceiling straight below p, when up is
+// -1, or above it, when up is 1. Floors at p count, ceilings don't. The BSP
+// trees of the sectors nearby are traced, going on past slopes too steep to
+// be floors; the triangles of entities move, so they aren't in the trees
+// and are tried one by one.
+func (w *World) levelFrom(current *Sector, p vec3, up float64) (float64, bool) {
+	if current == nil {
+		return math.Inf(int(up)), false
+	}
+
+	dir := vec3{0, up, 0}
+	nearest, found := math.Inf(1), false
+	try := func(triangle *Triangle, t float64) {
+		if t < nearest && (t > 0 || t == 0 && up < 0) && triangle.level() {
+			nearest, found = t, true
+		}
+	}
+
+	sectors := current.neighbourhood()
+	for _, sector := range sectors {
+		root := sector.BSP()
+		for start := 0.0; start < nearest; {
+			triangle, t := root.Trace(p.add(dir.scale(start)), dir, nearest-start)
+			if triangle == nil {
+				break
+			}
+			try(triangle, start+t)
+			start += t + BSP_EPSILON
+		}
+	}
+	for _, e := range w.EntitiesIn(sectors) {
+		for _, triangle := range e.triangles {
+			if y, ok := verticalHit(triangle.corners(), p[0], p[2]); ok {
+				try(triangle, (y-p[1])*up)
+			}
+		}
+	}
+
+	return p[1] + nearest*up, found
+}
+
+// The height of the highest floor at or below p, false if there is none
+func (w *World) FloorBelow(current *Sector, p vec3) (float64, bool) {
+	return w.levelFrom(current, p, -1)
+}
+
+// The height of the lowest ceiling above p, false if there is none
+func (w *World) CeilingAbove(current *Sector, p vec3) (float64, bool) {
+	return w.levelFrom(current, p, 1)
+}
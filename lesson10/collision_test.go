@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// The room in the middle of data/world.txt has walls at x and z of -2 and
+// 2, with doorways 1 wide in the middle of each into short hallways.
+func loadHallway(t *testing.T) (*World, *Sector) {
+	world, err := SetupWorld("data/world.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return world, world.sectors[0]
+}
+
+// How close a body with its feet at pos is to the nearest triangle
+func clearance(world *World, body Body, pos vec3) float64 {
+	bottom, top := body.segment(pos)
+	closest := math.Inf(1)
+	for _, triangle := range world.Triangles() {
+		onBody, onTriangle := closestSegmentTriangle(bottom, top, triangle.corners())
+		closest = math.Min(closest, onBody.sub(onTriangle).length())
+	}
+	return closest
+}
+
+func near(a, b vec3) bool {
+	return a.sub(b).length() < 1e-6
+}
+
+func TestSlide(t *testing.T) {
+	world, sector := loadHallway(t)
+	r := player.radius
+
+	tests := []struct {
+		name       string
+		from, move vec3
+		want       vec3
+	}{
+		{"free", vec3{0, 0, 0}, vec3{1, 0, 1}, vec3{1, 0, 1}},
+		{"into a wall", vec3{1, 0, 1}, vec3{2, 0, 0}, vec3{2 - r, 0, 1}},
+		{"along a wall", vec3{1, 0, 1}, vec3{2, 0, 0.5}, vec3{2 - r, 0, 1.5}},
+		{"into a corner", vec3{1, 0, 1}, vec3{2, 0, 2}, vec3{2 - r, 0, 2 - r}},
+		{"through a doorway", vec3{0, 0, 0}, vec3{0, 0, -2.5}, vec3{0, 0, -2.5}},
+		{"across a hallway", vec3{0, 0, -2.5}, vec3{1, 0, 0}, vec3{0.5 - r, 0, -2.5}},
+		{"along a hallway", vec3{-0.45, 0, 2.5}, vec3{-0.2, 0, 0.4}, vec3{-0.5 + r, 0, 2.9}},
+		{"out of the hallway", vec3{2.5, 0, 0}, vec3{1, 0, 0}, vec3{3.5, 0, 0}},
+	}
+
+	// not knowing the sector we are in, all walls are in the way
+	for _, current := range []*Sector{sector, nil} {
+		for _, test := range tests {
+			got := world.Slide(current, player, test.from, test.move)
+			if !near(got, test.want) {
+				t.Errorf("%s in %s: ended at %.4f, want %.4f", test.name, sectorName(current), got, test.want)
+			}
+			if c := clearance(world, player, got); c < r-1e-6 {
+				t.Errorf("%s in %s: ended %.4f from a wall, closer than the radius %v", test.name, sectorName(current), c, r)
+			}
+		}
+	}
+
+	// nor in a world of several sectors
+	sectors := loadSectors(t)
+	if got, want := sectors.Slide(nil, player, vec3{2, 0, -1}, vec3{2, 0, 0}), (vec3{3 - r, 0, -1}); !near(got, want) {
+		t.Errorf("into a wall of the hall: ended at %.4f, want %.4f", got, want)
+	}
+	if got, want := sectors.Slide(nil, player, vec3{0, 0, -6}, vec3{1, 0, 0}), (vec3{0.5 - r, 0, -6}); !near(got, want) {
+		t.Errorf("into a wall of the corridor: ended at %.4f, want %.4f", got, want)
+	}
+
+	// glancing off the edge where a room wall meets a hallway wall costs a
+	// little of the way
+	got := world.Slide(sector, player, vec3{0.45, 0, 1}, vec3{0, 0, 2})
+	if math.Abs(got[0]-(0.5-r)) > 1e-6 || got[2] < 2.9 || got[2] > 3 {
+		t.Errorf("past the edge of a doorway: ended at %.4f, want x %v and z almost 3", got, 0.5-r)
+	}
+}
+
+// However we walk around the room, we never leave it or end up in a wall
+func TestSlideStaysInside(t *testing.T) {
+	world, sector := loadHallway(t)
+
+	for yaw := 0.0; yaw < 360; yaw += 15 {
+		// through the doorways is the only way out
+		if int(yaw)%90 == 0 {
+			continue
+		}
+		pos := vec3{}
+		step := vec3{math.Sin(yaw*math.Pi/180) * 0.05, 0, -math.Cos(yaw*math.Pi/180) * 0.05}
+		for i := 0; i < 200; i++ {
+			pos = world.Slide(sector, player, pos, step)
+		}
+
+		if math.Abs(pos[0]) > 2 || math.Abs(pos[2]) > 2 {
+			t.Errorf("walking at %v degrees ended outside the room at %.3f", yaw, pos)
+		}
+		if c := clearance(world, player, pos); c < player.radius-1e-6 {
+			t.Errorf("walking at %v degrees ended %.4f from a wall", yaw, c)
+		}
+	}
+}
+
+func TestPushOut(t *testing.T) {
+	world, sector := loadHallway(t)
+	r := player.radius
+
+	tests := []struct {
+		name string
+		pos  vec3
+		want vec3
+	}{
+		{"clear", vec3{1, 0, 1}, vec3{1, 0, 1}},
+		{"overlapping a wall", vec3{1.95, 0, 1}, vec3{2 - r, 0, 1}},
+		{"overlapping from outside", vec3{2.05, 0, 1}, vec3{2 + r, 0, 1}},
+		{"overlapping two walls", vec3{1.95, 0, 1.95}, vec3{2 - r, 0, 2 - r}},
+		{"in a hallway wall", vec3{0.45, 0, -2.5}, vec3{0.5 - r, 0, -2.5}},
+	}
+
+	for _, test := range tests {
+		if got := world.pushOut(sector, player, test.pos); !near(got, test.want) {
+			t.Errorf("%s: pushed to %.4f, want %.4f", test.name, got, test.want)
+		}
+	}
+
+	// right inside a wall either side will do, as long as we are out
+	got := world.pushOut(sector, player, vec3{2, 0, 1})
+	if math.Abs(math.Abs(got[0]-2)-r) > 1e-6 || got[2] != 1 {
+		t.Errorf("in the wall: pushed to %.4f, want %v from it", got, r)
+	}
+}
+
+// A hallway along z with a floor at height 0 up to z 0, and one at height
+// step after it, joined by a riser
+func stepWorld(t *testing.T, step float64) (*World, *Sector) {
+	var src strings.Builder
+	quad := func(a, b, c, d vec3) {
+		for _, v := range []vec3{a, b, c, a, c, d} {
+			fmt.Fprintf(&src, "%g %g %g 0 0\n", v[0], v[1], v[2])
+		}
+	}
+	quad(vec3{-1, 0, -2}, vec3{1, 0, -2}, vec3{1, 0, 0}, vec3{-1, 0, 0})
+	quad(vec3{-1, 0, 0}, vec3{1, 0, 0}, vec3{1, step, 0}, vec3{-1, step, 0})
+	quad(vec3{-1, step, 0}, vec3{1, step, 0}, vec3{1, step, 2}, vec3{-1, step, 2})
+	quad(vec3{-1, 1, -2}, vec3{1, 1, -2}, vec3{1, 1, 2}, vec3{-1, 1, 2})
+
+	world, err := ParseWorld(strings.NewReader(src.String()), "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return world, world.sectors[0]
+}
+
+func TestSteps(t *testing.T) {
+	tests := []struct {
+		step    float64
+		climbed bool
+	}{
+		{0.02, true},
+		{player.step * 0.9, true},
+		{0.2, false},
+		{0.3, false},
+	}
+
+	for _, test := range tests {
+		world, sector := stepWorld(t, test.step)
+		got := world.Slide(sector, player, vec3{0, 0, -1}, vec3{0, 0, 1.5})
+
+		if test.climbed {
+			if !near(got, vec3{0, 0, 0.5}) {
+				t.Errorf("step %v: ended at %.4f, want to walk over it to z 0.5", test.step, got)
+			}
+			if floor, ok := world.FloorBelow(sector, got.add(vec3{0, player.step, 0})); !ok || math.Abs(floor-test.step) > 1e-6 {
+				t.Errorf("step %v: floor below is %v %v, want the top of the step", test.step, floor, ok)
+			}
+		} else if !near(got, vec3{0, 0, -player.radius}) {
+			t.Errorf("step %v: ended at %.4f, want to stop in front of it", test.step, got)
+		}
+	}
+}
+
+func TestFloorBelow(t *testing.T) {
+	world, sector := loadHallway(t)
+	steps, stepSector := stepWorld(t, 0.3)
+
+	tests := []struct {
+		name   string
+		world  *World
+		sector *Sector
+		p      vec3
+		floor  float64
+		found  bool
+	}{
+		{"room", world, sector, vec3{0, 0.5, 0}, 0, true},
+		{"on the floor", world, sector, vec3{1, 0, 1}, 0, true},
+		{"hallway", world, sector, vec3{0, 0.5, 2.5}, 0, true},
+		{"under the floor", world, sector, vec3{0, -0.1, 0}, 0, false},
+		{"outside", world, sector, vec3{10, 0.5, 10}, 0, false},
+		{"no sector", world, nil, vec3{0, 0.5, 0}, 0, false},
+		{"above the ceiling", world, sector, vec3{0, 2, 0}, 1, true},
+		{"before the step", steps, stepSector, vec3{0, 0.5, -1}, 0, true},
+		{"on the step", steps, stepSector, vec3{0, 0.5, 1}, 0.3, true},
+		{"below the step", steps, stepSector, vec3{0, 0.1, 1}, 0, false},
+	}
+
+	for _, test := range tests {
+		floor, found := test.world.FloorBelow(test.sector, test.p)
+		if found != test.found || found && math.Abs(floor-test.floor) > 1e-6 {
+			t.Errorf("%s: got floor %v %v, want %v %v", test.name, floor, found, test.floor, test.found)
+		}
+	}
+
+	if ceiling, ok := world.CeilingAbove(sector, vec3{0, 0.5, 0}); !ok || ceiling != 1 {
+		t.Errorf("got ceiling %v %v, want 1", ceiling, ok)
+	}
+}
+
+// Tracing the BSP trees finds the floors and ceilings looking at every
+// triangle nearby finds, also where they were cut into pieces
+func TestLevelsTraced(t *testing.T) {
+	for _, name := range []string{"world.txt", "sectors.txt", "levels.txt"} {
+		world, err := SetupWorld("data/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, sector := range world.sectors {
+			var triangles []*Triangle
+			for _, near := range sector.neighbourhood() {
+				triangles = append(triangles, near.triangles...)
+			}
+			want := func(p vec3, up float64) (float64, bool) {
+				nearest := math.Inf(1)
+				for _, triangle := range triangles {
+					y, ok := verticalHit(triangle.corners(), p[0], p[2])
+					// floors at p count, ceilings don't
+					if d := (y - p[1]) * up; ok && triangle.level() && d < nearest && (d > 0 || d == 0 && up < 0) {
+						nearest = d
+					}
+				}
+				return p[1] + nearest*up, !math.IsInf(nearest, 1)
+			}
+
+			// right on an edge or a floor either answer is fine, so the
+			// points are a little off the round numbers the worlds use
+			b := sector.bounds()
+			for x := b.min[0] - 0.24; x <= b.max[0]+0.25; x += 0.25 {
+				for z := b.min[2] - 0.26; z <= b.max[2]+0.25; z += 0.25 {
+					for y := b.min[1] + 0.01; y <= b.max[1]+0.5; y += 0.25 {
+						p := vec3{x, y, z}
+						for _, up := range []float64{-1, 1} {
+							got, ok := world.levelFrom(sector, p, up)
+							level, found := want(p, up)
+							if ok != found || found && math.Abs(got-level) > 1e-6 {
+								t.Fatalf("%s: sector %s: level %v from %v is %v %v, want %v %v", name, sector.name, up, p, got, ok, level, found)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
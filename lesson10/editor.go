@@ -0,0 +1,399 @@
+package main
+
+import (
+	"fmt"
+	"github.com/banthar/Go-SDL/sdl"
+	"github.com/banthar/gl"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// how far the editor moves things and shifts texture coordinates
+	EDIT_STEP = 0.1
+
+	// clicking closer than this to a corner selects the corner
+	EDIT_CORNER_DISTANCE = 0.1
+
+	// added to the name of the world file the editor saves to
+	EDIT_SUFFIX = "-edited"
+)
+
+// The Editor changes the world from inside lesson10. Press e to start
+// editing, which frees the mouse; clicking a triangle selects it, and
+// clicking close to one of its corners selects just that corner.
+//
+//	shift + arrows         move the selection across the floor
+//	shift + page up/down   move the selection up and down
+//	ctrl + arrows          shift the texture of the selection
+//	ctrl + page up/down    scale the texture of the selection
+//	n                      add a triangle in front of us
+//	delete                 delete the selected triangle
+//	F2                     save the world in world.txt format, next to
+//	                       the file it was loaded from
+//
+// Moving a corner moves the corners of all triangles at the same place
+// too, so the world stays in one piece. A triangle moves on its own.
+type Editor struct {
+	active bool
+	path   string // where the world is saved
+
+	sector   *Sector // of the selected triangle
+	triangle *Triangle
+	vertex   *Vertex // selected corner of the triangle, if any
+}
+
+var editor Editor
+
+// Where a world loaded from path is saved: next to it with -edited added
+// to its name, so the world we started from is kept. Saved worlds are
+// saved over when loaded again.
+func editorPath(path string) string {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	return strings.TrimSuffix(base, EDIT_SUFFIX) + EDIT_SUFFIX + ".txt"
+}
+
+// start or stop editing
+func (e *Editor) toggle() {
+	e.active = !e.active
+	if e.active {
+		p("edit mode on")
+	} else {
+		p("edit mode off")
+		e.triangle, e.vertex = nil, nil
+	}
+	grabMouse(!e.active)
+}
+
+// Handle a key while editing, returning false for keys that aren't ours
+func (e *Editor) handleKey(keysym sdl.Keysym) bool {
+	shift := keysym.Mod&sdl.KMOD_SHIFT != 0
+	ctrl := keysym.Mod&sdl.KMOD_CTRL != 0
+
+	// the floor directions closest to where we look
+	forward, right := viewAxes()
+
+	switch {
+	case keysym.Sym == sdl.K_e:
+		e.toggle()
+	case keysym.Sym == sdl.K_F2:
+		if err := ExportWorld(e.path, world); err != nil {
+			fmt.Println("Could not save the world:", err)
+		} else {
+			fmt.Println("World saved to", e.path)
+		}
+	case keysym.Sym == sdl.K_n:
+		e.add()
+	case keysym.Sym == sdl.K_DELETE:
+		e.delete()
+
+	case shift && keysym.Sym == sdl.K_UP:
+		e.move(forward.scale(EDIT_STEP))
+	case shift && keysym.Sym == sdl.K_DOWN:
+		e.move(forward.scale(-EDIT_STEP))
+	case shift && keysym.Sym == sdl.K_RIGHT:
+		e.move(right.scale(EDIT_STEP))
+	case shift && keysym.Sym == sdl.K_LEFT:
+		e.move(right.scale(-EDIT_STEP))
+	case shift && keysym.Sym == sdl.K_PAGEUP:
+		e.move(vec3{0, EDIT_STEP, 0})
+	case shift && keysym.Sym == sdl.K_PAGEDOWN:
+		e.move(vec3{0, -EDIT_STEP, 0})
+
+	case ctrl && keysym.Sym == sdl.K_UP:
+		e.shiftTexture(0, EDIT_STEP)
+	case ctrl && keysym.Sym == sdl.K_DOWN:
+		e.shiftTexture(0, -EDIT_STEP)
+	case ctrl && keysym.Sym == sdl.K_RIGHT:
+		e.shiftTexture(EDIT_STEP, 0)
+	case ctrl && keysym.Sym == sdl.K_LEFT:
+		e.shiftTexture(-EDIT_STEP, 0)
+	case ctrl && keysym.Sym == sdl.K_PAGEUP:
+		e.scaleTexture(1.25)
+	case ctrl && keysym.Sym == sdl.K_PAGEDOWN:
+		e.scaleTexture(0.8)
+
+	default:
+		return false
+	}
+
+	return true
+}
+
+// The directions along the floor, snapped to the X or Z axis, that are
+// closest to ahead of us and to our right
+func viewAxes() (forward, right vec3) {
+	x := -math.Sin(yrot * PiOver100)
+	z := -math.Cos(yrot * PiOver100)
+	if math.Abs(x) > math.Abs(z) {
+		forward = vec3{math.Copysign(1, x), 0, 0}
+	} else {
+		forward = vec3{0, 0, math.Copysign(1, z)}
+	}
+	return forward, forward.cross(vec3{0, 1, 0})
+}
+
+// select what is under the mouse at x, y in the window
+func (e *Editor) click(x, y int) {
+	camera := playerCamera()
+	ndcX := 2*float64(x)/float64(windowWidth) - 1
+	ndcY := 1 - 2*float64(y)/float64(windowHeight)
+	dir := camera.ray(ndcX, ndcY)
+
+	sector, triangle, distance := world.Pick(camera.pos, dir)
+	e.sector, e.triangle, e.vertex = sector, triangle, nil
+	if triangle == nil {
+		return
+	}
+
+	hit := camera.pos.add(dir.scale(distance))
+	for _, vertex := range triangle.vertices {
+		if vertex.pos().sub(hit).length() < EDIT_CORNER_DISTANCE {
+			e.vertex = vertex
+		}
+	}
+}
+
+// Find the closest triangle hit by a ray, and how far along the ray it is.
+// The BSP trees are traced for what doesn't move, the triangles of entities
+// aren't in them and are tried one by one.
+func (w *World) Pick(origin, dir vec3) (*Sector, *Triangle, float64) {
+	var hitSector *Sector
+	var hitTriangle *Triangle
+	closest := math.Inf(1)
+
+	for _, sector := range w.sectors {
+		if triangle, t := sector.BSP().Trace(origin, dir, closest); triangle != nil {
+			hitSector, hitTriangle, closest = sector, triangle, t
+		}
+	}
+	for _, e := range w.EntitiesIn(w.sectors) {
+		for _, triangle := range e.triangles {
+			if t, ok := rayTriangle(origin, dir, triangle.corners()); ok && t < closest {
+				hitSector, hitTriangle, closest = e.sector, triangle, t
+			}
+		}
+	}
+
+	return hitSector, hitTriangle, closest
+}
+
+// Where a ray hits a triangle from either side, as distance along dir
+func rayTriangle(origin, dir vec3, tri [3]vec3) (float64, bool) {
+	// Möller and Trumbore's algorithm
+	e1, e2 := tri[1].sub(tri[0]), tri[2].sub(tri[0])
+	p := dir.cross(e2)
+	det := e1.dot(p)
+	if math.Abs(det) < 1e-12 {
+		return 0, false
+	}
+
+	s := origin.sub(tri[0])
+	u := s.dot(p) / det
+	if u < 0 || u > 1 {
+		return 0, false
+	}
+
+	q := s.cross(e1)
+	v := dir.dot(q) / det
+	if v < 0 || u+v > 1 {
+		return 0, false
+	}
+
+	t := e2.dot(q) / det
+	return t, t >= 0
+}
+
+// The vertices the selection is made of: the selected corner and those at
+// the same place, or the corners of the selected triangle
+func (e *Editor) selectedVertices() map[*Vertex]*Triangle {
+	selected := map[*Vertex]*Triangle{}
+	if e.triangle == nil {
+		return selected
+	}
+
+	if e.vertex == nil {
+		for _, vertex := range e.triangle.vertices {
+			selected[vertex] = e.triangle
+		}
+		return selected
+	}
+
+	at := e.vertex.pos()
+	for _, sector := range world.sectors {
+		for _, triangle := range sector.triangles {
+			for _, vertex := range triangle.vertices {
+				if vertex.pos() == at {
+					selected[vertex] = triangle
+				}
+			}
+		}
+	}
+	return selected
+}
+
+// Move the selection
+func (e *Editor) move(d vec3) {
+	if e.triangle == nil {
+		return
+	}
+
+	// corners shared with other triangles stay with them
+	if e.vertex == nil {
+		e.detach()
+	}
+
+	for vertex, triangle := range e.selectedVertices() {
+		vertex.x += gl.GLfloat(d[0])
+		vertex.y += gl.GLfloat(d[1])
+		vertex.z += gl.GLfloat(d[2])
+		clearNormals(triangle)
+	}
+
+	world.ComputeNormals(creaseAngle)
+	world.Invalidate()
+}
+
+// Give the selected triangle vertices of its own
+func (e *Editor) detach() {
+	for i, vertex := range e.triangle.vertices {
+		own := *vertex
+		e.triangle.vertices[i] = &own
+	}
+}
+
+// forget the normals of a triangle, to have them computed again
+func clearNormals(triangle *Triangle) {
+	for _, vertex := range triangle.vertices {
+		vertex.nx, vertex.ny, vertex.nz = 0, 0, 0
+	}
+}
+
+// Shift the texture coordinates of the selected triangle or corner
+func (e *Editor) shiftTexture(du, dv float64) {
+	for _, vertex := range e.textureVertices() {
+		vertex.u += gl.GLfloat(du)
+		vertex.v += gl.GLfloat(dv)
+	}
+	world.Invalidate()
+}
+
+// Scale the texture coordinates of the selected triangle or corner
+func (e *Editor) scaleTexture(s float64) {
+	for _, vertex := range e.textureVertices() {
+		vertex.u *= gl.GLfloat(s)
+		vertex.v *= gl.GLfloat(s)
+	}
+	world.Invalidate()
+}
+
+// The vertices whose texture coordinates change: only those of the
+// selected triangle, as the texture doesn't have to match its neighbours
+func (e *Editor) textureVertices() []*Vertex {
+	if e.triangle == nil {
+		return nil
+	}
+	e.detach()
+	if e.vertex != nil {
+		// detaching replaced the selected corner
+		for _, vertex := range e.triangle.vertices {
+			if vertex.pos() == e.vertex.pos() {
+				e.vertex = vertex
+				return []*Vertex{vertex}
+			}
+		}
+	}
+	return e.triangle.vertices[:]
+}
+
+// Add an upright triangle a step ahead of us, facing us, and select it
+func (e *Editor) add() {
+	if currentSector == nil {
+		return
+	}
+
+	forward := vec3{-math.Sin(yrot * PiOver100), 0, -math.Cos(yrot * PiOver100)}
+	right := forward.cross(vec3{0, 1, 0})
+	base := vec3{xpos, ypos, zpos}.add(forward).sub(right.scale(0.5))
+
+	corners := [3]vec3{base, base.add(right), base.add(vec3{0, 1, 0})}
+	uvs := [3][2]gl.GLfloat{{0, 0}, {1, 0}, {0, 1}}
+
+	triangle := &Triangle{}
+	if e.triangle != nil {
+		triangle.material = e.triangle.material
+	}
+	for i, c := range corners {
+		triangle.vertices[i] = &Vertex{
+			x: gl.GLfloat(c[0]), y: gl.GLfloat(c[1]), z: gl.GLfloat(c[2]),
+			u: uvs[i][0], v: uvs[i][1],
+		}
+	}
+
+	currentSector.triangles = append(currentSector.triangles, triangle)
+	world.ComputeNormals(creaseAngle)
+	world.Invalidate()
+	e.sector, e.triangle, e.vertex = currentSector, triangle, nil
+}
+
+// Delete the selected triangle. An entity losing its last triangle goes
+// with it, a world file can't declare one without any.
+func (e *Editor) delete() {
+	if e.triangle == nil {
+		return
+	}
+
+	e.sector.triangles = without(e.sector.triangles, e.triangle)
+	if entity := e.triangle.entity; entity != nil {
+		entity.triangles = without(entity.triangles, e.triangle)
+		if len(entity.triangles) == 0 {
+			world.removeEntity(entity)
+		}
+	}
+	world.Invalidate()
+	e.sector, e.triangle, e.vertex = nil, nil, nil
+}
+
+// The triangles but one, in a new slice
+func without(triangles []*Triangle, triangle *Triangle) []*Triangle {
+	for i, t := range triangles {
+		if t == triangle {
+			return append(triangles[:i:i], triangles[i+1:]...)
+		}
+	}
+	return triangles
+}
+
+// Outline the selection on top of everything else
+func (e *Editor) draw() {
+	if !e.active || e.triangle == nil {
+		return
+	}
+
+	gl.Disable(gl.TEXTURE_2D)
+	gl.Disable(gl.DEPTH_TEST)
+	gl.Disable(gl.LIGHTING)
+	gl.Color3f(1.0, 1.0, 0.0)
+
+	gl.LineWidth(2.0)
+	gl.Begin(gl.LINE_LOOP)
+	for _, vertex := range e.triangle.vertices {
+		gl.Vertex3f(float32(vertex.x), float32(vertex.y), float32(vertex.z))
+	}
+	gl.End()
+
+	if e.vertex != nil {
+		gl.PointSize(8.0)
+		gl.Begin(gl.POINTS)
+		gl.Vertex3f(float32(e.vertex.x), float32(e.vertex.y), float32(e.vertex.z))
+		gl.End()
+	}
+
+	gl.Color3f(1.0, 1.0, 1.0)
+	gl.Enable(gl.DEPTH_TEST)
+	gl.Enable(gl.TEXTURE_2D)
+	if light {
+		gl.Enable(gl.LIGHTING)
+	}
+}
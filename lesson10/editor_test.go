@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+)
+
+// A floor of two triangles as WriteWorld writes it, every line different.
+// The triangles share the corners at -1 0 -1 and 1 0 1.
+const editorWorld = `// the world
+
+NUMPOLLIES 2
+
+// a triangle
+ -1.0   0.0  -1.0   0.0   1.0
+ -1.0   0.0   1.0   0.0   0.0
+  1.0   0.0   1.0   1.0   0.0
+
+// another
+ -1.0   0.0  -1.0   2.0   1.0
+  1.0   0.0  -1.0   3.0   1.0
+  1.0   0.0   1.0   3.0   0.0
+
+`
+
+// Editing the world changes only the lines of what was edited, comments
+// included, when it is saved again
+func TestEditor(t *testing.T) {
+	tests := []struct {
+		name    string
+		edit    func(e *Editor, w *World)
+		changes []string // pairs of lines before and after the edit
+	}{
+		{"nothing", func(e *Editor, w *World) {}, nil},
+		{"move a shared corner", func(e *Editor, w *World) {
+			e.triangle = w.sectors[0].triangles[0]
+			e.vertex = e.triangle.vertices[2]
+			e.move(vec3{0, EDIT_STEP, 0})
+		}, []string{
+			"  1.0   0.0   1.0   1.0   0.0", "  1.0   0.1   1.0   1.0   0.0",
+			"  1.0   0.0   1.0   3.0   0.0", "  1.0   0.1   1.0   3.0   0.0",
+		}},
+		{"move a corner", func(e *Editor, w *World) {
+			e.triangle = w.sectors[0].triangles[1]
+			e.vertex = e.triangle.vertices[1]
+			e.move(vec3{0.5, 0, 0})
+		}, []string{
+			"  1.0   0.0  -1.0   3.0   1.0", "  1.5   0.0  -1.0   3.0   1.0",
+		}},
+		{"move a triangle", func(e *Editor, w *World) {
+			e.triangle = w.sectors[0].triangles[1]
+			e.move(vec3{0, 0, -0.5})
+		}, []string{
+			" -1.0   0.0  -1.0   2.0   1.0", " -1.0   0.0  -1.5   2.0   1.0",
+			"  1.0   0.0  -1.0   3.0   1.0", "  1.0   0.0  -1.5   3.0   1.0",
+			"  1.0   0.0   1.0   3.0   0.0", "  1.0   0.0   0.5   3.0   0.0",
+		}},
+		{"shift a texture", func(e *Editor, w *World) {
+			e.triangle = w.sectors[0].triangles[0]
+			e.shiftTexture(0.5, -0.5)
+		}, []string{
+			" -1.0   0.0  -1.0   0.0   1.0", " -1.0   0.0  -1.0   0.5   0.5",
+			" -1.0   0.0   1.0   0.0   0.0", " -1.0   0.0   1.0   0.5  -0.5",
+			"  1.0   0.0   1.0   1.0   0.0", "  1.0   0.0   1.0   1.5  -0.5",
+		}},
+		{"shift a corner of a texture", func(e *Editor, w *World) {
+			e.triangle = w.sectors[0].triangles[1]
+			e.vertex = e.triangle.vertices[0]
+			e.shiftTexture(EDIT_STEP, 0)
+		}, []string{
+			" -1.0   0.0  -1.0   2.0   1.0", " -1.0   0.0  -1.0   2.1   1.0",
+		}},
+		{"scale a texture", func(e *Editor, w *World) {
+			e.triangle = w.sectors[0].triangles[1]
+			e.scaleTexture(1.25)
+		}, []string{
+			" -1.0   0.0  -1.0   2.0   1.0", " -1.0   0.0  -1.0   2.5  1.25",
+			"  1.0   0.0  -1.0   3.0   1.0", "  1.0   0.0  -1.0  3.75  1.25",
+			"  1.0   0.0   1.0   3.0   0.0", "  1.0   0.0   1.0  3.75   0.0",
+		}},
+		// a step ahead of us, facing us, half of it to either side
+		{"add a triangle", func(e *Editor, w *World) {
+			currentSector = w.sectors[0]
+			xpos, ypos, zpos, yrot = 0, 0, 0.5, 0
+			e.add()
+		}, []string{
+			"NUMPOLLIES 2", "NUMPOLLIES 3",
+			"  1.0   0.0   1.0   3.0   0.0\n", "  1.0   0.0   1.0   3.0   0.0\n\n" +
+				" -0.5   0.0  -0.5   0.0   0.0\n" +
+				"  0.5   0.0  -0.5   1.0   0.0\n" +
+				" -0.5   1.0  -0.5   0.0   1.0\n",
+		}},
+	}
+
+	defer func() {
+		world, currentSector, editor = nil, nil, Editor{}
+		xpos, ypos, zpos, yrot = 0, 0, 0, 0
+	}()
+
+	for _, test := range tests {
+		w, err := ParseWorld(strings.NewReader(editorWorld), "data")
+		if err != nil {
+			t.Fatal(err)
+		}
+		world = w
+		e := &Editor{}
+		test.edit(e, w)
+
+		want := editorWorld
+		for i := 0; i < len(test.changes); i += 2 {
+			if !strings.Contains(want, test.changes[i]) {
+				t.Fatalf("%s: no line %q to change", test.name, test.changes[i])
+			}
+			want = strings.Replace(want, test.changes[i], test.changes[i+1], 1)
+		}
+
+		var buf bytes.Buffer
+		if err := WriteWorld(&buf, w, "data"); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != want {
+			t.Errorf("%s: saved\n%s\nwant\n%s", test.name, got, want)
+		}
+	}
+}
+
+// Picking finds what looking at every triangle finds, those of doors and
+// lifts too
+func TestPick(t *testing.T) {
+	for _, name := range []string{"world.txt", "sectors.txt", "levels.txt"} {
+		world, err := SetupWorld("data/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		triangles := world.Triangles()
+
+		for _, eye := range append(testEyes, vec3{0, 0.5, -6}, vec3{0, 0.5, -11}) {
+			for _, dir := range testDirections(200) {
+				var want *Triangle
+				wantD := math.Inf(1)
+				for _, triangle := range triangles {
+					if d, ok := rayTriangle(eye, dir, triangle.corners()); ok && d < wantD {
+						want, wantD = triangle, d
+					}
+				}
+
+				_, got, d := world.Pick(eye, dir)
+				if (got == nil) != (want == nil) || got != nil && math.Abs(d-wantD) > 1e-6 {
+					t.Fatalf("%s: ray from %v along %.3f hit at %v, want %v", name, eye, dir, d, wantD)
+				}
+			}
+		}
+	}
+}
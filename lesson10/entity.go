@@ -0,0 +1,321 @@
+package main
+
+import (
+	"fmt"
+	"github.com/banthar/gl"
+)
+
+// The kinds of entities, named as the directives declaring them
+const (
+	ENTITY_DOOR    = iota // slides open when used or triggered
+	ENTITY_LIFT           // rises when stood on
+	ENTITY_TRIGGER        // a box that opens a door or lift when entered
+	ENTITY_PICKUP         // taken when touched
+)
+
+var entityNames = []string{
+	ENTITY_DOOR:    "DOOR",
+	ENTITY_LIFT:    "LIFT",
+	ENTITY_TRIGGER: "TRIGGER",
+	ENTITY_PICKUP:  "PICKUP",
+}
+
+// The states doors and lifts go through
+const (
+	MOVER_CLOSED = iota
+	MOVER_OPENING
+	MOVER_OPEN
+	MOVER_CLOSING
+)
+
+const (
+	// how fast doors and lifts move when the world file doesn't say
+	DEFAULT_MOVER_SPEED = 1.0
+
+	// how long doors and lifts stay open, in seconds
+	MOVER_WAIT = 3.0
+
+	// how far away a door can be used from
+	USE_DISTANCE = 0.6
+
+	// how close the feet have to be to the top of a lift to ride it
+	LIFT_TOLERANCE = 0.02
+)
+
+// An Entity is a part of a sector that does something. Doors, lifts and
+// pickups are made of triangles, which are among the triangles of their
+// sector, so they are collided with like the rest of it. They are left out
+// of the BSP tree and meshes of the sector though, and drawn from meshes
+// of their own moved into place, so moving them only refits the BVH.
+type Entity struct {
+	kind      int
+	name      string
+	sector    *Sector
+	triangles []*Triangle
+	comments  []string
+
+	// doors and lifts
+	move     vec3    // from closed to open
+	speed    float64 // in units per second
+	state    int
+	position float64 // how far open, from 0 to 1
+	wait     float64 // seconds left before closing
+
+	// triggers
+	box    bounds
+	target *Entity
+
+	// pickups
+	taken bool
+
+	meshes       []*Mesh // made when first needed
+	meshPosition float64 // how far open the meshes were made at
+}
+
+// Move on the entities of a world by some seconds, for a body with its
+// feet at feet. Returns where the feet are afterwards, as a lift carries
+// whoever stands on it.
+func (w *World) UpdateEntities(seconds float64, body Body, feet vec3, onGround bool) vec3 {
+	for _, e := range w.entities {
+		switch e.kind {
+		case ENTITY_TRIGGER:
+			bottom, top := body.segment(feet)
+			if e.box.contains(bottom) || e.box.contains(top) {
+				e.target.open()
+			}
+		case ENTITY_LIFT:
+			if e.state == MOVER_CLOSED && onGround && e.carries(feet) {
+				e.open()
+			}
+		case ENTITY_PICKUP:
+			if !e.taken && e.touches(body, feet) {
+				e.take()
+			}
+		}
+
+		if e.kind == ENTITY_DOOR || e.kind == ENTITY_LIFT {
+			feet = e.update(seconds, body, feet)
+		}
+	}
+	return feet
+}
+
+// Use what we look at, if it is close enough and can be used
+func (w *World) Use(eye, dir vec3) {
+	_, triangle, distance := w.Pick(eye, dir)
+	if triangle == nil || triangle.entity == nil || distance > USE_DISTANCE {
+		return
+	}
+	if triangle.entity.kind == ENTITY_DOOR {
+		triangle.entity.open()
+	}
+}
+
+// Start opening a door or lift, or keep it open a while longer
+func (e *Entity) open() {
+	switch e.state {
+	case MOVER_CLOSED, MOVER_CLOSING:
+		e.state = MOVER_OPENING
+	case MOVER_OPEN:
+		e.wait = MOVER_WAIT
+	}
+}
+
+// Move a door or lift along. Doors closing on the body open again, lifts
+// move it along as far as they moved, however far that is in one go.
+// Returns where the feet of the body are afterwards.
+func (e *Entity) update(seconds float64, body Body, feet vec3) vec3 {
+	step := e.speed * seconds / e.move.length()
+	carried, from := e.kind == ENTITY_LIFT && e.carries(feet), e.position
+
+	switch e.state {
+	case MOVER_OPENING:
+		if e.setPosition(e.position + step) {
+			e.state, e.wait = MOVER_OPEN, MOVER_WAIT
+		}
+	case MOVER_OPEN:
+		if e.wait -= seconds; e.wait <= 0 {
+			e.state = MOVER_CLOSING
+		}
+	case MOVER_CLOSING:
+		if e.kind == ENTITY_DOOR && e.blocked(body, feet) {
+			e.state = MOVER_OPENING
+			break
+		}
+		if e.setPosition(e.position - step) {
+			e.state = MOVER_CLOSED
+		}
+	}
+
+	if carried {
+		feet = feet.add(e.move.scale(e.position - from))
+	}
+	return feet
+}
+
+// Move the triangles to how far open position says, returning whether it
+// is all the way open or closed
+func (e *Entity) setPosition(position float64) bool {
+	done := false
+	if position >= 1 {
+		position, done = 1, true
+	} else if position <= 0 {
+		position, done = 0, true
+	}
+
+	d := e.move.scale(position - e.position)
+	for vertex := range e.vertices() {
+		vertex.x += gl.GLfloat(d[0])
+		vertex.y += gl.GLfloat(d[1])
+		vertex.z += gl.GLfloat(d[2])
+	}
+	e.position = position
+	if e.sector.index != nil {
+		e.sector.index.Refit()
+	}
+
+	return done
+}
+
+// The distinct vertices of the triangles of an entity
+func (e *Entity) vertices() map[*Vertex]bool {
+	vertices := map[*Vertex]bool{}
+	for _, triangle := range e.triangles {
+		for _, vertex := range triangle.vertices {
+			vertices[vertex] = true
+		}
+	}
+	return vertices
+}
+
+// The box around the triangles of an entity where they are now
+func (e *Entity) bounds() bounds {
+	b := emptyBounds()
+	for vertex := range e.vertices() {
+		b.extend(vertex.pos())
+	}
+	return b
+}
+
+// Whether a body with its feet at feet stands on top of a lift
+func (e *Entity) carries(feet vec3) bool {
+	b := e.bounds()
+	return feet[0] >= b.min[0] && feet[0] <= b.max[0] &&
+		feet[2] >= b.min[2] && feet[2] <= b.max[2] &&
+		feet[1] >= b.max[1]-LIFT_TOLERANCE && feet[1] <= b.max[1]+LIFT_TOLERANCE
+}
+
+// Whether a body is in the way of the triangles of an entity
+func (e *Entity) blocked(body Body, feet vec3) bool {
+	bottom, top := body.segment(feet)
+	for _, triangle := range e.triangles {
+		onBody, onTriangle := closestSegmentTriangle(bottom, top, triangle.corners())
+		if onBody.sub(onTriangle).length() < body.radius {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether a body touches a pickup. Walls keep the body a radius away from
+// them, so a little more than that counts.
+func (e *Entity) touches(body Body, feet vec3) bool {
+	bottom, top := body.segment(feet)
+	for _, triangle := range e.triangles {
+		onBody, onTriangle := closestSegmentTriangle(bottom, top, triangle.corners())
+		if onBody.sub(onTriangle).length() < body.radius*1.5 {
+			return true
+		}
+	}
+	return false
+}
+
+// Take a pickup out of its sector
+func (e *Entity) take() {
+	e.taken = true
+
+	taken := map[*Triangle]bool{}
+	for _, triangle := range e.triangles {
+		taken[triangle] = true
+	}
+	var left []*Triangle
+	for _, triangle := range e.sector.triangles {
+		if !taken[triangle] {
+			left = append(left, triangle)
+		}
+	}
+	e.sector.triangles = left
+	e.sector.index = nil
+	e.dropMeshes()
+
+	fmt.Println("Picked up", e.name)
+}
+
+// Take an entity out of a world, with the triggers that open it
+func (w *World) removeEntity(e *Entity) {
+	var left []*Entity
+	for _, other := range w.entities {
+		if other == e || other.target == e {
+			fmt.Println("Removed", entityNames[other.kind], other.name)
+			continue
+		}
+		left = append(left, other)
+	}
+	w.entities = left
+}
+
+// Find an entity by name, nil if there is none
+func (w *World) Entity(name string) *Entity {
+	for _, e := range w.entities {
+		if e.name == name {
+			return e
+		}
+	}
+	return nil
+}
+
+// The opaque triangles of an entity as a mesh per material, made when first
+// needed, and how far they have moved since
+func (e *Entity) Meshes() ([]*Mesh, vec3) {
+	if e.meshes == nil {
+		for _, b := range batchTriangles(e.triangles) {
+			e.meshes = append(e.meshes, NewMesh(b.material, b.triangles))
+		}
+		e.meshPosition = e.position
+	}
+	return e.meshes, e.move.scale(e.position - e.meshPosition)
+}
+
+// Throw away the meshes of an entity after its triangles changed
+func (e *Entity) dropMeshes() {
+	for _, mesh := range e.meshes {
+		mesh.Delete()
+	}
+	e.meshes = nil
+}
+
+// The entities with triangles in some sectors, which are still there
+func (w *World) EntitiesIn(sectors []*Sector) []*Entity {
+	in := map[*Sector]bool{}
+	for _, sector := range sectors {
+		in[sector] = true
+	}
+	var entities []*Entity
+	for _, e := range w.entities {
+		if in[e.sector] && e.kind != ENTITY_TRIGGER && !e.taken {
+			entities = append(entities, e)
+		}
+	}
+	return entities
+}
+
+// The triangles of a sector that aren't part of an entity, so never move
+func (s *Sector) staticTriangles() []*Triangle {
+	var triangles []*Triangle
+	for _, triangle := range s.triangles {
+		if triangle.entity == nil {
+			triangles = append(triangles, triangle)
+		}
+	}
+	return triangles
+}
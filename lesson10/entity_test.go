@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+// The gate of data/sectors.txt blocks the way north out of the hall until
+// it is used, and moving it leaves the BSP tree and meshes of the hall alone
+func TestDoor(t *testing.T) {
+	w, err := LoadWorld("data/sectors.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hall, gate := w.Sector("hall"), w.Entity("gate")
+	bsp, meshes := hall.BSP(), hall.Meshes()
+	gateMeshes, _ := gate.Meshes()
+
+	pos := w.Slide(hall, player, vec3{0, 0, -2}, vec3{0, 0, -1.5})
+	if pos[2] < -3 {
+		t.Fatalf("walked through the closed gate to %.3f", pos)
+	}
+	eye := pos.add(vec3{0, EYE_HEIGHT, 0})
+	if _, triangle, _ := w.Pick(eye, vec3{0, 0, -1}); triangle == nil || triangle.entity != gate {
+		t.Fatalf("looking north picks %v, want the gate", triangle)
+	}
+
+	w.Use(eye, vec3{0, 0, -1})
+	for i := 0; i < 100; i++ {
+		w.UpdateEntities(0.02, player, pos, true)
+	}
+	if gate.state != MOVER_OPEN || gate.position != 1 {
+		t.Fatalf("the gate is in state %d at %v, want open", gate.state, gate.position)
+	}
+	if got := w.Slide(hall, player, pos, vec3{0, 0, -1}); got[2] > -3.5 {
+		t.Errorf("the open gate still blocks at %.3f", got)
+	}
+
+	if hall.BSP() != bsp || hall.Meshes()[0] != meshes[0] {
+		t.Errorf("moving the gate rebuilt the BSP tree or meshes of the hall")
+	}
+	got, moved := gate.Meshes()
+	if got[0] != gateMeshes[0] || !near(moved, gate.move) {
+		t.Errorf("the meshes of the gate are moved by %v, want %v", moved, gate.move)
+	}
+
+	// it doesn't close on us standing in the doorway, but once we are gone
+	for i := 0; i < 400; i++ {
+		w.UpdateEntities(0.02, player, vec3{0, 0, -3}, true)
+	}
+	if gate.position < 0.5 {
+		t.Errorf("the gate closed on us to %v", gate.position)
+	}
+	for i := 0; i < 400; i++ {
+		w.UpdateEntities(0.02, player, vec3{0, 0, -5}, true)
+	}
+	if gate.state != MOVER_CLOSED || gate.position != 0 {
+		t.Errorf("the gate is in state %d at %v, want closed", gate.state, gate.position)
+	}
+}
+
+// Deleting the last triangle of an entity deletes the entity, and the
+// triggers opening it, so the world can be saved and loaded again
+func TestEditorDelete(t *testing.T) {
+	w, err := LoadWorld("data/sectors.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	world = w
+	defer func() { world = nil }()
+
+	for _, name := range []string{"gem", "vault"} {
+		e := w.Entity(name)
+		for len(e.triangles) > 0 {
+			editor.sector, editor.triangle = e.sector, e.triangles[0]
+			editor.delete()
+		}
+	}
+	for _, name := range []string{"gem", "vault", "vault-sensor"} {
+		if w.Entity(name) != nil {
+			t.Errorf("%s is still there", name)
+		}
+	}
+	if w.Entity("gate") == nil {
+		t.Errorf("the gate went too")
+	}
+
+	var buf bytes.Buffer
+	if err := WriteWorld(&buf, w, "data"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseWorld(&buf, "data"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEditorPath(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{"data/world.txt", "data/world-edited.txt"},
+		{"data/world.wld", "data/world-edited.txt"},
+		{"data/mesh.obj", "data/mesh-edited.txt"},
+		{"data/world-edited.txt", "data/world-edited.txt"},
+	}
+
+	for _, test := range tests {
+		if got := editorPath(test.path); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.path, got, test.want)
+		}
+	}
+}
+
+// A lift carries whoever stands on it up by its height
+func TestLift(t *testing.T) {
+	w, err := LoadWorld("data/levels.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lift := w.Entity("lift")
+	top := lift.bounds().max
+
+	center := lift.bounds().min.add(top).scale(0.5)
+	feet := vec3{center[0], top[1], center[2]}
+	for i := 0; i < 100; i++ {
+		feet = w.UpdateEntities(0.02, player, feet, true)
+	}
+	if lift.state != MOVER_OPEN || math.Abs(feet[1]-top[1]-lift.move[1]) > 1e-6 {
+		t.Errorf("the lift is in state %d with our feet at %v, want open at %v", lift.state, feet[1], top[1]+lift.move[1])
+	}
+}
+
+// A lift moving further than a step in one frame takes us along, up and
+// down again, rather than leaving us behind inside it
+func TestFastLift(t *testing.T) {
+	w, err := LoadWorld("data/levels.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lift := w.Entity("lift")
+	lift.speed = 20 // 0.4 a frame, the whole way in one
+	top := lift.bounds().max
+	center := lift.bounds().min.add(top).scale(0.5)
+
+	world, currentSector = w, w.sectors[0]
+	xpos, ypos, zpos, yspeed, onGround = center[0], top[1], center[2], 0, true
+	spawn = vec3{xpos, ypos, zpos}
+	defer func() {
+		world, currentSector = nil, nil
+		xpos, ypos, zpos, yspeed, onGround = 0, 0, 0, 0, false
+	}()
+
+	for i := 0; i < 50; i++ {
+		updatePlayer(0.02)
+	}
+	if lift.state != MOVER_OPEN || !onGround || math.Abs(ypos-top[1]-lift.move[1]) > 1e-6 {
+		t.Errorf("the lift is in state %d and we stand at %v on the ground %v, want open at %v", lift.state, ypos, onGround, top[1]+lift.move[1])
+	}
+
+	// after waiting it goes down with us, and up again as we still stand on it
+	for i := 0; i < 1000 && lift.state != MOVER_CLOSED; i++ {
+		updatePlayer(0.02)
+	}
+	if lift.state != MOVER_CLOSED || !onGround || math.Abs(ypos-top[1]) > 1e-6 {
+		t.Errorf("the lift is in state %d and we stand at %v on the ground %v, want closed at %v", lift.state, ypos, onGround, top[1])
+	}
+}
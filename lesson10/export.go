@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Write a world to path, as OBJ (with a MTL file next to it), binary glTF,
+// binary world or world.txt, depending on the extension. Only the binary
+// world and world.txt keep portals, the others just name their sectors.
+func ExportWorld(path string, world *World) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".obj":
+		mtlPath := strings.TrimSuffix(path, filepath.Ext(path)) + ".mtl"
+		err := writeFile(path, func(w io.Writer) error {
+			return WriteOBJ(w, world, filepath.Base(mtlPath))
+		})
+		if err != nil {
+			return err
+		}
+		return writeFile(mtlPath, func(w io.Writer) error {
+			return WriteMTL(w, world, filepath.Dir(mtlPath))
+		})
+	case ".glb":
+		return writeFile(path, func(w io.Writer) error {
+			return WriteGLB(w, world)
+		})
+	case ".wld":
+		return writeFile(path, func(w io.Writer) error {
+			return WriteBinaryWorld(w, world, filepath.Dir(path))
+		})
+	case ".txt":
+		return writeFile(path, func(w io.Writer) error {
+			return WriteWorld(w, world, filepath.Dir(path))
+		})
+	}
+
+	return fmt.Errorf("%s: don't know how to export to %q files", path, filepath.Ext(path))
+}
+
+// create the file at path and hand it to write, buffered
+func writeFile(path string, write func(w io.Writer) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	buf := bufio.NewWriter(file)
+	err = write(buf)
+	if err == nil {
+		err = buf.Flush()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// The materials used by triangles, in the order they first appear.
+// Triangles without a material use defaultMaterial.
+func usedMaterials(triangles []*Triangle) []*Material {
+	var materials []*Material
+	seen := map[*Material]bool{}
+	for _, triangle := range triangles {
+		material := triangleMaterial(triangle)
+		if !seen[material] {
+			seen[material] = true
+			materials = append(materials, material)
+		}
+	}
+	return materials
+}
+
+func triangleMaterial(triangle *Triangle) *Material {
+	if triangle.material == nil {
+		return defaultMaterial
+	}
+	return triangle.material
+}
+
+// Number every distinct vertex of triangles, in the order they first appear
+func distinctVertices(triangles []*Triangle) ([]*Vertex, map[*Vertex]int) {
+	var vertices []*Vertex
+	indices := map[*Vertex]int{}
+	for _, triangle := range triangles {
+		for _, vertex := range triangle.vertices {
+			if _, ok := indices[vertex]; !ok {
+				indices[vertex] = len(vertices)
+				vertices = append(vertices, vertex)
+			}
+		}
+	}
+	return vertices, indices
+}
+
+// Write a world as Wavefront OBJ, using the materials in mtllib. Every
+// sector becomes an object of the same name.
+func WriteOBJ(w io.Writer, world *World, mtllib string) error {
+	triangles := world.Triangles()
+	vertices, indices := distinctVertices(triangles)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# %d triangles in %d sectors\n", len(triangles), len(world.sectors))
+	fmt.Fprintf(&buf, "mtllib %s\n\n", mtllib)
+
+	for _, v := range vertices {
+		fmt.Fprintf(&buf, "v %g %g %g\n", v.x, v.y, v.z)
+	}
+	for _, v := range vertices {
+		// OBJ texture coordinates start at the bottom of the image
+		fmt.Fprintf(&buf, "vt %g %g\n", v.u, 1-v.v)
+	}
+	for _, v := range vertices {
+		fmt.Fprintf(&buf, "vn %g %g %g\n", v.nx, v.ny, v.nz)
+	}
+
+	// vertex, uv and normal share their index, and OBJ counts from 1
+	for _, sector := range world.sectors {
+		fmt.Fprintf(&buf, "\no %s\n", sector.name)
+
+		var material *Material
+		for _, triangle := range sector.triangles {
+			if m := triangleMaterial(triangle); m != material {
+				material = m
+				fmt.Fprintf(&buf, "usemtl %s\n", material.name)
+			}
+			buf.WriteString("f")
+			for _, vertex := range triangle.vertices {
+				i := indices[vertex] + 1
+				fmt.Fprintf(&buf, " %d/%d/%d", i, i, i)
+			}
+			buf.WriteString("\n")
+		}
+	}
+
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// Write the materials of a world as MTL. Texture paths are made relative
+// to dir, the directory the MTL file is written to.
+func WriteMTL(w io.Writer, world *World, dir string) error {
+	var buf bytes.Buffer
+	for _, material := range usedMaterials(world.Triangles()) {
+		fmt.Fprintf(&buf, "newmtl %s\n", material.name)
+		fmt.Fprintf(&buf, "Kd %g %g %g\n", material.color[0], material.color[1], material.color[2])
+		fmt.Fprintf(&buf, "d %g\n", material.color[3])
+		if material.texture != "" {
+			texture, err := relativePath(dir, material.texture)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&buf, "map_Kd %s\n", filepath.ToSlash(texture))
+		}
+		buf.WriteString("\n")
+	}
+
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// path as seen from dir
+func relativePath(dir, path string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Rel(absDir, absPath)
+}
+
+// The parts of glTF 2.0 we write
+type gltf struct {
+	Asset       gltfAsset        `json:"asset"`
+	Scene       int              `json:"scene"`
+	Scenes      []gltfScene      `json:"scenes"`
+	Nodes       []gltfNode       `json:"nodes,omitempty"`
+	Meshes      []gltfMesh       `json:"meshes,omitempty"`
+	Materials   []gltfMaterial   `json:"materials,omitempty"`
+	Textures    []gltfTexture    `json:"textures,omitempty"`
+	Images      []gltfImage      `json:"images,omitempty"`
+	Samplers    []gltfSampler    `json:"samplers,omitempty"`
+	Accessors   []gltfAccessor   `json:"accessors,omitempty"`
+	BufferViews []gltfBufferView `json:"bufferViews,omitempty"`
+	Buffers     []gltfBuffer     `json:"buffers"`
+}
+
+type gltfAsset struct {
+	Version   string `json:"version"`
+	Generator string `json:"generator"`
+}
+
+type gltfScene struct {
+	Nodes []int `json:"nodes"`
+}
+
+type gltfNode struct {
+	Name string `json:"name"`
+	Mesh int    `json:"mesh"`
+}
+
+type gltfMesh struct {
+	Name       string          `json:"name"`
+	Primitives []gltfPrimitive `json:"primitives"`
+}
+
+type gltfPrimitive struct {
+	Attributes map[string]int `json:"attributes"`
+	Indices    int            `json:"indices"`
+	Material   int            `json:"material"`
+}
+
+type gltfMaterial struct {
+	Name                 string  `json:"name"`
+	PBRMetallicRoughness gltfPBR `json:"pbrMetallicRoughness"`
+	AlphaMode            string  `json:"alphaMode,omitempty"`
+	DoubleSided          bool    `json:"doubleSided"`
+}
+
+type gltfPBR struct {
+	BaseColorFactor  [4]float32       `json:"baseColorFactor"`
+	BaseColorTexture *gltfTextureInfo `json:"baseColorTexture,omitempty"`
+	MetallicFactor   float32          `json:"metallicFactor"`
+	RoughnessFactor  float32          `json:"roughnessFactor"`
+}
+
+type gltfTextureInfo struct {
+	Index int `json:"index"`
+}
+
+type gltfTexture struct {
+	Sampler int `json:"sampler"`
+	Source  int `json:"source"`
+}
+
+type gltfImage struct {
+	Name       string `json:"name"`
+	BufferView int    `json:"bufferView"`
+	MimeType   string `json:"mimeType"`
+}
+
+type gltfSampler struct {
+	MagFilter int `json:"magFilter"`
+	MinFilter int `json:"minFilter"`
+	WrapS     int `json:"wrapS"`
+	WrapT     int `json:"wrapT"`
+}
+
+type gltfAccessor struct {
+	BufferView    int       `json:"bufferView"`
+	ComponentType int       `json:"componentType"`
+	Count         int       `json:"count"`
+	Type          string    `json:"type"`
+	Min           []float32 `json:"min,omitempty"`
+	Max           []float32 `json:"max,omitempty"`
+}
+
+type gltfBufferView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	Target     int `json:"target,omitempty"`
+}
+
+type gltfBuffer struct {
+	ByteLength int `json:"byteLength"`
+}
+
+// glTF constants, the same numbers GL uses
+const (
+	GLTF_FLOAT                = 5126
+	GLTF_UNSIGNED_INT         = 5125
+	GLTF_ARRAY_BUFFER         = 34962
+	GLTF_ELEMENT_ARRAY_BUFFER = 34963
+	GLTF_LINEAR               = 9729
+	GLTF_LINEAR_MIPMAP_LINEAR = 9987
+	GLTF_REPEAT               = 10497
+)
+
+// gltfBuilder collects the JSON document and binary buffer of a GLB file
+type gltfBuilder struct {
+	doc gltf
+	bin bytes.Buffer
+}
+
+// Append data to the binary buffer as a new buffer view, returning its index
+func (b *gltfBuilder) bufferView(data interface{}, target int) int {
+	// views have to start 4 byte aligned
+	for b.bin.Len()%4 != 0 {
+		b.bin.WriteByte(0)
+	}
+
+	offset := b.bin.Len()
+	binary.Write(&b.bin, binary.LittleEndian, data)
+
+	b.doc.BufferViews = append(b.doc.BufferViews, gltfBufferView{
+		ByteOffset: offset,
+		ByteLength: b.bin.Len() - offset,
+		Target:     target,
+	})
+	return len(b.doc.BufferViews) - 1
+}
+
+func (b *gltfBuilder) accessor(view, componentType, count int, typ string) int {
+	b.doc.Accessors = append(b.doc.Accessors, gltfAccessor{
+		BufferView:    view,
+		ComponentType: componentType,
+		Count:         count,
+		Type:          typ,
+	})
+	return len(b.doc.Accessors) - 1
+}
+
+// Write a world as binary glTF 2.0, with a node for every sector.
+// Textures are embedded as PNG.
+func WriteGLB(w io.Writer, world *World) error {
+	b := &gltfBuilder{}
+	b.doc.Asset = gltfAsset{Version: "2.0", Generator: "opengl-go-tutorials lesson10"}
+	b.doc.Scenes = []gltfScene{{Nodes: []int{}}}
+
+	// all primitives share one set of vertex attributes
+	triangles := world.Triangles()
+	vertices, indices := distinctVertices(triangles)
+	positions := make([]float32, 0, len(vertices)*3)
+	normals := make([]float32, 0, len(vertices)*3)
+	uvs := make([]float32, 0, len(vertices)*2)
+	lower := []float32{math.MaxFloat32, math.MaxFloat32, math.MaxFloat32}
+	upper := []float32{-math.MaxFloat32, -math.MaxFloat32, -math.MaxFloat32}
+	hasNormals := true
+
+	for _, v := range vertices {
+		position := [3]float32{float32(v.x), float32(v.y), float32(v.z)}
+		for i, c := range position {
+			lower[i] = float32(math.Min(float64(lower[i]), float64(c)))
+			upper[i] = float32(math.Max(float64(upper[i]), float64(c)))
+		}
+		positions = append(positions, position[:]...)
+		normals = append(normals, float32(v.nx), float32(v.ny), float32(v.nz))
+		uvs = append(uvs, float32(v.u), float32(v.v))
+
+		// glTF wants unit normals, so leave them out if some are missing
+		if v.nx == 0 && v.ny == 0 && v.nz == 0 {
+			hasNormals = false
+		}
+	}
+
+	attributes := map[string]int{}
+	if len(vertices) > 0 {
+		position := b.accessor(b.bufferView(positions, GLTF_ARRAY_BUFFER), GLTF_FLOAT, len(vertices), "VEC3")
+		b.doc.Accessors[position].Min, b.doc.Accessors[position].Max = lower, upper
+		attributes["POSITION"] = position
+		attributes["TEXCOORD_0"] = b.accessor(b.bufferView(uvs, GLTF_ARRAY_BUFFER), GLTF_FLOAT, len(vertices), "VEC2")
+		if hasNormals {
+			attributes["NORMAL"] = b.accessor(b.bufferView(normals, GLTF_ARRAY_BUFFER), GLTF_FLOAT, len(vertices), "VEC3")
+		}
+	}
+
+	materials := map[*Material]int{}
+	for i, material := range usedMaterials(triangles) {
+		if err := b.material(material); err != nil {
+			return err
+		}
+		materials[material] = i
+	}
+
+	// a mesh for each sector, with a primitive for each material in it
+	for _, sector := range world.sectors {
+		if len(sector.triangles) == 0 {
+			continue // glTF meshes need at least one primitive
+		}
+
+		mesh := gltfMesh{Name: sector.name}
+		for _, material := range usedMaterials(sector.triangles) {
+			var elements []uint32
+			for _, triangle := range sector.triangles {
+				if triangleMaterial(triangle) != material {
+					continue
+				}
+				for _, vertex := range triangle.vertices {
+					elements = append(elements, uint32(indices[vertex]))
+				}
+			}
+
+			mesh.Primitives = append(mesh.Primitives, gltfPrimitive{
+				Attributes: attributes,
+				Indices:    b.accessor(b.bufferView(elements, GLTF_ELEMENT_ARRAY_BUFFER), GLTF_UNSIGNED_INT, len(elements), "SCALAR"),
+				Material:   materials[material],
+			})
+		}
+
+		b.doc.Meshes = append(b.doc.Meshes, mesh)
+		b.doc.Nodes = append(b.doc.Nodes, gltfNode{Name: sector.name, Mesh: len(b.doc.Meshes) - 1})
+		b.doc.Scenes[0].Nodes = append(b.doc.Scenes[0].Nodes, len(b.doc.Nodes)-1)
+	}
+
+	for b.bin.Len()%4 != 0 {
+		b.bin.WriteByte(0)
+	}
+	b.doc.Buffers = []gltfBuffer{{ByteLength: b.bin.Len()}}
+
+	doc, err := json.Marshal(b.doc)
+	if err != nil {
+		return err
+	}
+	for len(doc)%4 != 0 {
+		doc = append(doc, ' ')
+	}
+
+	// a GLB file is a header followed by a JSON and a BIN chunk
+	var out bytes.Buffer
+	binary.Write(&out, binary.LittleEndian, []uint32{
+		0x46546c67, 2, uint32(12 + 8 + len(doc) + 8 + b.bin.Len()),
+		uint32(len(doc)), 0x4e4f534a,
+	})
+	out.Write(doc)
+	binary.Write(&out, binary.LittleEndian, []uint32{uint32(b.bin.Len()), 0x004e4942})
+	b.bin.WriteTo(&out)
+
+	_, err = out.WriteTo(w)
+	return err
+}
+
+// Add a material, and its texture as an embedded PNG
+func (b *gltfBuilder) material(material *Material) error {
+	m := gltfMaterial{
+		Name:        material.name,
+		DoubleSided: true, // lesson10 doesn't cull back faces either
+		PBRMetallicRoughness: gltfPBR{
+			BaseColorFactor: [4]float32{
+				float32(material.color[0]), float32(material.color[1]),
+				float32(material.color[2]), float32(material.color[3]),
+			},
+			RoughnessFactor: 1,
+		},
+	}
+	if material.color[3] < 1 || material.blend != BLEND_NONE {
+		m.AlphaMode = "BLEND"
+	}
+
+	if material.texture != "" {
+		img, err := ReadImage(material.texture)
+		if err != nil {
+			return err
+		}
+
+		var encoded bytes.Buffer
+		if err := png.Encode(&encoded, img.RGBA()); err != nil {
+			return err
+		}
+
+		if len(b.doc.Samplers) == 0 {
+			b.doc.Samplers = []gltfSampler{{GLTF_LINEAR, GLTF_LINEAR_MIPMAP_LINEAR, GLTF_REPEAT, GLTF_REPEAT}}
+		}
+		b.doc.Images = append(b.doc.Images, gltfImage{
+			Name:       filepath.Base(material.texture),
+			BufferView: b.bufferView(encoded.Bytes(), 0),
+			MimeType:   "image/png",
+		})
+		b.doc.Textures = append(b.doc.Textures, gltfTexture{Sampler: 0, Source: len(b.doc.Images) - 1})
+		m.PBRMetallicRoughness.BaseColorTexture = &gltfTextureInfo{Index: len(b.doc.Textures) - 1}
+	}
+
+	b.doc.Materials = append(b.doc.Materials, m)
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Export the sample worlds as OBJ and read them back. OBJ has no sectors,
+// so the triangles of all sectors end up in one, in the same order.
+func TestExportOBJ(t *testing.T) {
+	for _, name := range []string{"world.txt", "sectors.txt", "levels.txt"} {
+		world, err := SetupWorld("data/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		path := filepath.Join(t.TempDir(), strings.TrimSuffix(name, ".txt")+".obj")
+		if err := ExportWorld(path, world); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		loaded, err := LoadOBJ(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		want, got := world.Triangles(), loaded.Triangles()
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %d triangles back, want %d", name, len(got), len(want))
+		}
+		for i := range want {
+			if err := sameTriangle(got[i], want[i]); err != "" {
+				t.Errorf("%s: triangle %d: %s", name, i, err)
+				break
+			}
+		}
+	}
+}
+
+// Compare position, texture coordinates and material of two triangles,
+// returning what differs
+func sameTriangle(got, want *Triangle) string {
+	for c := range want.vertices {
+		g, w := got.vertices[c], want.vertices[c]
+		if g.x != w.x || g.y != w.y || g.z != w.z {
+			return "corner at a different position"
+		}
+		// flipping v twice may round
+		if math.Abs(float64(g.u-w.u)) > 1e-5 || math.Abs(float64(g.v-w.v)) > 1e-5 {
+			return "different texture coordinates"
+		}
+	}
+
+	gm, wm := triangleMaterial(got), triangleMaterial(want)
+	if gm.name != wm.name || gm.color != wm.color {
+		return "material " + gm.name + " instead of " + wm.name
+	}
+	gt, _ := filepath.Abs(gm.texture)
+	wt, _ := filepath.Abs(wm.texture)
+	if gt != wt {
+		return "texture " + gt + " instead of " + wt
+	}
+	return ""
+}
+
+// the chunk types of a GLB file
+const (
+	GLB_JSON = 0x4e4f534a
+	GLB_BIN  = 0x004e4942
+)
+
+func TestWriteGLB(t *testing.T) {
+	// a material without texture, reading images needs SDL
+	src := "MATERIAL glass - 0.5 0.5 1 0.5 alpha\nSECTOR a\nUSE glass\n" + twoTriangles +
+		"SECTOR b\nMATERIAL red - 1 0 0 1\nUSE red\n" + twoTriangles + "0 1 0 0 0\n0 1 1 0 1\n1 1 1 1 1\n"
+	world, err := ParseWorld(strings.NewReader(src), "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteGLB(&buf, world); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// the 12 byte header
+	if len(data) < 12 {
+		t.Fatalf("only %d bytes", len(data))
+	}
+	header := func(i int) uint32 { return binary.LittleEndian.Uint32(data[i:]) }
+	if header(0) != 0x46546c67 || header(4) != 2 {
+		t.Fatalf("got magic %#x version %d, want glTF 2", header(0), header(4))
+	}
+	if int(header(8)) != len(data) {
+		t.Fatalf("header says %d bytes, got %d", header(8), len(data))
+	}
+
+	// followed by the JSON and BIN chunks, both 4 byte aligned
+	var chunks [][]byte
+	for offset := 12; offset < len(data); {
+		length, kind := int(header(offset)), header(offset+4)
+		if length%4 != 0 || offset+8+length > len(data) {
+			t.Fatalf("chunk %d at %d: bad length %d", len(chunks), offset, length)
+		}
+		if want := []uint32{GLB_JSON, GLB_BIN}[len(chunks)%2]; kind != want {
+			t.Fatalf("chunk %d: got type %#x, want %#x", len(chunks), kind, want)
+		}
+		chunks = append(chunks, data[offset+8:offset+8+length])
+		offset += 8 + length
+	}
+	if len(chunks) != 2 {
+		t.Fatalf("got %d chunks, want JSON and BIN", len(chunks))
+	}
+
+	var doc gltf
+	if err := json.Unmarshal(chunks[0], &doc); err != nil {
+		t.Fatal(err)
+	}
+	bin := chunks[1]
+	if len(doc.Buffers) != 1 || doc.Buffers[0].ByteLength != len(bin) {
+		t.Fatalf("buffers %+v don't match the %d byte BIN chunk", doc.Buffers, len(bin))
+	}
+	for i, view := range doc.BufferViews {
+		if view.ByteOffset%4 != 0 || view.ByteOffset+view.ByteLength > len(bin) {
+			t.Errorf("buffer view %d %+v isn't aligned inside the buffer", i, view)
+		}
+	}
+
+	// a node and mesh per sector, each with a primitive per material
+	if len(doc.Nodes) != 2 || doc.Nodes[0].Name != "a" || doc.Nodes[1].Name != "b" {
+		t.Fatalf("got nodes %+v, want a and b", doc.Nodes)
+	}
+	if len(doc.Materials) != 2 || doc.Materials[0].Name != "glass" || doc.Materials[0].AlphaMode != "BLEND" {
+		t.Errorf("got materials %+v, want glass blended and red", doc.Materials)
+	}
+
+	// the positions read back through the indices are the triangles
+	floats := func(accessor int) []float32 {
+		view := doc.BufferViews[doc.Accessors[accessor].BufferView]
+		values := make([]float32, view.ByteLength/4)
+		binary.Read(bytes.NewReader(bin[view.ByteOffset:view.ByteOffset+view.ByteLength]), binary.LittleEndian, values)
+		return values
+	}
+	indices := func(accessor int) []uint32 {
+		view := doc.BufferViews[doc.Accessors[accessor].BufferView]
+		values := make([]uint32, doc.Accessors[accessor].Count)
+		binary.Read(bytes.NewReader(bin[view.ByteOffset:view.ByteOffset+view.ByteLength]), binary.LittleEndian, values)
+		return values
+	}
+
+	for s, sector := range world.sectors {
+		primitive := doc.Meshes[doc.Nodes[s].Mesh].Primitives[0]
+		positions := floats(primitive.Attributes["POSITION"])
+		elements := indices(primitive.Indices)
+		if len(elements) != 3*len(sector.triangles) {
+			t.Errorf("sector %s: got %d indices, want %d", sector.name, len(elements), 3*len(sector.triangles))
+			continue
+		}
+		for i, triangle := range sector.triangles {
+			for c, v := range triangle.vertices {
+				p := positions[3*elements[3*i+c]:]
+				if p[0] != float32(v.x) || p[1] != float32(v.y) || p[2] != float32(v.z) {
+					t.Errorf("sector %s triangle %d corner %d: got %v, want %v %v %v", sector.name, i, c, p[:3], v.x, v.y, v.z)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"github.com/banthar/gl"
+	"math"
+)
+
+// How fog thickens with the distance from the camera
+const (
+	FOG_NONE   = iota
+	FOG_LINEAR // from none at start to all fog at end
+	FOG_EXP    // 1 - e^-(density*distance)
+	FOG_EXP2   // 1 - e^-(density*distance)^2, clearer up close
+)
+
+// the names of the fog modes in world files
+var fogNames = []string{
+	FOG_NONE:   "none",
+	FOG_LINEAR: "linear",
+	FOG_EXP:    "exp",
+	FOG_EXP2:   "exp2",
+}
+
+// the fog modes as OpenGL knows them
+var fogModes = []gl.GLenum{
+	FOG_LINEAR: gl.LINEAR,
+	FOG_EXP:    gl.EXP,
+	FOG_EXP2:   gl.EXP2,
+}
+
+// A Fog fades what is far away into its color, which the screen is also
+// cleared with, so long hallways don't end at the far plane
+type Fog struct {
+	mode       int
+	density    float64 // for FOG_EXP and FOG_EXP2
+	start, end float64 // for FOG_LINEAR
+	color      [3]float32
+	comments   []string
+}
+
+// the fog of worlds without a FOG line, grey and off until the o key
+// cycles through the modes
+var defaultFog = Fog{
+	mode:    FOG_NONE,
+	density: 0.1,
+	start:   5.0,
+	end:     FAR_PLANE / 4,
+	color:   [3]float32{0.5, 0.5, 0.5},
+}
+
+// the fog we walk through
+var fog = defaultFog
+
+// Switch to the next fog mode, after FOG_EXP2 back to none
+func (f *Fog) cycle() {
+	f.mode = (f.mode + 1) % len(fogNames)
+}
+
+// Make the fog thicker by a factor, or thinner below 1: denser, and
+// ending closer to the camera
+func (f *Fog) thicken(factor float64) {
+	f.density = math.Min(f.density*factor, 10.0)
+	f.end = math.Max(f.start+0.1, math.Min(f.end/factor, FAR_PLANE))
+}
+
+// The color the screen is cleared with
+func (f *Fog) clearColor() {
+	if f.mode == FOG_NONE {
+		gl.ClearColor(0.0, 0.0, 0.0, 0.0)
+	} else {
+		gl.ClearColor(gl.GLclampf(f.color[0]), gl.GLclampf(f.color[1]), gl.GLclampf(f.color[2]), 1.0)
+	}
+}
+
+// Fog what is drawn until disable is called
+func (f *Fog) enable() {
+	if f.mode == FOG_NONE {
+		return
+	}
+
+	gl.Fogi(gl.FOG_MODE, int(fogModes[f.mode]))
+	gl.Fogfv(gl.FOG_COLOR, []float32{f.color[0], f.color[1], f.color[2], 1.0})
+	gl.Fogf(gl.FOG_DENSITY, float32(f.density))
+	gl.Fogf(gl.FOG_START, float32(f.start))
+	gl.Fogf(gl.FOG_END, float32(f.end))
+	gl.Hint(gl.FOG_HINT, gl.NICEST)
+	gl.Enable(gl.FOG)
+}
+
+// Stop fogging, for the overlays drawn on top of the scene
+func (f *Fog) disable() {
+	gl.Disable(gl.FOG)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	// triangles in a leaf of a bounding volume hierarchy
+	BVH_LEAF_SIZE = 4
+)
+
+// A BVH is a bounding volume hierarchy over triangles: a binary tree of
+// boxes, each around the triangles below it, so a query only looks at the
+// triangles in boxes it touches.
+type BVH struct {
+	nodes     []bvhNode // the root is the first
+	triangles []*Triangle
+}
+
+// A bvhNode either has two children, or is a leaf with some triangles
+type bvhNode struct {
+	box         bounds
+	left, right int // indices of the children, 0 for leaves
+	first, n    int // triangles of a leaf
+}
+
+// Build a BVH over some triangles. The triangles are kept in their own
+// slice, so the one given isn't changed.
+func BuildBVH(triangles []*Triangle) *BVH {
+	b := &BVH{triangles: append([]*Triangle(nil), triangles...)}
+	if len(triangles) > 0 {
+		centers := make([]vec3, len(triangles))
+		for i, triangle := range b.triangles {
+			centers[i] = centroid(triangle)
+		}
+		b.build(centers, 0, len(b.triangles))
+	}
+	return b
+}
+
+// Triangles with their centroids, sorted along an axis
+type byCentroid struct {
+	triangles []*Triangle
+	centers   []vec3
+	axis      int
+}
+
+func (s byCentroid) Len() int { return len(s.triangles) }
+
+func (s byCentroid) Less(i, j int) bool {
+	return s.centers[i][s.axis] < s.centers[j][s.axis]
+}
+
+func (s byCentroid) Swap(i, j int) {
+	s.triangles[i], s.triangles[j] = s.triangles[j], s.triangles[i]
+	s.centers[i], s.centers[j] = s.centers[j], s.centers[i]
+}
+
+// Add a node for the triangles from first to last, returning its index.
+// centers holds the centroids of the triangles, in the same order.
+func (b *BVH) build(centers []vec3, first, last int) int {
+	index := len(b.nodes)
+	b.nodes = append(b.nodes, bvhNode{first: first, n: last - first})
+	b.nodes[index].box = b.box(first, last)
+	if last-first <= BVH_LEAF_SIZE {
+		return index
+	}
+
+	// split in the middle along the axis the triangles spread out most
+	spread := emptyBounds()
+	for _, center := range centers[first:last] {
+		spread.extend(center)
+	}
+	axis := 0
+	size := spread.max.sub(spread.min)
+	if size[1] > size[axis] {
+		axis = 1
+	}
+	if size[2] > size[axis] {
+		axis = 2
+	}
+	sort.Sort(byCentroid{b.triangles[first:last], centers[first:last], axis})
+
+	middle := (first + last) / 2
+	left := b.build(centers, first, middle)
+	right := b.build(centers, middle, last)
+	b.nodes[index].left, b.nodes[index].right, b.nodes[index].n = left, right, 0
+	return index
+}
+
+// The box around the triangles from first to last
+func (b *BVH) box(first, last int) bounds {
+	box := emptyBounds()
+	for _, triangle := range b.triangles[first:last] {
+		for _, vertex := range triangle.vertices {
+			box.extend(vertex.pos())
+		}
+	}
+	return box
+}
+
+func centroid(triangle *Triangle) vec3 {
+	c := triangle.corners()
+	return c[0].add(c[1]).add(c[2]).scale(1.0 / 3)
+}
+
+// Fit the boxes to the triangles again after they moved. This is much
+// faster than building a new BVH, but the tree gets worse the further the
+// triangles move.
+func (b *BVH) Refit() {
+	// children always come after their parents
+	for i := len(b.nodes) - 1; i >= 0; i-- {
+		node := &b.nodes[i]
+		if node.left == 0 {
+			node.box = b.box(node.first, node.first+node.n)
+			continue
+		}
+		node.box = b.nodes[node.left].box
+		node.box.extend(b.nodes[node.right].box.min)
+		node.box.extend(b.nodes[node.right].box.max)
+	}
+}
+
+// Visit the triangles whose boxes may overlap box
+func (b *BVH) Query(box bounds, visit func(*Triangle)) {
+	if len(b.nodes) == 0 {
+		return
+	}
+	stack := []int{0}
+	for len(stack) > 0 {
+		node := &b.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+		if !overlaps(node.box, box) {
+			continue
+		}
+		if node.left == 0 {
+			for _, triangle := range b.triangles[node.first : node.first+node.n] {
+				visit(triangle)
+			}
+			continue
+		}
+		stack = append(stack, node.left, node.right)
+	}
+}
+
+func overlaps(a, b bounds) bool {
+	for i := range a.min {
+		if a.max[i] < b.min[i] || b.max[i] < a.min[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// The triangles closer to center than radius
+func (b *BVH) Overlap(center vec3, radius float64) []*Triangle {
+	r := vec3{radius, radius, radius}
+	var found []*Triangle
+	b.Query(bounds{min: center.sub(r), max: center.add(r)}, func(triangle *Triangle) {
+		if closestPointTriangle(center, triangle.corners()).sub(center).length() < radius {
+			found = append(found, triangle)
+		}
+	})
+	return found
+}
+
+// Find the first triangle hit on the way from origin along dir, up to a
+// distance of maxDist, and the distance to the hit
+func (b *BVH) Raycast(origin, dir vec3, maxDist float64) (*Triangle, float64) {
+	if len(b.nodes) == 0 {
+		return nil, 0
+	}
+
+	var inverse vec3
+	for i := range dir {
+		inverse[i] = 1 / dir[i]
+	}
+
+	var hit *Triangle
+	closest := maxDist
+	stack := []int{0}
+	for len(stack) > 0 {
+		node := &b.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+		if t, ok := rayBox(origin, inverse, node.box); !ok || t > closest {
+			continue
+		}
+		if node.left == 0 {
+			for _, triangle := range b.triangles[node.first : node.first+node.n] {
+				if t, ok := rayTriangle(origin, dir, triangle.corners()); ok && t <= closest {
+					hit, closest = triangle, t
+				}
+			}
+			continue
+		}
+
+		// look at the nearer child first, it more likely has the hit
+		near, far := node.left, node.right
+		tl, _ := rayBox(origin, inverse, b.nodes[near].box)
+		tr, _ := rayBox(origin, inverse, b.nodes[far].box)
+		if tr < tl {
+			near, far = far, near
+		}
+		stack = append(stack, far, near)
+	}
+
+	if hit == nil {
+		return nil, 0
+	}
+	return hit, closest
+}
+
+// Where a ray enters a box, given one over its direction, if it does
+func rayBox(origin, inverse vec3, box bounds) (float64, bool) {
+	tmin, tmax := 0.0, math.Inf(1)
+	for i := range origin {
+		t1 := (box.min[i] - origin[i]) * inverse[i]
+		t2 := (box.max[i] - origin[i]) * inverse[i]
+		if t1 > t2 {
+			t1, t2 = t2, t1
+		}
+		// a ray along a face of the box gives NaN, which doesn't count
+		if t1 > tmin {
+			tmin = t1
+		}
+		if t2 < tmax {
+			tmax = t2
+		}
+	}
+	return tmin, tmin <= tmax
+}
+
+// Find the triangle closest to p within maxDist, the point on it closest
+// to p and how far that is
+func (b *BVH) Nearest(p vec3, maxDist float64) (*Triangle, vec3, float64) {
+	var nearest *Triangle
+	var point vec3
+	closest := maxDist
+	if len(b.nodes) == 0 {
+		return nil, point, 0
+	}
+
+	stack := []int{0}
+	for len(stack) > 0 {
+		node := &b.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+		if boxDistance(p, node.box) >= closest {
+			continue
+		}
+		if node.left == 0 {
+			for _, triangle := range b.triangles[node.first : node.first+node.n] {
+				q := closestPointTriangle(p, triangle.corners())
+				if d := q.sub(p).length(); d < closest {
+					nearest, point, closest = triangle, q, d
+				}
+			}
+			continue
+		}
+
+		near, far := node.left, node.right
+		if boxDistance(p, b.nodes[far].box) < boxDistance(p, b.nodes[near].box) {
+			near, far = far, near
+		}
+		stack = append(stack, far, near)
+	}
+
+	if nearest == nil {
+		return nil, point, 0
+	}
+	return nearest, point, closest
+}
+
+// How far p is from a box, 0 inside it
+func boxDistance(p vec3, box bounds) float64 {
+	var d vec3
+	for i := range p {
+		d[i] = math.Max(0, math.Max(box.min[i]-p[i], p[i]-box.max[i]))
+	}
+	return d.length()
+}
+
+// The BVH of a sector, built when first needed
+func (s *Sector) Index() *BVH {
+	if s.index == nil {
+		s.index = BuildBVH(s.triangles)
+	}
+	return s.index
+}
+
+// Build the BVHs of all sectors, so the first frame doesn't have to
+func (w *World) BuildIndex() {
+	for _, sector := range w.sectors {
+		sector.Index()
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"github.com/banthar/gl"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// A world of n random triangles scattered through a box
+func randomWorld(n int, random *rand.Rand) *World {
+	const size, triangleSize = 100.0, 1.0
+
+	point := func(around vec3, spread float64) *Vertex {
+		return &Vertex{
+			x: gl.GLfloat(around[0] + (random.Float64()-0.5)*spread),
+			y: gl.GLfloat(around[1] + (random.Float64()-0.5)*spread),
+			z: gl.GLfloat(around[2] + (random.Float64()-0.5)*spread),
+		}
+	}
+
+	sector := &Sector{name: "default"}
+	for i := 0; i < n; i++ {
+		center := point(vec3{}, size).pos()
+		sector.triangles = append(sector.triangles, &Triangle{vertices: [3]*Vertex{
+			point(center, triangleSize), point(center, triangleSize), point(center, triangleSize),
+		}})
+	}
+	return &World{sectors: []*Sector{sector}}
+}
+
+// A query to ask both the BVH and every triangle
+type indexQuery struct {
+	p, dir vec3
+	radius float64
+}
+
+// n queries spread through the box of randomWorld
+func randomQueries(n int, random *rand.Rand) []indexQuery {
+	queries := make([]indexQuery, n)
+	for i := range queries {
+		p := vec3{random.Float64() - 0.5, random.Float64() - 0.5, random.Float64() - 0.5}
+		dir := vec3{random.NormFloat64(), random.NormFloat64(), random.NormFloat64()}
+		queries[i] = indexQuery{p: p.scale(100), dir: dir.normalize(), radius: 2}
+	}
+	return queries
+}
+
+// What the BVH answers, found by looking at every triangle
+func bruteRaycast(triangles []*Triangle, origin, dir vec3) (*Triangle, float64) {
+	var hit *Triangle
+	closest := math.Inf(1)
+	for _, triangle := range triangles {
+		if t, ok := rayTriangle(origin, dir, triangle.corners()); ok && t < closest {
+			hit, closest = triangle, t
+		}
+	}
+	return hit, closest
+}
+
+func bruteOverlap(triangles []*Triangle, center vec3, radius float64) []*Triangle {
+	var found []*Triangle
+	for _, triangle := range triangles {
+		if closestPointTriangle(center, triangle.corners()).sub(center).length() < radius {
+			found = append(found, triangle)
+		}
+	}
+	return found
+}
+
+func bruteNearest(triangles []*Triangle, p vec3) (*Triangle, float64) {
+	var nearest *Triangle
+	closest := math.Inf(1)
+	for _, triangle := range triangles {
+		if d := closestPointTriangle(p, triangle.corners()).sub(p).length(); d < closest {
+			nearest, closest = triangle, d
+		}
+	}
+	return nearest, closest
+}
+
+// Compare the answers of a BVH to looking at every triangle
+func checkIndex(t *testing.T, name string, index *BVH, triangles []*Triangle, queries []indexQuery) {
+	for _, q := range queries {
+		hit, d := index.Raycast(q.p, q.dir, math.Inf(1))
+		want, wantD := bruteRaycast(triangles, q.p, q.dir)
+		if (hit == nil) != (want == nil) || hit != nil && math.Abs(d-wantD) > 1e-9 {
+			t.Fatalf("%s: ray from %v along %.3f hit at %v, want %v", name, q.p, q.dir, d, wantD)
+		}
+
+		found := map[*Triangle]bool{}
+		for _, triangle := range index.Overlap(q.p, q.radius) {
+			found[triangle] = true
+		}
+		wantFound := bruteOverlap(triangles, q.p, q.radius)
+		if len(found) != len(wantFound) {
+			t.Fatalf("%s: sphere at %v overlaps %d triangles, want %d", name, q.p, len(found), len(wantFound))
+		}
+		for _, triangle := range wantFound {
+			if !found[triangle] {
+				t.Fatalf("%s: sphere at %v misses a triangle it overlaps", name, q.p)
+			}
+		}
+
+		_, _, d = index.Nearest(q.p, math.Inf(1))
+		if _, wantD := bruteNearest(triangles, q.p); math.Abs(d-wantD) > 1e-9 {
+			t.Fatalf("%s: nearest surface to %v is %v away, want %v", name, q.p, d, wantD)
+		}
+	}
+}
+
+func TestIndex(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	triangles := randomWorld(2000, random).sectors[0].triangles
+	queries := randomQueries(200, random)
+	index := BuildBVH(triangles)
+	checkIndex(t, "random", index, triangles, queries)
+
+	// after moving some triangles, refitting finds them where they are now
+	for _, triangle := range triangles[:500] {
+		for _, vertex := range triangle.vertices {
+			vertex.y += 10
+		}
+	}
+	index.Refit()
+	checkIndex(t, "refitted", index, triangles, queries)
+
+	_, sector := loadHallway(t)
+	var hallway []indexQuery
+	for _, eye := range testEyes {
+		for _, dir := range testDirections(50) {
+			hallway = append(hallway, indexQuery{p: eye, dir: dir, radius: 0.5})
+		}
+	}
+	checkIndex(t, "hallway", sector.Index(), sector.triangles, hallway)
+}
+
+// Run query on a random world of 100000 triangles, the same queries for
+// the BVH and looking at every triangle
+func benchmarkIndex(b *testing.B, query func(index *BVH, triangles []*Triangle, q indexQuery)) {
+	random := rand.New(rand.NewSource(1))
+	triangles := randomWorld(100000, random).sectors[0].triangles
+	queries := randomQueries(64, random)
+	index := BuildBVH(triangles)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		query(index, triangles, queries[i%len(queries)])
+	}
+}
+
+func BenchmarkIndexBuild(b *testing.B) {
+	triangles := randomWorld(100000, rand.New(rand.NewSource(1))).sectors[0].triangles
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		BuildBVH(triangles)
+	}
+}
+
+func BenchmarkIndexRaycast(b *testing.B) {
+	benchmarkIndex(b, func(index *BVH, triangles []*Triangle, q indexQuery) { index.Raycast(q.p, q.dir, math.Inf(1)) })
+}
+
+func BenchmarkIndexRaycastBrute(b *testing.B) {
+	benchmarkIndex(b, func(index *BVH, triangles []*Triangle, q indexQuery) { bruteRaycast(triangles, q.p, q.dir) })
+}
+
+func BenchmarkIndexOverlap(b *testing.B) {
+	benchmarkIndex(b, func(index *BVH, triangles []*Triangle, q indexQuery) { index.Overlap(q.p, q.radius) })
+}
+
+func BenchmarkIndexOverlapBrute(b *testing.B) {
+	benchmarkIndex(b, func(index *BVH, triangles []*Triangle, q indexQuery) { bruteOverlap(triangles, q.p, q.radius) })
+}
+
+func BenchmarkIndexNearest(b *testing.B) {
+	benchmarkIndex(b, func(index *BVH, triangles []*Triangle, q indexQuery) { index.Nearest(q.p, math.Inf(1)) })
+}
+
+func BenchmarkIndexNearestBrute(b *testing.B) {
+	benchmarkIndex(b, func(index *BVH, triangles []*Triangle, q indexQuery) { bruteNearest(triangles, q.p) })
+}
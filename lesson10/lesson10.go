@@ -1,14 +1,14 @@
 package main
 
 import (
-	"bytes"
+	"flag"
 	"fmt"
 	"github.com/banthar/Go-SDL/sdl"
 	"github.com/banthar/gl"
-	"io/ioutil"
+	"image"
 	"math"
 	"os"
-	"strconv"
+	"time"
 )
 
 const (
@@ -18,61 +18,166 @@ const (
 
 	// used for conversion to radians
 	PiOver100 = 0.0174532925199433
+
+	// our viewing volume
+	FIELD_OF_VIEW = 45.0
+	NEAR_PLANE    = 0.1
+	FAR_PLANE     = 100.0
+
+	// how far above the floor our eyes are
+	EYE_HEIGHT = 0.25
+
+	// how fast we fall and jump, in units per second (squared)
+	GRAVITY    = 3.0
+	JUMP_SPEED = 0.9
+
+	// falling this far below the start puts us back there
+	RESPAWN_DEPTH = 10.0
+
+	// how far we can look up or down, in degrees
+	MAX_PITCH = 89.0
 )
 
 var (
 	surface    *sdl.Surface
 	t0, frames uint32
 
-	sector1                 Sector  // our sector
-	yrot                    float64 // camera rotation
-	xpos, zpos              float64 // camera position
-	walkbias, walkbiasangle float64 // head-bobbing....
-	lookupdown              gl.GLfloat
+	world                   *World  // our world
+	currentSector           *Sector // the sector the camera is in
+	portalCulling           = true  // only draw sectors seen through portals
+	aspect                  float64 // width / height of the window
+	windowWidth             int
+	windowHeight            int
+	yrot                    float64    // camera rotation
+	xpos, ypos, zpos        float64    // position of our feet
+	yspeed                  float64    // how fast we're going up
+	onGround                bool       // standing on a floor
+	spawn                   vec3       // where we start
+	walkbias, walkbiasangle float64    // head-bobbing....
+	lookupdown              gl.GLfloat // camera pitch, positive looks down
+
+	mouseGrabbed     bool    // the mouse turns the camera
+	mouseSensitivity float64 // degrees per pixel of mouse movement
+	invertMouse      bool    // moving the mouse up looks down
 
 	lightAmbient  = [4]float32{0.5, 0.5, 0.5, 1.0}
 	lightDiffuse  = [4]float32{1.0, 1.0, 1.0, 1.0}
 	lightPosition = [4]float32{0.0, 0.0, 2.0, 1.0}
 
-	filter   gl.GLuint
-	textures [3]gl.Texture
+	filter gl.GLuint
+	light  = false // Light is off at first
+
+	skybox *Skybox // drawn behind the world, nil to clear to black
+
+	// how triangles without a material of their own look
+	defaultMaterial = &Material{
+		name:    "mud",
+		texture: "data/mud.bmp",
+		color:   [4]gl.GLfloat{1.0, 1.0, 1.0, 1.0},
+	}
 )
 
 type Vertex struct {
-	x, y, z gl.GLfloat
-	u, v    gl.GLfloat
+	x, y, z    gl.GLfloat
+	u, v       gl.GLfloat
+	nx, ny, nz gl.GLfloat
+}
+
+type Triangle struct {
+	vertices [3]*Vertex
+	material *Material        // nil for the default look
+	comments []string         // from the world file, written back when saving
+	lightmap [3][2]gl.GLfloat // where the corners are in the lightmap
+	entity   *Entity          // the door, lift or pickup it is part of
 }
 
-type Triangle [3]*Vertex
-type Sector []*Triangle
+// A Sector is a part of the world, like a room, that is drawn as a whole
+type Sector struct {
+	name      string
+	triangles []*Triangle
+	portals   []*Portal // openings to neighbouring sectors
+	comments  []string
+	bsp       *BSPNode // built from the triangles when first needed
+	index     *BVH     // likewise
+	meshes    []*Mesh  // likewise
+}
+
+// A Portal is a convex polygon through which another sector is visible
+type Portal struct {
+	points [][3]gl.GLfloat
+	target *Sector
+}
+
+// A World is made up of sectors connected by portals
+type World struct {
+	sectors  []*Sector
+	lights   []*Light // baked into the lightmap
+	entities []*Entity
+	fog      *Fog     // nil unless the world file has a FOG line
+	comments []string // describing the whole world
+}
+
+// Find a sector by name, nil if there is none
+func (w *World) Sector(name string) *Sector {
+	for _, sector := range w.sectors {
+		if sector.name == name {
+			return sector
+		}
+	}
+	return nil
+}
+
+// All triangles of all sectors
+func (w *World) Triangles() []*Triangle {
+	var triangles []*Triangle
+	for _, sector := range w.sectors {
+		triangles = append(triangles, sector.triangles...)
+	}
+	return triangles
+}
+
+// A Material describes how the surface of a triangle looks
+type Material struct {
+	name    string
+	texture string        // path of the texture image, if any
+	color   [4]gl.GLfloat // diffuse color and opacity
+	blend   int           // one of the BLEND_ modes
+}
 
 func p(a ...interface{}) { fmt.Println(a) }
 
-// load in bitmap as a GL texture
-func LoadGLTextures(path string) {
+// load in bitmap as a GL texture, with three different filters
+func LoadGLTextures(path string) (textures [3]gl.Texture, err error) {
 	// storage space for the textures
-	image, format := LoadImage(path)
+	image, err := ReadImage(path)
+	if err != nil {
+		return textures, err
+	}
 
 	// Create the textures
 	gl.GenTextures(textures[:])
 
-	genTexture(textures[0], image, format)
+	genTexture(textures[0], image)
 	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
 	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
 
-	genTexture(textures[1], image, format)
+	genTexture(textures[1], image)
 	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
 	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
 
-	genTexture(textures[2], image, format)
+	genTexture(textures[2], image)
 	gl.TexParameteri(gl.TEXTURE_2D, gl.GENERATE_MIPMAP, gl.TRUE)
+
+	return textures, nil
 }
 
-func LoadImage(path string) (image *sdl.Surface, format gl.GLenum) {
-	image = sdl.Load(path)
+// load an image of any pixel format SDL knows and convert it for GL
+func ReadImage(path string) (*Image, error) {
+	image := sdl.Load(path)
 	if image == nil {
-		panic(sdl.GetError())
+		return nil, fmt.Errorf("%s: %s", path, sdl.GetError())
 	}
+	defer image.Free()
 
 	// Check that the image's width is a power of 2
 	if image.W&(image.W-1) != 0 {
@@ -84,78 +189,25 @@ func LoadImage(path string) (image *sdl.Surface, format gl.GLenum) {
 		fmt.Println("warning:", path, "has an height that is not a power of 2")
 	}
 
-	// get the number of channels in the SDL surface
-	nOfColors := image.Format.BytesPerPixel
-	if nOfColors == 4 { // contains alpha channel
-		if image.Format.Rmask == 0x000000ff {
-			format = gl.RGBA
-		} else {
-			format = gl.BGRA
-		}
-	} else if nOfColors == 3 { // no alpha channel
-		if image.Format.Rmask == 0x000000ff {
-			format = gl.RGB
-		} else {
-			format = gl.BGR
-		}
-	} else {
-		fmt.Println("warning:", path, "is not truecolor, this will probably break")
-	}
-
-	return image, format
-}
-
-func genTexture(into gl.Texture, from *sdl.Surface, format gl.GLenum) {
-	gl.BindTexture(gl.TEXTURE_2D, uint(into))
-	gl.TexImage2D(gl.TEXTURE_2D, 0, 3, int(from.W), int(from.H),
-		0, format, gl.UNSIGNED_BYTE, from.Pixels,
-	)
-}
+	width, height, pitch := int(image.W), int(image.H), int(image.Pitch)
+	pixels := (*[1 << 30]byte)(image.Pixels)[: pitch*height : pitch*height]
 
-func SetupWorld(path string) {
-	content, err := ioutil.ReadFile(path)
+	converted, err := ConvertPixels(pixels, width, height, pitch, surfaceFormat(image.Format))
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("%s: %v", path, err)
 	}
 
-	triangle := &Triangle{}
-	triangles := []*Triangle{triangle}
-	tindex := 0
-
-	lines := bytes.Split(content, []byte("\n"))
-	for _, line := range lines {
-		fields := bytes.Fields(line)
-
-		if len(fields) == 5 && fields[0][0] != '/' {
-			vertex := &Vertex{
-				x: atof(fields[0]), y: atof(fields[1]), z: atof(fields[2]),
-				u: atof(fields[3]), v: atof(fields[4]),
-			}
-
-			idx := tindex % 3
-			if triangle[idx] == nil {
-				triangle[idx] = vertex
-			} else {
-				triangle = &Triangle{vertex}
-				triangles = append(triangles, triangle)
-			}
-
-			tindex++
-		}
-	}
-
-	sector1 = make(Sector, len(triangles))
-	for idx, tri := range triangles {
-		sector1[idx] = tri
-	}
+	return converted, nil
 }
 
-func atof(s []byte) gl.GLfloat {
-	f, err := strconv.ParseFloat(string(s), 32)
-	if err != nil {
-		panic(err)
-	}
-	return gl.GLfloat(f)
+func genTexture(into gl.Texture, from *Image) {
+	// rows of RGB images aren't always a multiple of 4 bytes long
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 1)
+
+	gl.BindTexture(gl.TEXTURE_2D, uint(into))
+	gl.TexImage2D(gl.TEXTURE_2D, 0, 4, from.width, from.height,
+		0, from.format, gl.UNSIGNED_BYTE, from.pixels,
+	)
 }
 
 // release/destroy our resources and restoring the old desktop
@@ -181,19 +233,20 @@ func resizeWindow(width, height int) {
 	gl.MatrixMode(gl.PROJECTION)
 	gl.LoadIdentity()
 
-	// aspect ratio
-	aspect := gl.GLdouble(gl.GLfloat(width) / gl.GLfloat(height))
+	// aspect ratio, kept for working out what is visible through portals
+	aspect = float64(width) / float64(height)
+	windowWidth, windowHeight = width, height
 
 	// Set our perspective.
 	// This code is equivalent to using gluPerspective as in the original tutorial.
 	var fov, near, far gl.GLdouble
-	fov = 45.0
-	near = 0.1
-	far = 100.0
+	fov = FIELD_OF_VIEW
+	near = NEAR_PLANE
+	far = FAR_PLANE
 	top := gl.GLdouble(math.Tan(float64(fov*math.Pi/360.0))) * near
 	bottom := -top
-	left := aspect * bottom
-	right := aspect * top
+	left := gl.GLdouble(aspect) * bottom
+	right := gl.GLdouble(aspect) * top
 	gl.Frustum(float64(left), float64(right), float64(bottom), float64(top), float64(near), float64(far))
 
 	// Make sure we're changing the model view and not the projection
@@ -206,6 +259,16 @@ func resizeWindow(width, height int) {
 // handle key press events
 func handleKeyPress(keysym sdl.Keysym) {
 	keys := sdl.GetKeyState()
+	from := eyePosition()
+
+	// the editor has first pick
+	if editor.active && editor.handleKey(keysym) {
+		return
+	}
+
+	if keys[sdl.K_e] == 1 {
+		editor.toggle()
+	}
 
 	if keys[sdl.K_ESCAPE] == 1 {
 		Quit(0)
@@ -219,6 +282,90 @@ func handleKeyPress(keysym sdl.Keysym) {
 		filter = (filter + 1) % 3
 	}
 
+	if keys[sdl.K_p] == 1 {
+		portalCulling = !portalCulling
+	}
+
+	if keys[sdl.K_m] == 1 {
+		minimap.visible = !minimap.visible
+	}
+
+	if keys[sdl.K_r] == 1 {
+		minimap.rotate = !minimap.rotate
+	}
+
+	if keys[sdl.K_MINUS] == 1 {
+		minimap.zoom(1.25)
+	}
+
+	if keys[sdl.K_EQUALS] == 1 {
+		minimap.zoom(0.8)
+	}
+
+	if keys[sdl.K_l] == 1 {
+		light = !light
+		if light {
+			p("light on")
+			gl.Enable(gl.LIGHTING)
+		} else {
+			p("light off")
+			gl.Disable(gl.LIGHTING)
+		}
+	}
+
+	if keys[sdl.K_b] == 1 && lightmapTexture != 0 {
+		lightmapOn = !lightmapOn
+		if lightmapOn {
+			p("lightmap on")
+		} else {
+			p("lightmap off")
+		}
+	}
+
+	if keys[sdl.K_v] == 1 {
+		vertexBuffers = !vertexBuffers
+		if vertexBuffers {
+			p("vertex buffers on")
+		} else {
+			p("vertex buffers off")
+		}
+	}
+
+	if keys[sdl.K_o] == 1 {
+		fog.cycle()
+		p("fog", fogNames[fog.mode])
+	}
+
+	if keys[sdl.K_LEFTBRACKET] == 1 {
+		fog.thicken(0.8)
+	}
+
+	if keys[sdl.K_RIGHTBRACKET] == 1 {
+		fog.thicken(1.25)
+	}
+
+	if keys[sdl.K_g] == 1 {
+		grabMouse(!mouseGrabbed)
+	}
+
+	if keys[sdl.K_u] == 1 {
+		camera := playerCamera()
+		world.Use(camera.pos, camera.ray(0, 0))
+	}
+
+	if keys[sdl.K_PAGEUP] == 1 {
+		look(0, -1.0)
+	}
+
+	if keys[sdl.K_PAGEDOWN] == 1 {
+		look(0, 1.0)
+	}
+
+	if keys[sdl.K_SPACE] == 1 && onGround {
+		yspeed = JUMP_SPEED
+		onGround = false
+	}
+
 	if keys[sdl.K_RIGHT] == 1 {
 		yrot -= 1.5
 	}
@@ -227,9 +374,12 @@ func handleKeyPress(keysym sdl.Keysym) {
 		yrot += 1.5
 	}
 
+	// where we want to go, walls permitting
+	var move vec3
+
 	if keys[sdl.K_UP] == 1 {
-		xpos -= math.Sin(yrot*PiOver100) * 0.05
-		zpos -= math.Cos(yrot*PiOver100) * 0.05
+		move[0] -= math.Sin(yrot*PiOver100) * 0.05
+		move[2] -= math.Cos(yrot*PiOver100) * 0.05
 		if walkbiasangle >= 359.0 {
 			walkbiasangle = 0.0
 		} else {
@@ -239,8 +389,8 @@ func handleKeyPress(keysym sdl.Keysym) {
 	}
 
 	if keys[sdl.K_DOWN] == 1 {
-		xpos += math.Sin(yrot*PiOver100) * 0.05
-		zpos += math.Cos(yrot*PiOver100) * 0.05
+		move[0] += math.Sin(yrot*PiOver100) * 0.05
+		move[2] += math.Cos(yrot*PiOver100) * 0.05
 		if walkbiasangle <= 1.0 {
 			walkbiasangle = 359.0
 		} else {
@@ -248,12 +398,101 @@ func handleKeyPress(keysym sdl.Keysym) {
 		}
 		walkbias = math.Sin(walkbiasangle*PiOver100) / 20.0
 	}
+
+	// slide along the walls we run into
+	feet := world.Slide(currentSector, player, vec3{xpos, ypos, zpos}, move)
+	xpos, zpos = feet[0], feet[2]
+
+	// walking through a portal takes us into the sector behind it
+	currentSector = world.Move(currentSector, from, eyePosition())
+}
+
+// handle mouse movement, while the mouse is ours
+func handleMouseMotion(e *sdl.MouseMotionEvent) {
+	if !mouseGrabbed {
+		return
+	}
+
+	pitch := float64(e.Yrel) * mouseSensitivity
+	if invertMouse {
+		pitch = -pitch
+	}
+	look(-float64(e.Xrel)*mouseSensitivity, pitch)
+}
+
+// turn the camera by the given degrees, without looking over our head
+func look(yaw, pitch float64) {
+	yrot += yaw
+	lookupdown = gl.GLfloat(math.Max(-MAX_PITCH, math.Min(MAX_PITCH, float64(lookupdown)+pitch)))
+}
+
+// keep the mouse in the window and hide it, so it can turn the camera
+func grabMouse(grab bool) {
+	mouseGrabbed = grab
+	if grab {
+		sdl.WM_GrabInput(sdl.GRAB_ON)
+		sdl.ShowCursor(0)
+	} else {
+		sdl.WM_GrabInput(sdl.GRAB_OFF)
+		sdl.ShowCursor(1)
+	}
+}
+
+// the camera we see the world through
+func playerCamera() *Camera {
+	return &Camera{
+		pos: eyePosition(), yaw: yrot, pitch: float64(lookupdown),
+		fov: FIELD_OF_VIEW, aspect: aspect, near: NEAR_PLANE,
+	}
+}
+
+// where the camera is in the world
+func eyePosition() vec3 {
+	return vec3{xpos, ypos + walkbias + EYE_HEIGHT, zpos}
+}
+
+// let gravity and the floor under our feet decide how high we are
+func updatePlayer(seconds float64) {
+	from := eyePosition()
+	feet := world.UpdateEntities(seconds, player, vec3{xpos, ypos, zpos}, onGround)
+	xpos, ypos, zpos = feet[0], feet[1], feet[2]
+
+	// floors up to a step above our feet are stepped onto
+	knees := vec3{xpos, ypos + player.step, zpos}
+	floor, onFloor := world.FloorBelow(currentSector, knees)
+
+	if onGround && onFloor && floor >= ypos-player.step {
+		// follow the floor up steps and down slopes
+		ypos, yspeed = floor, 0
+	} else {
+		onGround = false
+		yspeed -= GRAVITY * seconds
+		ypos += yspeed * seconds
+
+		if onFloor && ypos <= floor {
+			ypos, yspeed, onGround = floor, 0, true
+		}
+	}
+
+	// bump our head
+	if ceiling, ok := world.CeilingAbove(currentSector, knees); ok && ypos+player.height > ceiling {
+		ypos = math.Min(ypos, ceiling-player.height)
+		yspeed = math.Min(yspeed, 0)
+	}
+
+	// fell out of the world
+	if ypos < spawn[1]-RESPAWN_DEPTH {
+		xpos, ypos, zpos = spawn[0], spawn[1], spawn[2]
+		yspeed, onGround = 0, false
+		currentSector = world.Locate(eyePosition())
+		return
+	}
+
+	currentSector = world.Move(currentSector, from, eyePosition())
 }
 
 // general OpenGL initialization
 func initGL() {
-	LoadGLTextures("data/mud.bmp")
-
 	gl.Enable(gl.TEXTURE_2D)
 	gl.ShadeModel(gl.SMOOTH)
 	gl.ClearColor(0.0, 0.0, 0.0, 0.0)
@@ -267,18 +506,25 @@ func initGL() {
 	gl.Lightfv(gl.LIGHT1, gl.POSITION, lightPosition[:])
 	gl.Enable(gl.LIGHT1)
 
+	// light both sides of the triangles, as world files don't agree on
+	// which side is the front, and keep the colors of the materials
+	gl.LightModeli(gl.LIGHT_MODEL_TWO_SIDE, gl.TRUE)
+	gl.ColorMaterial(gl.FRONT_AND_BACK, gl.AMBIENT_AND_DIFFUSE)
+	gl.Enable(gl.COLOR_MATERIAL)
+
 	gl.Color4f(1.0, 1.0, 1.0, 0.5)
 	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE)
 }
 
 // Here goes our drawing code
-func drawGLScene(sector Sector) {
+func drawGLScene(world *World) {
 	xtrans := gl.GLfloat(-xpos)
 	ztrans := gl.GLfloat(-zpos)
-	ytrans := gl.GLfloat(-walkbias - 0.25)
+	ytrans := gl.GLfloat(-ypos - walkbias - EYE_HEIGHT)
 	scenroty := gl.GLfloat(360.0 - yrot)
 
-	// Clear the screen and depth buffer
+	// Clear the screen and depth buffer, to the color of the fog if any
+	fog.clearColor()
 	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
 
 	// reset the view
@@ -288,21 +534,27 @@ func drawGLScene(sector Sector) {
 	gl.Rotatef(float32(lookupdown), 1.0, 0.0, 0.0)
 	// Rotate depending on direction player is facing
 	gl.Rotatef(float32(scenroty), 0.0, 1.0, 0.0)
+
+	// the sky only turns with us, it is too far away to get closer to
+	if skybox != nil {
+		skybox.draw()
+	}
+
 	// translate the scene based on player position
 	gl.Translatef(float32(xtrans), float32(ytrans), float32(ztrans))
 
-	gl.BindTexture(gl.TEXTURE_2D, uint(textures[filter]))
-
-	for _, vertices := range sector {
-		gl.Begin(gl.TRIANGLES)
-		for _, triangle := range *vertices {
-			gl.Normal3f(0.0, 0.0, 1.0)
-			gl.TexCoord2f(float32(triangle.u), float32(triangle.v))
-			gl.Vertex3f(float32(triangle.x), float32(triangle.y), float32(triangle.z))
-		}
-		gl.End()
+	// only draw the sectors that can be seen from the one we are in
+	sectors := world.sectors
+	if portalCulling {
+		sectors = world.VisibleSectors(currentSector, playerCamera())
 	}
 
+	fog.enable()
+	drawSectors(sectors, world.EntitiesIn(sectors))
+	fog.disable()
+	editor.draw()
+	minimap.draw(world)
+
 	// Draw to the screen
 	sdl.GL_SwapBuffers()
 
@@ -319,6 +571,44 @@ func drawGLScene(sector Sector) {
 }
 
 func main() {
+	worldPath := flag.String("world", "data/world.txt", "world to walk through, in world.txt or OBJ format")
+	exportPath := flag.String("export", "", "write the world to this .obj, .glb or .wld file and exit")
+	flag.Float64Var(&mouseSensitivity, "sensitivity", 0.2, "degrees the camera turns per pixel of mouse movement")
+	flag.BoolVar(&invertMouse, "invert", false, "invert looking up and down with the mouse")
+	flag.Float64Var(&creaseAngle, "crease", DEFAULT_CREASE_ANGLE, "edges sharper than this many degrees aren't smoothed when lit")
+	bakePath := flag.String("bake", "", "bake the lightmap of the world into this PNG file and exit")
+	bounce := flag.Bool("bounce", false, "add light bounced off other triangles to the lightmap")
+	skyboxPath := flag.String("skybox", "", "draw this skybox behind the world, one image of the faces as a cross or six with * for their names")
+	flag.Parse()
+
+	if *bakePath != "" {
+		world, err := LoadWorld(*worldPath)
+		var lightmap *image.RGBA
+		if err == nil {
+			lightmap, err = BakeLightmap(world, LIGHTMAP_SIZE, *bounce)
+		}
+		if err == nil {
+			err = writePNG(*bakePath, lightmap)
+		}
+		if err != nil {
+			fmt.Println("Could not bake the lightmap:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *exportPath != "" {
+		world, err := LoadWorld(*worldPath)
+		if err == nil {
+			err = ExportWorld(*exportPath, world)
+		}
+		if err != nil {
+			fmt.Println("Could not export the world:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if sdl.Init(sdl.INIT_VIDEO) < 0 {
 		panic("Video initialization failed: " + sdl.GetError())
 	}
@@ -339,15 +629,54 @@ func main() {
 		panic("Video mode set failed: " + sdl.GetError())
 	}
 
+	// multitexturing for the lightmap and buffer objects for meshes are
+	// newer than OpenGL 1.1, their functions have to be looked up
+	if gl.Init() != 0 {
+		panic("Loading OpenGL functions failed")
+	}
+
 	sdl.GL_SetAttribute(sdl.GL_DOUBLEBUFFER, 1)
 	initGL()
 	resizeWindow(SCREEN_WIDTH, SCREEN_HEIGHT)
 
-	SetupWorld("data/world.txt")
+	var err error
+	if world, err = LoadWorld(*worldPath); err != nil {
+		fmt.Println("Could not load the world:", err)
+		Quit(1)
+	}
+	if err = LoadMaterialTextures(world); err != nil {
+		fmt.Println("Could not load the textures:", err)
+		Quit(1)
+	}
+	if *skyboxPath != "" {
+		if skybox, err = LoadSkybox(*skyboxPath); err != nil {
+			fmt.Println("Could not load the skybox:", err)
+			Quit(1)
+		}
+	}
+	if len(world.lights) > 0 {
+		start := time.Now()
+		lightmap, err := BakeLightmap(world, LIGHTMAP_SIZE, *bounce)
+		if err != nil {
+			fmt.Println("Could not bake the lightmap:", err)
+			Quit(1)
+		}
+		loadLightmapTexture(lightmap)
+		fmt.Println("Lightmap baked in", time.Since(start))
+	}
+	world.BuildIndex()
+	if world.fog != nil {
+		fog = *world.fog
+	}
+	editor.path = editorPath(*worldPath)
+	spawn = vec3{xpos, ypos, zpos}
+	currentSector = world.Locate(eyePosition())
+	grabMouse(true)
 
 	// wait for events
 	running := true
 	isActive := true
+	ticks := sdl.GetTicks()
 	for running {
 		for ev := sdl.PollEvent(); ev != nil; ev = sdl.PollEvent() {
 			switch e := ev.(type) {
@@ -365,14 +694,26 @@ func main() {
 				if e.Type == sdl.KEYDOWN {
 					handleKeyPress(e.Keysym)
 				}
+			case *sdl.MouseMotionEvent:
+				handleMouseMotion(e)
+			case *sdl.MouseButtonEvent:
+				if e.Type == sdl.MOUSEBUTTONDOWN && e.Button == sdl.BUTTON_LEFT && editor.active {
+					editor.click(int(e.X), int(e.Y))
+				}
 			case *sdl.QuitEvent:
 				running = false
 			}
 		}
 
+		// move on, at most a tenth of a second at a time
+		now := sdl.GetTicks()
+		seconds := math.Min(float64(now-ticks)/1000.0, 0.1)
+		ticks = now
+
 		// draw the scene
 		if isActive {
-			drawGLScene(sector1)
+			updatePlayer(seconds)
+			drawGLScene(world)
 		}
 	}
 }
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"fmt"
+	"github.com/banthar/gl"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+)
+
+const (
+	// width and height the lightmap atlas starts out with, in texels
+	LIGHTMAP_SIZE = 256
+
+	// the largest the atlas grows to when the triangles don't fit
+	LIGHTMAP_MAX_SIZE = 4096
+
+	// how many texels a world unit starts out with, halved until all
+	// triangles fit into the atlas
+	LIGHTMAP_DENSITY = 32
+
+	// below this the atlas grows instead
+	LIGHTMAP_MIN_DENSITY = 1
+
+	// light reaching every surface, so shadows aren't completely black
+	LIGHTMAP_AMBIENT = 0.15
+
+	// how far a light reaches when the world file doesn't say
+	DEFAULT_LIGHT_RADIUS = 5
+
+	// rays shot from every texel to gather the light bouncing off others
+	LIGHTMAP_BOUNCE_RAYS = 32
+
+	// how far shadow and bounce rays start off the surface
+	LIGHTMAP_BIAS = 1e-3
+)
+
+// A Light is a point light declared in the world file, used to bake
+// lightmaps. It fades out until it reaches nothing at radius.
+type Light struct {
+	pos      vec3
+	color    vec3
+	radius   float64
+	comments []string
+}
+
+// the lightmap texture, and whether it is drawn
+var (
+	lightmapTexture gl.Texture
+	lightmapOn      bool
+)
+
+// A chart is where a triangle lies in the lightmap atlas. The triangle is
+// laid flat along the axes e1 and e2 from its first corner, and placed
+// with a texel of padding around it so filtering doesn't bleed.
+type chart struct {
+	triangle      *Triangle
+	origin        vec3
+	e1, e2        vec3
+	x, y          int // of the top left texel in the atlas
+	width, height int
+	min           [2]float64 // of the flattened corners, in texels
+}
+
+// The texel of the chart a point on the triangle falls into
+func (c *chart) texel(p vec3, density float64) (int, int) {
+	d := p.sub(c.origin)
+	x := c.x + 1 + int(math.Floor(d.dot(c.e1)*density-c.min[0]))
+	y := c.y + 1 + int(math.Floor(d.dot(c.e2)*density-c.min[1]))
+	return clampInt(x, c.x, c.x+c.width-1), clampInt(y, c.y, c.y+c.height-1)
+}
+
+func clampInt(i, min, max int) int {
+	if i < min {
+		return min
+	}
+	if i > max {
+		return max
+	}
+	return i
+}
+
+// The point of the triangle closest to the center of a texel of the chart,
+// and whether the texel is used: texels further than one from the
+// triangle are never drawn, even with filtering
+func (c *chart) point(x, y int, density float64) (vec3, bool) {
+	u := (float64(x-c.x-1) + 0.5 + c.min[0]) / density
+	v := (float64(y-c.y-1) + 0.5 + c.min[1]) / density
+	p := c.origin.add(c.e1.scale(u)).add(c.e2.scale(v))
+	closest := closestPointTriangle(p, c.triangle.corners())
+	return closest, closest.sub(p).length()*density < 1.5
+}
+
+// Unwrap the triangles of the world into a lightmap atlas, giving every
+// triangle a place of its own and setting its lightmap coordinates.
+// Returns the charts, how many texels there are per world unit, and the
+// size of the atlas, which is doubled from size when that is too small.
+func UnwrapLightmap(world *World, size int) ([]*chart, float64, int, error) {
+	var charts []*chart
+	for _, triangle := range world.Triangles() {
+		triangle.lightmap = [3][2]gl.GLfloat{}
+		normal := triangle.faceNormal()
+		if normal == (vec3{}) {
+			continue
+		}
+		corners := triangle.corners()
+		e1 := corners[1].sub(corners[0]).normalize()
+		charts = append(charts, &chart{
+			triangle: triangle,
+			origin:   corners[0],
+			e1:       e1,
+			e2:       normal.cross(e1),
+		})
+	}
+
+	density := float64(LIGHTMAP_DENSITY)
+	for !packCharts(charts, size, density) {
+		if density /= 2; density < LIGHTMAP_MIN_DENSITY {
+			if size *= 2; size > LIGHTMAP_MAX_SIZE {
+				return nil, 0, 0, fmt.Errorf("%d triangles don't fit into a lightmap of %dx%d texels", len(charts), LIGHTMAP_MAX_SIZE, LIGHTMAP_MAX_SIZE)
+			}
+			density = LIGHTMAP_DENSITY
+		}
+	}
+
+	for _, c := range charts {
+		for i, corner := range c.triangle.corners() {
+			x := float64(c.x+1) + corner.sub(c.origin).dot(c.e1)*density - c.min[0]
+			y := float64(c.y+1) + corner.sub(c.origin).dot(c.e2)*density - c.min[1]
+			c.triangle.lightmap[i] = [2]gl.GLfloat{gl.GLfloat(x / float64(size)), gl.GLfloat(y / float64(size))}
+		}
+	}
+
+	return charts, density, size, nil
+}
+
+// Place the charts in rows across the atlas, returning false if they don't
+// all fit
+func packCharts(charts []*chart, size int, density float64) bool {
+	for _, c := range charts {
+		c.min = [2]float64{math.Inf(1), math.Inf(1)}
+		var max [2]float64
+		for _, corner := range c.triangle.corners() {
+			d := corner.sub(c.origin)
+			u, v := d.dot(c.e1)*density, d.dot(c.e2)*density
+			c.min = [2]float64{math.Min(c.min[0], u), math.Min(c.min[1], v)}
+			max = [2]float64{math.Max(max[0], u), math.Max(max[1], v)}
+		}
+		c.width = int(math.Ceil(max[0]-c.min[0])) + 2
+		c.height = int(math.Ceil(max[1]-c.min[1])) + 2
+	}
+
+	// the tallest charts go first, so the rows of the atlas are filled well
+	sort.SliceStable(charts, func(i, j int) bool { return charts[i].height > charts[j].height })
+
+	x, y, rowHeight := 0, 0, 0
+	for _, c := range charts {
+		if x+c.width > size {
+			x, y, rowHeight = 0, y+rowHeight, 0
+		}
+		if c.width > size || y+c.height > size {
+			return false
+		}
+		c.x, c.y = x, y
+		x += c.width
+		if c.height > rowHeight {
+			rowHeight = c.height
+		}
+	}
+	return true
+}
+
+// Bake the light of the world's lights into a lightmap atlas of size by
+// size texels, or larger if the triangles don't fit, with shadows cast by
+// all triangles. With bounce set, light reflected once off other
+// triangles is added too.
+//
+// This is slow, it is meant to be run once when a world is loaded or
+// with -bake, not every frame.
+func BakeLightmap(world *World, size int, bounce bool) (*image.RGBA, error) {
+	charts, density, size, err := UnwrapLightmap(world, size)
+	if err != nil {
+		return nil, err
+	}
+	texels := make([]vec3, size*size)
+
+	for _, c := range charts {
+		for y := c.y; y < c.y+c.height; y++ {
+			for x := c.x; x < c.x+c.width; x++ {
+				if p, used := c.point(x, y, density); used {
+					texels[y*size+x] = directLight(world, p, surfaceNormal(c.triangle, p))
+				}
+			}
+		}
+	}
+
+	if bounce {
+		byTriangle := map[*Triangle]*chart{}
+		for _, c := range charts {
+			byTriangle[c.triangle] = c
+		}
+
+		// the same rays every time, so baking twice gives the same lightmap
+		random := rand.New(rand.NewSource(1))
+		bounced := make([]vec3, len(texels))
+		for _, c := range charts {
+			for y := c.y; y < c.y+c.height; y++ {
+				for x := c.x; x < c.x+c.width; x++ {
+					p, used := c.point(x, y, density)
+					if !used {
+						continue
+					}
+					bounced[y*size+x] = bouncedLight(world, p, surfaceNormal(c.triangle, p), random, func(hit *Triangle, at vec3) vec3 {
+						hc := byTriangle[hit]
+						if hc == nil {
+							return vec3{}
+						}
+						hx, hy := hc.texel(at, density)
+						return texels[hy*size+hx]
+					})
+				}
+			}
+		}
+		for i := range texels {
+			texels[i] = texels[i].add(bounced[i])
+		}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for i, light := range texels {
+		channel := func(f float64) uint8 { return uint8(math.Min(1, f)*255 + 0.5) }
+		img.Set(i%size, i/size, color.RGBA{channel(light[0]), channel(light[1]), channel(light[2]), 255})
+	}
+	return img, nil
+}
+
+// The normal of a triangle at p, smoothed like the lighting of GL does it
+func surfaceNormal(triangle *Triangle, p vec3) vec3 {
+	corners := triangle.corners()
+	w := barycentric(p, corners)
+	var n vec3
+	for i, vertex := range triangle.vertices {
+		n = n.add(vec3{float64(vertex.nx), float64(vertex.ny), float64(vertex.nz)}.scale(w[i]))
+	}
+	if n.length() < 1e-9 {
+		return triangle.faceNormal()
+	}
+	return n.normalize()
+}
+
+// The weights of the corners of a triangle that make up p
+func barycentric(p vec3, tri [3]vec3) [3]float64 {
+	e1, e2, d := tri[1].sub(tri[0]), tri[2].sub(tri[0]), p.sub(tri[0])
+	d11, d12, d22 := e1.dot(e1), e1.dot(e2), e2.dot(e2)
+	d1, d2 := d.dot(e1), d.dot(e2)
+	denom := d11*d22 - d12*d12
+	if denom == 0 {
+		return [3]float64{1, 0, 0}
+	}
+	v := (d22*d1 - d12*d2) / denom
+	w := (d11*d2 - d12*d1) / denom
+	return [3]float64{1 - v - w, v, w}
+}
+
+// The light arriving straight from the lights at p. Like GL with two sided
+// lighting, surfaces are lit from whichever side the light is on.
+func directLight(world *World, p, normal vec3) vec3 {
+	total := vec3{LIGHTMAP_AMBIENT, LIGHTMAP_AMBIENT, LIGHTMAP_AMBIENT}
+	for _, light := range world.lights {
+		toLight := light.pos.sub(p)
+		distance := toLight.length()
+		if distance >= light.radius || distance == 0 {
+			continue
+		}
+		dir := toLight.scale(1 / distance)
+		facing := normal.dot(dir)
+
+		// start off the side of the surface the light is on
+		side := normal.scale(math.Copysign(LIGHTMAP_BIAS, facing))
+		if world.occluded(p.add(side), dir, distance-LIGHTMAP_BIAS) {
+			continue
+		}
+
+		falloff := 1 - distance/light.radius
+		total = total.add(light.color.scale(math.Abs(facing) * falloff * falloff))
+	}
+	return total
+}
+
+// The light reaching p off other triangles, gathered with random rays.
+// Either side of the surface may be the one that is seen, so the brighter
+// side is used.
+func bouncedLight(world *World, p, normal vec3, random *rand.Rand, lightAt func(*Triangle, vec3) vec3) vec3 {
+	var sides [2]vec3
+	for s, side := range []float64{1, -1} {
+		n := normal.scale(side)
+		origin := p.add(n.scale(LIGHTMAP_BIAS))
+		for i := 0; i < LIGHTMAP_BOUNCE_RAYS; i++ {
+			dir := cosineDirection(n, random)
+			_, hit, distance := world.Pick(origin, dir)
+			if hit == nil {
+				continue
+			}
+			albedo := triangleMaterial(hit).color
+			light := lightAt(hit, origin.add(dir.scale(distance)))
+			sides[s] = sides[s].add(vec3{
+				light[0] * float64(albedo[0]),
+				light[1] * float64(albedo[1]),
+				light[2] * float64(albedo[2]),
+			})
+		}
+		sides[s] = sides[s].scale(1.0 / LIGHTMAP_BOUNCE_RAYS)
+	}
+
+	if sides[1].length() > sides[0].length() {
+		return sides[1]
+	}
+	return sides[0]
+}
+
+// A random direction around normal, more likely the closer it is to it
+func cosineDirection(normal vec3, random *rand.Rand) vec3 {
+	a := vec3{1, 0, 0}
+	if math.Abs(normal[0]) > 0.9 {
+		a = vec3{0, 1, 0}
+	}
+	t := normal.cross(a).normalize()
+	b := normal.cross(t)
+
+	r, phi := math.Sqrt(random.Float64()), 2*math.Pi*random.Float64()
+	up := math.Sqrt(1 - r*r)
+	return t.scale(r * math.Cos(phi)).add(b.scale(r * math.Sin(phi))).add(normal.scale(up))
+}
+
+// Whether any triangle is in the way from origin along dir up to distance
+func (w *World) occluded(origin, dir vec3, distance float64) bool {
+	for _, sector := range w.sectors {
+		if hit, _ := sector.Index().Raycast(origin, dir, distance); hit != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Save a baked lightmap, to look at it
+func writePNG(path string, img image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Upload a baked lightmap as texture
+func loadLightmapTexture(img *image.RGBA) {
+	if lightmapTexture == 0 {
+		textures := make([]gl.Texture, 1)
+		gl.GenTextures(textures)
+		lightmapTexture = textures[0]
+	}
+
+	bounds := img.Bounds()
+	genTexture(lightmapTexture, &Image{width: bounds.Dx(), height: bounds.Dy(), format: gl.RGBA, pixels: img.Pix})
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	lightmapOn = true
+}
+
+// Turn the lightmap on or off on the second texture unit, where it
+// modulates the texture of the material
+func enableLightmap(on bool) {
+	gl.ActiveTexture(gl.TEXTURE1)
+	if on {
+		gl.Enable(gl.TEXTURE_2D)
+		gl.BindTexture(gl.TEXTURE_2D, uint(lightmapTexture))
+		gl.TexEnvi(gl.TEXTURE_ENV, gl.TEXTURE_ENV_MODE, gl.MODULATE)
+	} else {
+		gl.Disable(gl.TEXTURE_2D)
+	}
+	gl.ActiveTexture(gl.TEXTURE0)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// Every triangle gets a chart of its own inside the atlas, and its
+// lightmap coordinates inside that chart
+func TestUnwrapLightmap(t *testing.T) {
+	worlds := map[string]*World{
+		// more charts than fit into LIGHTMAP_SIZE at any density
+		"random": randomWorld(20000, rand.New(rand.NewSource(1))),
+	}
+	for _, name := range []string{"world.txt", "sectors.txt", "levels.txt"} {
+		world, err := SetupWorld("data/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		worlds[name] = world
+	}
+
+	for name, world := range worlds {
+		charts, density, size, err := UnwrapLightmap(world, LIGHTMAP_SIZE)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if len(charts) != len(world.Triangles()) {
+			t.Errorf("%s: got %d charts for %d triangles", name, len(charts), len(world.Triangles()))
+		}
+		if density < LIGHTMAP_MIN_DENSITY || size < LIGHTMAP_SIZE || size > LIGHTMAP_MAX_SIZE {
+			t.Errorf("%s: got a %d texel atlas with %v texels per unit", name, size, density)
+		}
+
+		used := make([]bool, size*size)
+		for i, c := range charts {
+			if c.x < 0 || c.y < 0 || c.x+c.width > size || c.y+c.height > size {
+				t.Fatalf("%s: chart %d at %d,%d of %dx%d is outside the atlas", name, i, c.x, c.y, c.width, c.height)
+			}
+			for y := c.y; y < c.y+c.height; y++ {
+				for x := c.x; x < c.x+c.width; x++ {
+					if used[y*size+x] {
+						t.Fatalf("%s: chart %d overlaps another at %d,%d", name, i, x, y)
+					}
+					used[y*size+x] = true
+				}
+			}
+
+			for _, uv := range c.triangle.lightmap {
+				x, y := int(float64(uv[0])*float64(size)), int(float64(uv[1])*float64(size))
+				if x < c.x || x > c.x+c.width || y < c.y || y > c.y+c.height {
+					t.Fatalf("%s: chart %d has a corner at %d,%d outside it", name, i, x, y)
+				}
+			}
+		}
+
+		if name == "random" && size == LIGHTMAP_SIZE {
+			t.Errorf("%s: the atlas didn't grow", name)
+		}
+	}
+}
+
+// A floor from -2.5 to 2.5 lit by a light a unit above its middle, reaching
+// radius, and a board halfway up between them, from x 0.5 to 1.5, whose
+// shadow falls on the floor from x 1 to 3
+func shadowWorld(t *testing.T, radius float64) *World {
+	var src strings.Builder
+	fmt.Fprintf(&src, "LIGHT 0 1 0 1 1 1 %g\n", radius)
+	quad := func(a, b, c, d vec3) {
+		for _, v := range []vec3{a, b, c, a, c, d} {
+			fmt.Fprintf(&src, "%g %g %g 0 0\n", v[0], v[1], v[2])
+		}
+	}
+	quad(vec3{-2.5, 0, -2.5}, vec3{-2.5, 0, 2.5}, vec3{2.5, 0, 2.5}, vec3{2.5, 0, -2.5})
+	quad(vec3{0.5, 0.5, -0.5}, vec3{0.5, 0.5, 0.5}, vec3{1.5, 0.5, 0.5}, vec3{1.5, 0.5, -0.5})
+
+	world, err := ParseWorld(strings.NewReader(src.String()), "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return world
+}
+
+// The red of the lightmap where the floor is at x, z
+func floorLight(world *World, img *image.RGBA, x, z float64) uint8 {
+	p := vec3{x, 0, z}
+	for _, triangle := range world.Triangles() {
+		corners := triangle.corners()
+		if y, ok := verticalHit(corners, x, z); !ok || y != 0 {
+			continue
+		}
+		var uv [2]float64
+		for i, w := range barycentric(p, corners) {
+			uv[0] += w * float64(triangle.lightmap[i][0])
+			uv[1] += w * float64(triangle.lightmap[i][1])
+		}
+		size := img.Bounds().Dx()
+		return img.RGBAAt(int(uv[0]*float64(size)), int(uv[1]*float64(size))).R
+	}
+	return 0
+}
+
+// The lightmap value of a light at distance from a point, which it shines
+// on at an angle whose cosine is facing
+func bakedLight(facing, distance, radius float64) uint8 {
+	light := LIGHTMAP_AMBIENT
+	if distance < radius {
+		light += facing * (1 - distance/radius) * (1 - distance/radius)
+	}
+	return uint8(math.Min(1, light)*255 + 0.5)
+}
+
+func TestBakeLightmap(t *testing.T) {
+	world := shadowWorld(t, 2.5)
+	img, err := BakeLightmap(world, LIGHTMAP_SIZE, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ambient := bakedLight(0, 0, 0)
+
+	// the light fades out towards its radius, past which there is only the
+	// ambient light; texels are a little off the points asked about
+	last := uint8(255)
+	for _, x := range []float64{-0.5, -1, -1.5, -2, -2.4} {
+		distance := math.Hypot(x, 1)
+		got, want := floorLight(world, img, x, 0), bakedLight(1/distance, distance, 2.5)
+		if math.Abs(float64(got)-float64(want)) > 4 {
+			t.Errorf("floor at x %v: got light %d, want about %d", x, got, want)
+		}
+		if got > last || got < ambient {
+			t.Errorf("floor at x %v: got light %d after %d, want it fading to %d", x, got, last, ambient)
+		}
+		last = got
+	}
+	if got := floorLight(world, img, -2.4, 0); got != ambient {
+		t.Errorf("floor past the radius: got light %d, want the ambient %d", got, ambient)
+	}
+
+	// the board throws a shadow where the other side is lit
+	for _, p := range [][2]float64{{1.5, 0}, {1.2, 0.3}, {1.8, -0.5}} {
+		lit, shadowed := floorLight(world, img, -p[0], p[1]), floorLight(world, img, p[0], p[1])
+		if shadowed != ambient || lit <= shadowed {
+			t.Errorf("floor at %v: got light %d in the shadow and %d across from it, want %d and more", p, shadowed, lit, ambient)
+		}
+	}
+
+	// a light reaching further is brighter everywhere it reaches
+	far, err := BakeLightmap(shadowWorld(t, 4), LIGHTMAP_SIZE, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, x := range []float64{-0.5, -1.5, -2.4} {
+		if near, far := floorLight(world, img, x, 0), floorLight(world, far, x, 0); far <= near {
+			t.Errorf("floor at x %v: got light %d with radius 4, not more than %d with radius 2.5", x, far, near)
+		}
+	}
+}
+
+// Bounced light is only ever added, and it reaches into the shadow off the
+// underside of the board
+func TestBakeLightmapBounce(t *testing.T) {
+	world := shadowWorld(t, 2.5)
+	direct, err := BakeLightmap(world, LIGHTMAP_SIZE, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bounced, err := BakeLightmap(world, LIGHTMAP_SIZE, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range direct.Pix {
+		if bounced.Pix[i] < direct.Pix[i] {
+			t.Fatalf("byte %d of the lightmap is %d with bounced light, %d without", i, bounced.Pix[i], direct.Pix[i])
+		}
+	}
+	if got, without := floorLight(world, bounced, 1.5, 0), floorLight(world, direct, 1.5, 0); got <= without {
+		t.Errorf("in the shadow: got light %d with bounced light, want more than %d", got, without)
+	}
+}
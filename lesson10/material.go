@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"github.com/banthar/gl"
+	"sort"
+)
+
+// How a material is blended with what is behind it
+const (
+	BLEND_NONE     = iota // opaque
+	BLEND_ALPHA           // see-through by the alpha of color and texture
+	BLEND_ADDITIVE        // adds to what is behind, for glowing things
+)
+
+// the names of the blend modes in world files
+var blendNames = []string{
+	BLEND_NONE:     "none",
+	BLEND_ALPHA:    "alpha",
+	BLEND_ADDITIVE: "additive",
+}
+
+// the textures of each material, with the three filters the f key cycles
+// through, by texture path
+var materialTextures = map[string][3]gl.Texture{}
+
+// Load the textures of all materials in the world. The triangles without
+// a material of their own use defaultMaterial.
+func LoadMaterialTextures(world *World) error {
+	for _, material := range usedMaterials(world.Triangles()) {
+		if material.texture == "" {
+			continue
+		}
+		if _, ok := materialTextures[material.texture]; ok {
+			continue
+		}
+
+		textures, err := LoadGLTextures(material.texture)
+		if err != nil {
+			return fmt.Errorf("material %s: %v", material.name, err)
+		}
+		materialTextures[material.texture] = textures
+	}
+
+	return nil
+}
+
+// Set up GL to draw triangles of a material
+func bindMaterial(material *Material) {
+	if textures, ok := materialTextures[material.texture]; ok {
+		gl.Enable(gl.TEXTURE_2D)
+		gl.BindTexture(gl.TEXTURE_2D, uint(textures[filter]))
+	} else {
+		gl.Disable(gl.TEXTURE_2D)
+	}
+
+	c := material.color
+	gl.Color4f(float32(c[0]), float32(c[1]), float32(c[2]), float32(c[3]))
+
+	switch material.blend {
+	case BLEND_ALPHA:
+		gl.Enable(gl.BLEND)
+		gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
+		gl.DepthMask(false)
+	case BLEND_ADDITIVE:
+		gl.Enable(gl.BLEND)
+		gl.BlendFunc(gl.SRC_ALPHA, gl.ONE)
+		gl.DepthMask(false)
+	default:
+		gl.Disable(gl.BLEND)
+		gl.DepthMask(true)
+	}
+}
+
+// A batch holds the triangles of one material, to be drawn in one go
+type batch struct {
+	material  *Material
+	triangles []*Triangle
+}
+
+// Sort the opaque triangles among some by material, so every material is
+// set up only once
+func batchTriangles(triangles []*Triangle) []*batch {
+	var batches []*batch
+	byMaterial := map[*Material]*batch{}
+
+	for _, triangle := range triangles {
+		material := triangleMaterial(triangle)
+		if material.blend != BLEND_NONE {
+			continue
+		}
+		b, ok := byMaterial[material]
+		if !ok {
+			b = &batch{material: material}
+			byMaterial[material] = b
+			batches = append(batches, b)
+		}
+		b.triangles = append(b.triangles, triangle)
+	}
+
+	return batches
+}
+
+// Draw the triangles of some sectors, sorted as VisibleSectors returns
+// them, and the entities in them. Opaque triangles are drawn a material at
+// a time, from the meshes of the sectors and entities when vertex buffers
+// are on, then blended ones over them from back to front, as the BSP trees
+// of the sectors give them. Blended triangles of entities aren't in the
+// trees, so they come last, sorted far to near.
+// The lightmap only darkens opaque triangles, as the BSP trees cut
+// blended ones into pieces that have no place in it.
+func drawSectors(sectors []*Sector, entities []*Entity) {
+	enableLightmap(lightmapOn)
+	if vertexBuffers {
+		for _, sector := range sectors {
+			for _, mesh := range sector.Meshes() {
+				bindMaterial(mesh.material)
+				mesh.Draw()
+			}
+		}
+		for _, e := range entities {
+			meshes, moved := e.Meshes()
+			gl.PushMatrix()
+			gl.Translatef(float32(moved[0]), float32(moved[1]), float32(moved[2]))
+			for _, mesh := range meshes {
+				bindMaterial(mesh.material)
+				mesh.Draw()
+			}
+			gl.PopMatrix()
+		}
+	} else {
+		// the triangles of a sector include those of its entities
+		var triangles []*Triangle
+		for _, sector := range sectors {
+			triangles = append(triangles, sector.triangles...)
+		}
+		for _, b := range batchTriangles(triangles) {
+			bindMaterial(b.material)
+
+			gl.Begin(gl.TRIANGLES)
+			for _, triangle := range b.triangles {
+				drawTriangle(triangle)
+			}
+			gl.End()
+		}
+	}
+
+	enableLightmap(false)
+
+	eye := eyePosition()
+	var bound *Material
+	drawBlended := func(triangle *Triangle) {
+		material := triangleMaterial(triangle)
+		if material.blend == BLEND_NONE {
+			return
+		}
+		if material != bound {
+			if bound != nil {
+				gl.End()
+			}
+			bindMaterial(material)
+			gl.Begin(gl.TRIANGLES)
+			bound = material
+		}
+		drawTriangle(triangle)
+	}
+
+	// the sectors come near to far, so go through them backwards
+	for i := len(sectors) - 1; i >= 0; i-- {
+		sectors[i].BSP().BackToFront(eye, drawBlended)
+	}
+
+	var blended []*Triangle
+	for _, e := range entities {
+		for _, triangle := range e.triangles {
+			if triangleMaterial(triangle).blend != BLEND_NONE {
+				blended = append(blended, triangle)
+			}
+		}
+	}
+	sort.Slice(blended, func(i, j int) bool {
+		return centroid(blended[i]).sub(eye).length() > centroid(blended[j]).sub(eye).length()
+	})
+	for _, triangle := range blended {
+		drawBlended(triangle)
+	}
+
+	if bound != nil {
+		gl.End()
+	}
+
+	// leave things as we found them
+	gl.Disable(gl.BLEND)
+	gl.DepthMask(true)
+	gl.Enable(gl.TEXTURE_2D)
+}
+
+func drawTriangle(triangle *Triangle) {
+	for i, vertex := range triangle.vertices {
+		gl.Normal3f(float32(vertex.nx), float32(vertex.ny), float32(vertex.nz))
+		gl.TexCoord2f(float32(vertex.u), float32(vertex.v))
+		gl.MultiTexCoord2f(gl.TEXTURE1, float32(triangle.lightmap[i][0]), float32(triangle.lightmap[i][1]))
+		gl.Vertex3f(float32(vertex.x), float32(vertex.y), float32(vertex.z))
+	}
+}
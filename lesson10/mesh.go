@@ -0,0 +1,143 @@
+package main
+
+import (
+	"github.com/banthar/gl"
+	"unsafe"
+)
+
+// whether sectors are drawn from vertex buffer objects instead of a
+// glVertex call per corner; press v to switch and compare the FPS
+var vertexBuffers = true
+
+// A Mesh holds triangles of one material in vertex buffer objects: their
+// vertices interleaved in one buffer, and their corners as indices into it
+// in another, so all of them are drawn with a single DrawElements. Corners
+// that are the same in every respect share a vertex.
+type Mesh struct {
+	material     *Material
+	vertices     []meshVertex
+	indices      []gl.GLuint
+	vertexBuffer gl.Buffer
+	indexBuffer  gl.Buffer
+	uploaded     bool
+}
+
+// A meshVertex is a vertex as it lies in the vertex buffer
+type meshVertex struct {
+	x, y, z    gl.GLfloat
+	nx, ny, nz gl.GLfloat
+	u, v       gl.GLfloat
+	s, t       gl.GLfloat // in the lightmap
+}
+
+// Byte offsets of the parts of a meshVertex, and its size
+var (
+	meshStride   = int(unsafe.Sizeof(meshVertex{}))
+	meshNormal   = unsafe.Offsetof(meshVertex{}.nx)
+	meshUV       = unsafe.Offsetof(meshVertex{}.u)
+	meshLightmap = unsafe.Offsetof(meshVertex{}.s)
+)
+
+// Make a mesh of some triangles, drawn with material. Nothing is uploaded
+// until it is first drawn.
+func NewMesh(material *Material, triangles []*Triangle) *Mesh {
+	m := &Mesh{material: material}
+	shared := map[meshVertex]gl.GLuint{}
+	for _, triangle := range triangles {
+		for i, vertex := range triangle.vertices {
+			mv := meshVertex{
+				vertex.x, vertex.y, vertex.z,
+				vertex.nx, vertex.ny, vertex.nz,
+				vertex.u, vertex.v,
+				triangle.lightmap[i][0], triangle.lightmap[i][1],
+			}
+			index, ok := shared[mv]
+			if !ok {
+				index = gl.GLuint(len(m.vertices))
+				shared[mv] = index
+				m.vertices = append(m.vertices, mv)
+			}
+			m.indices = append(m.indices, index)
+		}
+	}
+	return m
+}
+
+// Put the vertices and indices into buffers on the graphics card
+func (m *Mesh) upload() {
+	m.vertexBuffer = gl.GenBuffer()
+	m.vertexBuffer.Bind(gl.ARRAY_BUFFER)
+	gl.BufferData(gl.ARRAY_BUFFER, len(m.vertices)*meshStride, m.vertices, gl.STATIC_DRAW)
+	m.vertexBuffer.Unbind(gl.ARRAY_BUFFER)
+
+	m.indexBuffer = gl.GenBuffer()
+	m.indexBuffer.Bind(gl.ELEMENT_ARRAY_BUFFER)
+	gl.BufferData(gl.ELEMENT_ARRAY_BUFFER, len(m.indices)*4, m.indices, gl.STATIC_DRAW)
+	m.indexBuffer.Unbind(gl.ELEMENT_ARRAY_BUFFER)
+
+	m.uploaded = true
+}
+
+// Draw the triangles of a mesh, with whatever material is bound
+func (m *Mesh) Draw() {
+	if len(m.indices) == 0 {
+		return
+	}
+	if !m.uploaded {
+		m.upload()
+	}
+
+	m.vertexBuffer.Bind(gl.ARRAY_BUFFER)
+	m.indexBuffer.Bind(gl.ELEMENT_ARRAY_BUFFER)
+
+	// with a buffer bound, the pointers are offsets into it
+	gl.EnableClientState(gl.VERTEX_ARRAY)
+	gl.VertexPointer(3, gl.FLOAT, meshStride, uintptr(0))
+	gl.EnableClientState(gl.NORMAL_ARRAY)
+	gl.NormalPointer(gl.FLOAT, meshStride, meshNormal)
+	gl.EnableClientState(gl.TEXTURE_COORD_ARRAY)
+	gl.TexCoordPointer(2, gl.FLOAT, meshStride, meshUV)
+	gl.ClientActiveTexture(gl.TEXTURE1)
+	gl.EnableClientState(gl.TEXTURE_COORD_ARRAY)
+	gl.TexCoordPointer(2, gl.FLOAT, meshStride, meshLightmap)
+
+	gl.DrawElements(gl.TRIANGLES, len(m.indices), gl.UNSIGNED_INT, uintptr(0))
+
+	gl.DisableClientState(gl.TEXTURE_COORD_ARRAY)
+	gl.ClientActiveTexture(gl.TEXTURE0)
+	gl.DisableClientState(gl.TEXTURE_COORD_ARRAY)
+	gl.DisableClientState(gl.NORMAL_ARRAY)
+	gl.DisableClientState(gl.VERTEX_ARRAY)
+
+	m.indexBuffer.Unbind(gl.ELEMENT_ARRAY_BUFFER)
+	m.vertexBuffer.Unbind(gl.ARRAY_BUFFER)
+}
+
+// Free the buffers of a mesh
+func (m *Mesh) Delete() {
+	if m.uploaded {
+		m.vertexBuffer.Delete()
+		m.indexBuffer.Delete()
+		m.uploaded = false
+	}
+}
+
+// The opaque triangles of a sector as a mesh per material, made when
+// first needed. Blended triangles are left out, as they are drawn back to
+// front from the BSP tree, and so are those of entities.
+func (s *Sector) Meshes() []*Mesh {
+	if s.meshes == nil {
+		for _, b := range batchTriangles(s.staticTriangles()) {
+			s.meshes = append(s.meshes, NewMesh(b.material, b.triangles))
+		}
+	}
+	return s.meshes
+}
+
+// Throw away the meshes of a sector after its triangles changed
+func (s *Sector) dropMeshes() {
+	for _, mesh := range s.meshes {
+		mesh.Delete()
+	}
+	s.meshes = nil
+}
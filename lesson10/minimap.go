@@ -0,0 +1,141 @@
+package main
+
+import (
+	"github.com/banthar/gl"
+	"math"
+)
+
+const (
+	// size of the minimap and its distance from the corner, in pixels
+	MINIMAP_SIZE   = 160
+	MINIMAP_MARGIN = 10
+
+	// how far the minimap sees from us at first, in world units, and how
+	// far it can be zoomed in and out
+	MINIMAP_RANGE     = 4.0
+	MINIMAP_MIN_RANGE = 1.0
+	MINIMAP_MAX_RANGE = 32.0
+)
+
+// The Minimap shows the walls of the world from above in the top right
+// corner of the window, with us as an arrow in the middle. Press m to show
+// or hide it, - and = to zoom out and in, and r to have it turn with us
+// instead of keeping north up.
+type Minimap struct {
+	visible bool
+	rotate  bool    // turn the map so ahead of us is up
+	rng     float64 // how far the map reaches from us in every direction
+}
+
+var minimap = Minimap{visible: true, rng: MINIMAP_RANGE}
+
+// Zoom the minimap in (factor below 1) or out (above 1)
+func (m *Minimap) zoom(factor float64) {
+	m.rng = math.Max(MINIMAP_MIN_RANGE, math.Min(MINIMAP_MAX_RANGE, m.rng*factor))
+}
+
+// The walls of the world seen from above: every triangle steeper than a
+// floor becomes a line between the two corners furthest apart on the map
+func (w *World) mapLines() [][2][2]float64 {
+	var lines [][2][2]float64
+	for _, triangle := range w.Triangles() {
+		if math.Abs(triangle.faceNormal()[1]) >= MIN_LEVEL_NORMAL {
+			continue
+		}
+
+		corners := triangle.corners()
+		var best [2][2]float64
+		longest := -1.0
+		for i := range corners {
+			a, b := corners[i], corners[(i+1)%3]
+			dx, dz := b[0]-a[0], b[2]-a[2]
+			if l := dx*dx + dz*dz; l > longest {
+				best, longest = [2][2]float64{{a[0], a[2]}, {b[0], b[2]}}, l
+			}
+		}
+		if longest > 0 {
+			lines = append(lines, best)
+		}
+	}
+	return lines
+}
+
+// Draw the minimap over the scene
+func (m *Minimap) draw(world *World) {
+	if !m.visible {
+		return
+	}
+
+	x := windowWidth - MINIMAP_SIZE - MINIMAP_MARGIN
+	y := windowHeight - MINIMAP_SIZE - MINIMAP_MARGIN
+	gl.Viewport(x, y, MINIMAP_SIZE, MINIMAP_SIZE)
+	gl.Scissor(x, y, MINIMAP_SIZE, MINIMAP_SIZE)
+	gl.Enable(gl.SCISSOR_TEST)
+
+	// a square of the world around us, north up, in world units
+	gl.MatrixMode(gl.PROJECTION)
+	gl.PushMatrix()
+	gl.LoadIdentity()
+	gl.Ortho(-m.rng, m.rng, -m.rng, m.rng, -1, 1)
+	gl.MatrixMode(gl.MODELVIEW)
+	gl.PushMatrix()
+	gl.LoadIdentity()
+
+	gl.Disable(gl.TEXTURE_2D)
+	gl.Disable(gl.DEPTH_TEST)
+	gl.Disable(gl.LIGHTING)
+	gl.Enable(gl.BLEND)
+	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
+
+	// darken the scene behind the map
+	gl.Color4f(0.0, 0.0, 0.0, 0.6)
+	gl.Begin(gl.QUADS)
+	gl.Vertex2f(float32(-m.rng), float32(-m.rng))
+	gl.Vertex2f(float32(m.rng), float32(-m.rng))
+	gl.Vertex2f(float32(m.rng), float32(m.rng))
+	gl.Vertex2f(float32(-m.rng), float32(m.rng))
+	gl.End()
+
+	if m.rotate {
+		gl.Rotatef(float32(-yrot), 0.0, 0.0, 1.0)
+	}
+
+	// going north is going along -z, which is up on the map
+	gl.PushMatrix()
+	gl.Translatef(float32(-xpos), float32(zpos), 0.0)
+	gl.Color4f(0.8, 0.8, 0.8, 1.0)
+	gl.Begin(gl.LINES)
+	for _, line := range world.mapLines() {
+		gl.Vertex2f(float32(line[0][0]), float32(-line[0][1]))
+		gl.Vertex2f(float32(line[1][0]), float32(-line[1][1]))
+	}
+	gl.End()
+	gl.PopMatrix()
+
+	// point the arrow where we look, which is up when the map turns
+	gl.Rotatef(float32(yrot), 0.0, 0.0, 1.0)
+
+	// the arrow keeps its size when zooming
+	s := float32(m.rng / 20)
+	gl.Color4f(1.0, 0.8, 0.0, 1.0)
+	gl.Begin(gl.TRIANGLES)
+	gl.Vertex2f(0.0, 1.5*s)
+	gl.Vertex2f(-s, -s)
+	gl.Vertex2f(s, -s)
+	gl.End()
+
+	gl.MatrixMode(gl.PROJECTION)
+	gl.PopMatrix()
+	gl.MatrixMode(gl.MODELVIEW)
+	gl.PopMatrix()
+
+	gl.Disable(gl.BLEND)
+	gl.Disable(gl.SCISSOR_TEST)
+	gl.Color4f(1.0, 1.0, 1.0, 1.0)
+	gl.Enable(gl.DEPTH_TEST)
+	gl.Enable(gl.TEXTURE_2D)
+	if light {
+		gl.Enable(gl.LIGHTING)
+	}
+	gl.Viewport(0, 0, windowWidth, windowHeight)
+}
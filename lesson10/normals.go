@@ -0,0 +1,92 @@
+package main
+
+import (
+	"github.com/banthar/gl"
+	"math"
+)
+
+// Edges where triangles meet at a sharper angle than this, in degrees,
+// stay sharp when lit; flatter ones are smoothed over
+const DEFAULT_CREASE_ANGLE = 45.0
+
+// the crease angle normals are computed with when a world is loaded
+var creaseAngle = DEFAULT_CREASE_ANGLE
+
+// Compute normals for the triangles that don't have them. Each corner gets
+// the average of the normals of the triangles meeting there, weighted by
+// their angle at that point, leaving out those bent away further than the
+// crease angle. Vertices shared by triangles on both sides of a crease are
+// split, so each side has its own.
+//
+// The normals point to the side a triangle's corners are counter-clockwise
+// from. World files don't agree on that, so neighbours wound the other way
+// round count as if they were flipped, and lighting is done on both sides.
+func (w *World) ComputeNormals(crease float64) {
+	minDot := math.Cos(crease * math.Pi / 180.0)
+	triangles := w.Triangles()
+
+	// the corners of triangles at each point
+	type corner struct {
+		normal vec3
+		angle  float64
+	}
+	var missing []*Triangle
+	faces := make(map[*Triangle]vec3, len(triangles))
+	touching := map[[3]gl.GLfloat][]corner{}
+	for _, triangle := range triangles {
+		if !triangle.hasNormals() {
+			missing = append(missing, triangle)
+		}
+		face := triangle.faceNormal()
+		faces[triangle] = face
+
+		points := triangle.corners()
+		for i, vertex := range triangle.vertices {
+			a := points[(i+1)%3].sub(points[i]).normalize()
+			b := points[(i+2)%3].sub(points[i]).normalize()
+			angle := math.Acos(math.Max(-1, math.Min(1, a.dot(b))))
+
+			key := [3]gl.GLfloat{vertex.x, vertex.y, vertex.z}
+			touching[key] = append(touching[key], corner{face, angle})
+		}
+	}
+
+	assigned := map[*Vertex]vec3{}
+	for _, triangle := range missing {
+		face := faces[triangle]
+		for i, vertex := range triangle.vertices {
+			var sum vec3
+			key := [3]gl.GLfloat{vertex.x, vertex.y, vertex.z}
+			for _, other := range touching[key] {
+				normal := other.normal
+				d := normal.dot(face)
+				if d < 0 {
+					normal, d = normal.scale(-1), -d
+				}
+				if d >= minDot {
+					sum = sum.add(normal.scale(other.angle))
+				}
+			}
+			normal := sum.normalize()
+
+			if previous, ok := assigned[vertex]; ok && previous != normal {
+				split := *vertex
+				vertex = &split
+				triangle.vertices[i] = vertex
+			}
+			assigned[vertex] = normal
+
+			vertex.nx, vertex.ny, vertex.nz = gl.GLfloat(normal[0]), gl.GLfloat(normal[1]), gl.GLfloat(normal[2])
+		}
+	}
+}
+
+// Whether all corners of a triangle have a normal
+func (t *Triangle) hasNormals() bool {
+	for _, v := range t.vertices {
+		if v.nx == 0 && v.ny == 0 && v.nz == 0 {
+			return false
+		}
+	}
+	return true
+}
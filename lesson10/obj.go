@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/banthar/gl"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Load a Wavefront OBJ file, and the MTL files it uses, as a world with a
+// single sector. Paths inside the files are relative to the directory of
+// the OBJ file.
+func LoadOBJ(path string) (*World, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	world, err := ParseOBJ(file, filepath.Dir(path))
+	if werr, ok := err.(*WorldError); ok && werr.Path == "" {
+		werr.Path = path
+	}
+	return world, err
+}
+
+// Parse OBJ data into a world with a single sector. Polygons are split into triangle fans,
+// and material libraries are loaded from dir.
+//
+// Only geometry and materials are read; groups, smoothing and free-form
+// statements are skipped. Vertices without texture coordinates have them
+// set to zero, and normals are computed for faces without them.
+func ParseOBJ(r io.Reader, dir string) (*World, error) {
+	p := &objParser{
+		dir:       dir,
+		materials: map[string]*Material{},
+		vertices:  map[[3]int]*Vertex{},
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		p.line++
+		if err := p.parseLine(scanner.Text()); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sector := &Sector{name: "default", triangles: p.triangles}
+	world := &World{sectors: []*Sector{sector}}
+	world.ComputeNormals(creaseAngle)
+	return world, nil
+}
+
+// state kept while reading an OBJ file
+type objParser struct {
+	dir       string
+	line      int
+	triangles []*Triangle
+
+	positions [][3]gl.GLfloat
+	uvs       [][2]gl.GLfloat
+	normals   [][3]gl.GLfloat
+
+	// face corners using the same position, uv and normal share a vertex
+	vertices map[[3]int]*Vertex
+
+	materials map[string]*Material
+	material  *Material // set by usemtl, used for the faces that follow
+}
+
+func (p *objParser) errorf(column int, format string, a ...interface{}) error {
+	return &WorldError{Line: p.line, Column: column, Msg: fmt.Sprintf(format, a...)}
+}
+
+func (p *objParser) parseLine(line string) error {
+	// OBJ only knows # comments, so don't use splitFields here
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+
+	fields := splitWords(line)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	args := fields[1:]
+	switch fields[0].text {
+	case "v":
+		xyz, err := p.floats(fields[0], args, 3, 4)
+		if err != nil {
+			return err
+		}
+		p.positions = append(p.positions, [3]gl.GLfloat{xyz[0], xyz[1], xyz[2]})
+	case "vt":
+		uv, err := p.floats(fields[0], args, 1, 3)
+		if err != nil {
+			return err
+		}
+		uv = append(uv, 0)
+		// OBJ texture coordinates start at the bottom of the image, ours at the top
+		p.uvs = append(p.uvs, [2]gl.GLfloat{uv[0], 1 - uv[1]})
+	case "vn":
+		n, err := p.floats(fields[0], args, 3, 3)
+		if err != nil {
+			return err
+		}
+		p.normals = append(p.normals, [3]gl.GLfloat{n[0], n[1], n[2]})
+	case "f":
+		return p.parseFace(fields[0], args)
+	case "usemtl":
+		if len(args) != 1 {
+			return p.errorf(fields[0].column, "usemtl takes 1 argument, got %d", len(args))
+		}
+		p.material = p.materials[args[0].text]
+		if p.material == nil {
+			// keep the reference even if no library defines it
+			p.material = &Material{name: args[0].text, color: [4]gl.GLfloat{1, 1, 1, 1}}
+			p.materials[args[0].text] = p.material
+		}
+	case "mtllib":
+		for _, arg := range args {
+			if err := p.loadMaterials(arg); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Parse between min and max numbers following the statement at name
+func (p *objParser) floats(name field, args []field, min, max int) ([]gl.GLfloat, error) {
+	if len(args) < min || len(args) > max {
+		if min == max {
+			return nil, p.errorf(name.column, "%s takes %d numbers, got %d", name.text, min, len(args))
+		}
+		return nil, p.errorf(name.column, "%s takes %d to %d numbers, got %d", name.text, min, max, len(args))
+	}
+
+	values := make([]gl.GLfloat, len(args))
+	for i, arg := range args {
+		value, ok := parseNumber(arg.text)
+		if !ok {
+			return nil, p.errorf(arg.column, "invalid number %q", arg.text)
+		}
+		values[i] = gl.GLfloat(value)
+	}
+
+	return values, nil
+}
+
+// Parse a face of three or more corners given as v, v/vt, v//vn or v/vt/vn
+func (p *objParser) parseFace(name field, args []field) error {
+	if len(args) < 3 {
+		return p.errorf(name.column, "face needs at least 3 vertices, got %d", len(args))
+	}
+
+	corners := make([]*Vertex, len(args))
+	for i, arg := range args {
+		vertex, err := p.parseCorner(arg)
+		if err != nil {
+			return err
+		}
+		corners[i] = vertex
+	}
+
+	// split the polygon into a fan around the first corner
+	for i := 1; i+1 < len(corners); i++ {
+		p.triangles = append(p.triangles, &Triangle{
+			vertices: [3]*Vertex{corners[0], corners[i], corners[i+1]},
+			material: p.material,
+		})
+	}
+
+	return nil
+}
+
+func (p *objParser) parseCorner(arg field) (*Vertex, error) {
+	parts := strings.Split(arg.text, "/")
+	if len(parts) > 3 {
+		return nil, p.errorf(arg.column, "invalid face vertex %q", arg.text)
+	}
+
+	// indices into positions, uvs and normals, -1 where not given
+	key := [3]int{-1, -1, -1}
+	counts := [3]int{len(p.positions), len(p.uvs), len(p.normals)}
+	for i, part := range parts {
+		if part == "" && i > 0 {
+			continue
+		}
+		index, err := strconv.Atoi(part)
+		if err != nil || index == 0 {
+			return nil, p.errorf(arg.column, "invalid face vertex %q", arg.text)
+		}
+		// negative indices count back from the last element read so far
+		if index < 0 {
+			index += counts[i]
+		} else {
+			index--
+		}
+		if index < 0 || index >= counts[i] {
+			return nil, p.errorf(arg.column, "face vertex %q refers to a missing element", arg.text)
+		}
+		key[i] = index
+	}
+
+	if vertex, ok := p.vertices[key]; ok {
+		return vertex, nil
+	}
+
+	position := p.positions[key[0]]
+	vertex := &Vertex{x: position[0], y: position[1], z: position[2]}
+	if key[1] >= 0 {
+		vertex.u, vertex.v = p.uvs[key[1]][0], p.uvs[key[1]][1]
+	}
+	if key[2] >= 0 {
+		normal := p.normals[key[2]]
+		vertex.nx, vertex.ny, vertex.nz = normal[0], normal[1], normal[2]
+	}
+
+	p.vertices[key] = vertex
+	return vertex, nil
+}
+
+// Load the materials of a library, replacing those of the same name. A
+// missing library isn't fatal, its materials stay plain white.
+func (p *objParser) loadMaterials(name field) error {
+	path := filepath.Join(p.dir, name.text)
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Println("warning:", err)
+		return nil
+	}
+	defer file.Close()
+
+	materials, err := ParseMTL(file, p.dir)
+	if werr, ok := err.(*WorldError); ok {
+		werr.Path = path
+	}
+	if err != nil {
+		return err
+	}
+
+	for name, material := range materials {
+		// faces read after a usemtl of a material that wasn't defined yet
+		// point at its placeholder, so fill that in
+		if placeholder, ok := p.materials[name]; ok {
+			*placeholder = *material
+		} else {
+			p.materials[name] = material
+		}
+	}
+	return nil
+}
+
+// Parse a MTL material library. Texture paths are relative to dir.
+// Only the diffuse color (Kd), opacity (d or Tr) and diffuse texture
+// (map_Kd) are used.
+func ParseMTL(r io.Reader, dir string) (map[string]*Material, error) {
+	materials := map[string]*Material{}
+	var material *Material
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+
+		fields := splitWords(text)
+		if len(fields) == 0 {
+			continue
+		}
+
+		errorf := func(column int, format string, a ...interface{}) error {
+			return &WorldError{Line: line, Column: column, Msg: fmt.Sprintf(format, a...)}
+		}
+
+		name, args := fields[0], fields[1:]
+		if name.text == "newmtl" {
+			if len(args) != 1 {
+				return nil, errorf(name.column, "newmtl takes 1 argument, got %d", len(args))
+			}
+			material = &Material{name: args[0].text, color: [4]gl.GLfloat{1, 1, 1, 1}}
+			materials[material.name] = material
+			continue
+		}
+
+		switch name.text {
+		case "Kd", "d", "Tr", "map_Kd":
+		default:
+			continue
+		}
+
+		if material == nil {
+			return nil, errorf(name.column, "%s before newmtl", name.text)
+		}
+		if len(args) == 0 {
+			return nil, errorf(name.column, "%s needs an argument", name.text)
+		}
+
+		if name.text == "map_Kd" {
+			// options like -s come first, the file name is last
+			material.texture = filepath.Join(dir, args[len(args)-1].text)
+			continue
+		}
+
+		var values []gl.GLfloat
+		for _, arg := range args {
+			value, ok := parseNumber(arg.text)
+			if !ok {
+				return nil, errorf(arg.column, "invalid number %q", arg.text)
+			}
+			values = append(values, gl.GLfloat(value))
+		}
+
+		switch name.text {
+		case "Kd":
+			if len(values) != 3 {
+				return nil, errorf(name.column, "Kd takes 3 numbers, got %d", len(values))
+			}
+			copy(material.color[:3], values)
+		case "d":
+			material.color[3] = values[0]
+		case "Tr":
+			material.color[3] = 1 - values[0]
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// MTL has no blend modes, but see-through materials need blending
+	for _, material := range materials {
+		if material.color[3] < 1 {
+			material.blend = BLEND_ALPHA
+		}
+	}
+
+	return materials, nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"github.com/banthar/gl"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// the corners of a unit square and a pentagon in the floor
+const objPolygons = `
+v 0 0 0
+v 1 0 0
+v 1 0 1
+v 0 0 1
+v 0.5 0 1.5
+`
+
+// Where the corners of the triangles are, as indices into the v lines
+func objCorners(world *World, positions [][3]gl.GLfloat) [][3]int {
+	var corners [][3]int
+	for _, triangle := range world.Triangles() {
+		var c [3]int
+		for i, vertex := range triangle.vertices {
+			c[i] = -1
+			for j, p := range positions {
+				if p == [3]gl.GLfloat{vertex.x, vertex.y, vertex.z} {
+					c[i] = j + 1
+				}
+			}
+		}
+		corners = append(corners, c)
+	}
+	return corners
+}
+
+// Polygons are split into fans around their first corner, and negative
+// indices count back from the last vertex read
+func TestParseOBJFaces(t *testing.T) {
+	positions := [][3]gl.GLfloat{{0, 0, 0}, {1, 0, 0}, {1, 0, 1}, {0, 0, 1}, {0.5, 0, 1.5}}
+	tests := []struct {
+		name  string
+		faces string
+		want  [][3]int
+	}{
+		{"triangle", "f 1 2 3", [][3]int{{1, 2, 3}}},
+		{"quad", "f 1 2 3 4", [][3]int{{1, 2, 3}, {1, 3, 4}}},
+		{"pentagon", "f 1 2 3 5 4", [][3]int{{1, 2, 3}, {1, 3, 5}, {1, 5, 4}}},
+		{"negative", "f -5 -4 -3 -2", [][3]int{{1, 2, 3}, {1, 3, 4}}},
+		{"mixed", "f 1 -4 3", [][3]int{{1, 2, 3}}},
+	}
+
+	for _, test := range tests {
+		world, err := ParseOBJ(strings.NewReader(objPolygons+test.faces+"\n"), "data")
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		got := objCorners(world, positions)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got triangles %v, want %v", test.name, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: got triangles %v, want %v", test.name, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+// Corners can leave out texture coordinates or normals, and texture
+// coordinates are flipped to start at the top of the image
+func TestParseOBJCorners(t *testing.T) {
+	src := objPolygons + `
+vt 0.25 0.75
+vt 1 0
+vn 0 1 0
+vn 0 -1 0
+f 1/1 2/2 3/1
+f 1//2 3//2 4//2
+f 1/2/1 3/2/1 4/1/1
+`
+	world, err := ParseOBJ(strings.NewReader(src), "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	triangles := world.Triangles()
+	if len(triangles) != 3 {
+		t.Fatalf("got %d triangles, want 3", len(triangles))
+	}
+
+	tests := []struct {
+		name   string
+		vertex *Vertex
+		uv     [2]gl.GLfloat
+		normal [3]gl.GLfloat
+	}{
+		// the normal of a face without them points up, as it is
+		// counter-clockwise seen from below
+		{"v/vt", triangles[0].vertices[0], [2]gl.GLfloat{0.25, 0.25}, [3]gl.GLfloat{0, -1, 0}},
+		{"v/vt", triangles[0].vertices[1], [2]gl.GLfloat{1, 1}, [3]gl.GLfloat{0, -1, 0}},
+		{"v//vn", triangles[1].vertices[0], [2]gl.GLfloat{0, 0}, [3]gl.GLfloat{0, -1, 0}},
+		{"v/vt/vn", triangles[2].vertices[2], [2]gl.GLfloat{0.25, 0.25}, [3]gl.GLfloat{0, 1, 0}},
+	}
+	for _, test := range tests {
+		v := test.vertex
+		if uv := [2]gl.GLfloat{v.u, v.v}; uv != test.uv {
+			t.Errorf("%s: got texture coordinates %v, want %v", test.name, uv, test.uv)
+		}
+		if n := [3]gl.GLfloat{v.nx, v.ny, v.nz}; n != test.normal {
+			t.Errorf("%s: got normal %v, want %v", test.name, n, test.normal)
+		}
+	}
+}
+
+// Corners with the same position, texture coordinates and normal are the
+// same vertex, all others are not
+func TestParseOBJSharing(t *testing.T) {
+	src := objPolygons + `
+vt 0 0
+vt 1 1
+vn 0 1 0
+f 1/1/1 2/1/1 3/1/1
+f 1/1/1 3/1/1 4/1/1
+f 1/2/1 3/1/1 4//1
+`
+	world, err := ParseOBJ(strings.NewReader(src), "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b, c := world.Triangles()[0], world.Triangles()[1], world.Triangles()[2]
+
+	if a.vertices[0] != b.vertices[0] || a.vertices[2] != b.vertices[1] {
+		t.Errorf("corners with the same indices don't share a vertex")
+	}
+	if c.vertices[1] != b.vertices[1] {
+		t.Errorf("corners with the same indices in a later face don't share a vertex")
+	}
+	if c.vertices[0] == b.vertices[0] {
+		t.Errorf("corners with different texture coordinates share a vertex")
+	}
+	if c.vertices[2] == b.vertices[2] {
+		t.Errorf("a corner without texture coordinates shares a vertex with one that has them")
+	}
+}
+
+// Write files into a new directory, returning it
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestParseOBJMaterials(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.mtl": `
+# colors, opacity both ways, and a texture with options
+newmtl red
+Kd 1 0 0
+newmtl glass
+Kd 0.5 0.5 1
+d 0.25
+newmtl smoke
+Tr 0.75
+newmtl brick
+map_Kd -s 2 2 1 textures/brick.bmp
+`})
+
+	tests := []struct {
+		name    string
+		color   [4]gl.GLfloat
+		texture string
+		blend   int
+	}{
+		{"red", [4]gl.GLfloat{1, 0, 0, 1}, "", BLEND_NONE},
+		{"glass", [4]gl.GLfloat{0.5, 0.5, 1, 0.25}, "", BLEND_ALPHA},
+		{"smoke", [4]gl.GLfloat{1, 1, 1, 0.25}, "", BLEND_ALPHA},
+		{"brick", [4]gl.GLfloat{1, 1, 1, 1}, filepath.Join(dir, "textures/brick.bmp"), BLEND_NONE},
+		// only used, never defined
+		{"unknown", [4]gl.GLfloat{1, 1, 1, 1}, "", BLEND_NONE},
+	}
+
+	// the first face uses its material before the library defines it
+	src := objPolygons
+	for i, test := range tests {
+		src += "usemtl " + test.name + "\nf 1 2 3\n"
+		if i == 0 {
+			src += "mtllib a.mtl\n"
+		}
+	}
+	world, err := ParseOBJ(strings.NewReader(src), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, test := range tests {
+		m := world.Triangles()[i].material
+		if m == nil || m.name != test.name {
+			t.Errorf("%s: got material %v", test.name, m)
+			continue
+		}
+		if m.color != test.color || m.texture != test.texture || m.blend != test.blend {
+			t.Errorf("%s: got color %v, texture %q and blending %d, want %v, %q and %d",
+				test.name, m.color, m.texture, m.blend, test.color, test.texture, test.blend)
+		}
+	}
+}
+
+// A library that isn't there leaves its materials plain white
+func TestParseOBJMissingLibrary(t *testing.T) {
+	src := objPolygons + "mtllib missing.mtl\nusemtl red\nf 1 2 3\n"
+	world, err := ParseOBJ(strings.NewReader(src), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := world.Triangles()[0].material
+	if m == nil || m.name != "red" || m.color != [4]gl.GLfloat{1, 1, 1, 1} {
+		t.Errorf("got material %v, want a white red", m)
+	}
+}
+
+func TestParseOBJErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string // the start of the error, with its line and column
+	}{
+		{"short vertex", "v 1 2\n", "1:1: v takes 3 to 4 numbers, got 2"},
+		{"NaN vertex", "v 1 NaN 2\n", "1:5: invalid number"},
+		{"short normal", "vn 0 1\n", "1:1: vn takes 3 numbers, got 2"},
+		{"short face", objPolygons + "f 1 2\n", "7:1: face needs at least 3 vertices, got 2"},
+		{"bad index", objPolygons + "f 1 2 x\n", "7:7: invalid face vertex \"x\""},
+		{"zero index", objPolygons + "f 1 0 2\n", "7:5: invalid face vertex \"0\""},
+		{"too many slashes", objPolygons + "f 1 2 3/1/1/1\n", "7:7: invalid face vertex"},
+		{"index past the end", objPolygons + "  f 1 2 6\n", "7:9: face vertex \"6\" refers to a missing element"},
+		{"negative past the start", objPolygons + "f 1 2 -6\n", "7:7: face vertex \"-6\" refers to a missing element"},
+		{"missing uv", objPolygons + "f 1/1 2/1 3/1\n", "7:3: face vertex \"1/1\" refers to a missing element"},
+		{"missing normal", objPolygons + "vt 0 0\nf 1/1/1 2 3\n", "8:3: face vertex \"1/1/1\" refers to a missing element"},
+		{"usemtl", "usemtl\n", "1:1: usemtl takes 1 argument, got 0"},
+	}
+
+	for _, test := range tests {
+		_, err := ParseOBJ(strings.NewReader(test.src), "data")
+		if err == nil || !strings.HasPrefix(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestParseMTLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"before newmtl", "Kd 1 1 1\n", "1:1: Kd before newmtl"},
+		{"short color", "newmtl a\nKd 1 1\n", "2:1: Kd takes 3 numbers, got 2"},
+		{"bad opacity", "newmtl a\n d x\n", "2:4: invalid number"},
+		{"no texture", "newmtl a\nmap_Kd\n", "2:1: map_Kd needs an argument"},
+	}
+
+	for _, test := range tests {
+		_, err := ParseMTL(strings.NewReader(test.src), "data")
+		if err == nil || !strings.HasPrefix(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.want)
+		}
+	}
+}
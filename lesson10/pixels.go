@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"github.com/banthar/Go-SDL/sdl"
+	"github.com/banthar/gl"
+	"image"
+	"math/bits"
+	"unsafe"
+)
+
+// PixelFormat describes how the pixels of an image are laid out in memory.
+// Images with one byte per pixel are indices into the palette, everything
+// else is split into channels by the masks, which have to be contiguous.
+type PixelFormat struct {
+	bytesPerPixel              int
+	rmask, gmask, bmask, amask uint32
+	palette                    [][4]uint8
+}
+
+// Some common 16 bit layouts
+var (
+	RGB565   = PixelFormat{bytesPerPixel: 2, rmask: 0xf800, gmask: 0x07e0, bmask: 0x001f}
+	RGBA5551 = PixelFormat{bytesPerPixel: 2, rmask: 0xf800, gmask: 0x07c0, bmask: 0x003e, amask: 0x0001}
+	RGBA4444 = PixelFormat{bytesPerPixel: 2, rmask: 0xf000, gmask: 0x0f00, bmask: 0x00f0, amask: 0x000f}
+)
+
+// An Image holds pixels in a format that can be handed to gl.TexImage2D
+type Image struct {
+	width, height int
+	format        gl.GLenum
+	pixels        []byte
+}
+
+// Build our PixelFormat from the one SDL gives us
+func surfaceFormat(format *sdl.PixelFormat) PixelFormat {
+	f := PixelFormat{
+		bytesPerPixel: int(format.BytesPerPixel),
+		rmask:         format.Rmask,
+		gmask:         format.Gmask,
+		bmask:         format.Bmask,
+		amask:         format.Amask,
+	}
+
+	if f.bytesPerPixel == 1 && format.Palette != nil {
+		n := int(format.Palette.Ncolors)
+		colors := (*[256]sdl.Color)(unsafe.Pointer(format.Palette.Colors))[:n:n]
+		f.palette = make([][4]uint8, n)
+		for i, c := range colors {
+			f.palette[i] = [4]uint8{c.R, c.G, c.B, 255}
+		}
+	}
+
+	return f
+}
+
+// The GL format that can read pixels in this format unchanged, if there is
+// one. GL reads bytes in order, while the masks apply to little endian
+// pixel values, so 0x000000ff is the first byte.
+func (f PixelFormat) glFormat() (format gl.GLenum, ok bool) {
+	masks := [4]uint32{f.rmask, f.gmask, f.bmask, f.amask}
+
+	switch {
+	case f.bytesPerPixel == 4 && masks == [4]uint32{0xff, 0xff00, 0xff0000, 0xff000000}:
+		return gl.RGBA, true
+	case f.bytesPerPixel == 4 && masks == [4]uint32{0xff0000, 0xff00, 0xff, 0xff000000}:
+		return gl.BGRA, true
+	case f.bytesPerPixel == 3 && masks == [4]uint32{0xff, 0xff00, 0xff0000, 0}:
+		return gl.RGB, true
+	case f.bytesPerPixel == 3 && masks == [4]uint32{0xff0000, 0xff00, 0xff, 0}:
+		return gl.BGR, true
+	}
+
+	return 0, false
+}
+
+// Convert the pixels of an image with the given format to an Image.
+// Formats GL understands are only copied, everything else becomes RGBA.
+func ConvertPixels(src []byte, width, height, pitch int, f PixelFormat) (*Image, error) {
+	bpp := f.bytesPerPixel
+	if bpp < 1 || bpp > 4 {
+		return nil, fmt.Errorf("unsupported pixel size of %d bytes", bpp)
+	}
+	if bpp == 1 && f.palette == nil {
+		return nil, fmt.Errorf("8 bit image without palette")
+	}
+	if pitch < width*bpp || len(src) < pitch*(height-1)+width*bpp {
+		return nil, fmt.Errorf("%d bytes are too few for a %dx%d image with pitch %d", len(src), width, height, pitch)
+	}
+
+	img := &Image{width: width, height: height}
+
+	if format, ok := f.glFormat(); ok {
+		img.format = format
+		img.pixels = make([]byte, width*height*bpp)
+		for y := 0; y < height; y++ {
+			copy(img.pixels[y*width*bpp:(y+1)*width*bpp], src[y*pitch:])
+		}
+		return img, nil
+	}
+
+	img.format = gl.RGBA
+	img.pixels = make([]byte, width*height*4)
+
+	r, g, b, a := newChannel(f.rmask), newChannel(f.gmask), newChannel(f.bmask), newChannel(f.amask)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			// pixels are stored in little endian byte order
+			var pixel uint32
+			offset := y*pitch + x*bpp
+			for i := 0; i < bpp; i++ {
+				pixel |= uint32(src[offset+i]) << uint(8*i)
+			}
+
+			dst := img.pixels[(y*width+x)*4:]
+			if bpp == 1 {
+				if int(pixel) >= len(f.palette) {
+					return nil, fmt.Errorf("pixel %d,%d uses color %d of a %d color palette", x, y, pixel, len(f.palette))
+				}
+				copy(dst, f.palette[pixel][:])
+				continue
+			}
+
+			dst[0] = r.value(pixel, 0)
+			dst[1] = g.value(pixel, 0)
+			dst[2] = b.value(pixel, 0)
+			dst[3] = a.value(pixel, 255)
+		}
+	}
+
+	return img, nil
+}
+
+// Convert the image to the image package's RGBA, for encoding. Images only
+// come from ConvertPixels, so any other format is a bug and panics.
+func (img *Image) RGBA() *image.RGBA {
+	rgba := image.NewRGBA(image.Rect(0, 0, img.width, img.height))
+
+	// byte offsets of red, green and blue, and alpha if there is one
+	var bpp int
+	var order [4]int
+	switch img.format {
+	case gl.RGBA:
+		bpp, order = 4, [4]int{0, 1, 2, 3}
+	case gl.BGRA:
+		bpp, order = 4, [4]int{2, 1, 0, 3}
+	case gl.RGB:
+		bpp, order = 3, [4]int{0, 1, 2, -1}
+	case gl.BGR:
+		bpp, order = 3, [4]int{2, 1, 0, -1}
+	default:
+		panic(fmt.Sprintf("can't convert pixels of GL format 0x%04x to RGBA", img.format))
+	}
+
+	for i := 0; i < img.width*img.height; i++ {
+		src := img.pixels[i*bpp:]
+		dst := rgba.Pix[i*4:]
+		for c := 0; c < 4; c++ {
+			if order[c] < 0 {
+				dst[c] = 255
+			} else {
+				dst[c] = src[order[c]]
+			}
+		}
+	}
+
+	return rgba
+}
+
+// A channel extracts one component of a pixel given by a mask
+type channel struct {
+	mask  uint32
+	shift uint
+	max   uint64
+}
+
+func newChannel(mask uint32) channel {
+	shift := uint(bits.TrailingZeros32(mask))
+	if mask == 0 {
+		return channel{}
+	}
+	return channel{mask: mask, shift: shift, max: uint64(mask >> shift)}
+}
+
+// Scale the component to 0-255, or return def if the channel is missing
+func (c channel) value(pixel uint32, def uint8) uint8 {
+	if c.mask == 0 {
+		return def
+	}
+	v := uint64((pixel & c.mask) >> c.shift)
+	return uint8((v*255 + c.max/2) / c.max)
+}
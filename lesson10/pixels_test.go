@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/banthar/gl"
+	"strings"
+	"testing"
+)
+
+func TestConvertPixels(t *testing.T) {
+	tests := []struct {
+		name          string
+		src           []byte
+		width, height int
+		pitch         int
+		format        PixelFormat
+		want          Image
+	}{
+		{
+			name:  "RGBA is copied",
+			src:   []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			width: 2, height: 1, pitch: 8,
+			format: PixelFormat{bytesPerPixel: 4, rmask: 0xff, gmask: 0xff00, bmask: 0xff0000, amask: 0xff000000},
+			want:   Image{format: gl.RGBA, pixels: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		},
+		{
+			name:  "BGRA is copied",
+			src:   []byte{1, 2, 3, 4},
+			width: 1, height: 1, pitch: 4,
+			format: PixelFormat{bytesPerPixel: 4, rmask: 0xff0000, gmask: 0xff00, bmask: 0xff, amask: 0xff000000},
+			want:   Image{format: gl.BGRA, pixels: []byte{1, 2, 3, 4}},
+		},
+		{
+			name:  "RGB loses the padding at the end of rows",
+			src:   []byte{1, 2, 3, 0, 4, 5, 6, 0},
+			width: 1, height: 2, pitch: 4,
+			format: PixelFormat{bytesPerPixel: 3, rmask: 0xff, gmask: 0xff00, bmask: 0xff0000},
+			want:   Image{format: gl.RGB, pixels: []byte{1, 2, 3, 4, 5, 6}},
+		},
+		{
+			name:  "BGR is copied",
+			src:   []byte{1, 2, 3},
+			width: 1, height: 1, pitch: 3,
+			format: PixelFormat{bytesPerPixel: 3, rmask: 0xff0000, gmask: 0xff00, bmask: 0xff},
+			want:   Image{format: gl.BGR, pixels: []byte{1, 2, 3}},
+		},
+		{
+			name:  "32 bit without alpha mask is opaque",
+			src:   []byte{3, 2, 1, 99},
+			width: 1, height: 1, pitch: 4,
+			format: PixelFormat{bytesPerPixel: 4, rmask: 0xff0000, gmask: 0xff00, bmask: 0xff},
+			want:   Image{format: gl.RGBA, pixels: []byte{1, 2, 3, 255}},
+		},
+		{
+			name: "RGB565 scales 5 and 6 bit channels",
+			// 0xf800 full red, 0x0020 the smallest green, 0x0001 the smallest blue
+			src:   []byte{0x00, 0xf8, 0x20, 0x00, 0x01, 0x00},
+			width: 3, height: 1, pitch: 6,
+			format: RGB565,
+			want: Image{format: gl.RGBA, pixels: []byte{
+				255, 0, 0, 255,
+				0, 4, 0, 255,
+				0, 0, 8, 255,
+			}},
+		},
+		{
+			name: "RGBA5551 has a 1 bit alpha",
+			// 0x003f full blue and opaque, 0x003e full blue and clear
+			src:   []byte{0x3f, 0x00, 0x3e, 0x00},
+			width: 2, height: 1, pitch: 4,
+			format: RGBA5551,
+			want: Image{format: gl.RGBA, pixels: []byte{
+				0, 0, 255, 255,
+				0, 0, 255, 0,
+			}},
+		},
+		{
+			name: "RGBA4444 scales 4 bit channels",
+			// 0x8421: red 8, green 4, blue 2 and alpha 1 of 15
+			src:   []byte{0x21, 0x84},
+			width: 1, height: 1, pitch: 2,
+			format: RGBA4444,
+			want:   Image{format: gl.RGBA, pixels: []byte{136, 68, 34, 17}},
+		},
+		{
+			name:  "16 bit without alpha mask is opaque",
+			src:   []byte{0xff, 0xff},
+			width: 1, height: 1, pitch: 2,
+			format: RGB565,
+			want:   Image{format: gl.RGBA, pixels: []byte{255, 255, 255, 255}},
+		},
+		{
+			name:  "palette",
+			src:   []byte{1, 0, 0, 0},
+			width: 2, height: 2, pitch: 2,
+			format: PixelFormat{bytesPerPixel: 1, palette: [][4]uint8{{0, 0, 0, 255}, {10, 20, 30, 255}}},
+			want: Image{format: gl.RGBA, pixels: []byte{
+				10, 20, 30, 255, 0, 0, 0, 255,
+				0, 0, 0, 255, 0, 0, 0, 255,
+			}},
+		},
+	}
+
+	for _, test := range tests {
+		img, err := ConvertPixels(test.src, test.width, test.height, test.pitch, test.format)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if img.width != test.width || img.height != test.height {
+			t.Errorf("%s: got a %dx%d image, want %dx%d", test.name, img.width, img.height, test.width, test.height)
+		}
+		if img.format != test.want.format {
+			t.Errorf("%s: got format %#x, want %#x", test.name, img.format, test.want.format)
+		}
+		if !bytes.Equal(img.pixels, test.want.pixels) {
+			t.Errorf("%s: got pixels %v, want %v", test.name, img.pixels, test.want.pixels)
+		}
+	}
+}
+
+func TestConvertPixelsErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    []byte
+		pitch  int
+		format PixelFormat
+		want   string
+	}{
+		{"pixel size", make([]byte, 20), 10, PixelFormat{bytesPerPixel: 5}, "unsupported pixel size"},
+		{"no palette", make([]byte, 4), 2, PixelFormat{bytesPerPixel: 1}, "without palette"},
+		{"too few bytes", make([]byte, 7), 4, RGB565, "too few"},
+		{"pitch too short", make([]byte, 8), 3, RGB565, "too few"},
+		{"color outside the palette", []byte{0, 2, 0, 0}, 2, PixelFormat{bytesPerPixel: 1, palette: [][4]uint8{{}, {}}}, "uses color 2"},
+	}
+
+	for _, test := range tests {
+		_, err := ConvertPixels(test.src, 2, 2, test.pitch, test.format)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want one about %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestImageRGBA(t *testing.T) {
+	for _, img := range []*Image{
+		{width: 1, height: 1, format: gl.RGBA, pixels: []byte{1, 2, 3, 4}},
+		{width: 1, height: 1, format: gl.BGRA, pixels: []byte{3, 2, 1, 4}},
+		{width: 1, height: 1, format: gl.RGB, pixels: []byte{1, 2, 3}},
+		{width: 1, height: 1, format: gl.BGR, pixels: []byte{3, 2, 1}},
+	} {
+		want := []byte{1, 2, 3, 4}
+		if img.format == gl.RGB || img.format == gl.BGR {
+			want[3] = 255
+		}
+		if got := img.RGBA().Pix; !bytes.Equal(got, want) {
+			t.Errorf("format %#x: got %v, want %v", img.format, got, want)
+		}
+	}
+}
+
+// Any other format is a bug, which has to say what format it was
+func TestImageRGBAUnknownFormat(t *testing.T) {
+	defer func() {
+		if err := recover(); err == nil || !strings.Contains(fmt.Sprint(err), "0x1909") {
+			t.Errorf("got panic %v, want one naming format 0x1909", err)
+		}
+	}()
+	img := &Image{width: 1, height: 1, format: gl.LUMINANCE, pixels: []byte{1}}
+	img.RGBA()
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"math"
+)
+
+// How many portals deep we look for visible sectors
+const MAX_PORTAL_DEPTH = 16
+
+// A Camera describes where the world is seen from, with the same meaning
+// as the transformations in drawGLScene
+type Camera struct {
+	pos         vec3
+	yaw, pitch  float64 // degrees around the Y and X axis, like yrot and lookupdown
+	fov, aspect float64 // vertical field of view in degrees, width / height
+	near        float64 // distance of the near clipping plane
+}
+
+// Transform a point from world space into the space of the camera, which
+// looks down -Z
+func (c *Camera) toEye(p vec3) vec3 {
+	p = p.sub(c.pos)
+
+	yaw := (360.0 - c.yaw) * math.Pi / 180.0
+	sin, cos := math.Sincos(yaw)
+	p = vec3{cos*p[0] + sin*p[2], p[1], -sin*p[0] + cos*p[2]}
+
+	pitch := c.pitch * math.Pi / 180.0
+	sin, cos = math.Sincos(pitch)
+	return vec3{p[0], cos*p[1] - sin*p[2], sin*p[1] + cos*p[2]}
+}
+
+// The direction from the camera through a point on the screen, given in
+// normalized device coordinates
+func (c *Camera) ray(x, y float64) vec3 {
+	t := math.Tan(c.fov * math.Pi / 360.0)
+	d := vec3{x * t * c.aspect, y * t, -1}
+
+	// undo the pitch, then the yaw, of toEye
+	pitch := -c.pitch * math.Pi / 180.0
+	sin, cos := math.Sincos(pitch)
+	d = vec3{d[0], cos*d[1] - sin*d[2], sin*d[1] + cos*d[2]}
+
+	yaw := -(360.0 - c.yaw) * math.Pi / 180.0
+	sin, cos = math.Sincos(yaw)
+	d = vec3{cos*d[0] + sin*d[2], d[1], -sin*d[0] + cos*d[2]}
+
+	return d.normalize()
+}
+
+// A screenRect is an area of the screen in normalized device coordinates
+type screenRect struct {
+	left, bottom, right, top float64
+}
+
+var fullScreen = screenRect{-1, -1, 1, 1}
+
+func (r screenRect) intersect(o screenRect) screenRect {
+	return screenRect{
+		math.Max(r.left, o.left), math.Max(r.bottom, o.bottom),
+		math.Min(r.right, o.right), math.Min(r.top, o.top),
+	}
+}
+
+func (r screenRect) empty() bool {
+	return r.left >= r.right || r.bottom >= r.top
+}
+
+// The corners of a portal as vectors
+func (p *Portal) corners() []vec3 {
+	corners := make([]vec3, len(p.points))
+	for i, point := range p.points {
+		corners[i] = toVec3(point)
+	}
+	return corners
+}
+
+// The part of the screen a portal covers, or false if it's out of sight
+func (c *Camera) portalRect(portal *Portal) (screenRect, bool) {
+	corners := portal.corners()
+
+	// standing in the portal, it covers everything
+	normal := polygonNormal(corners)
+	distance := c.pos.sub(corners[0]).dot(normal)
+	if math.Abs(distance) <= c.near && convexContains(corners, normal, c.pos.sub(normal.scale(distance))) {
+		return fullScreen, true
+	}
+
+	eye := make([]vec3, len(corners))
+	for i, corner := range corners {
+		eye[i] = c.toEye(corner)
+	}
+	eye = clipNear(eye, c.near)
+	if len(eye) == 0 {
+		return screenRect{}, false
+	}
+
+	// project onto the screen, just like the frustum in resizeWindow
+	f := 1.0 / math.Tan(c.fov*math.Pi/360.0)
+	rect := screenRect{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	for _, p := range eye {
+		x := p[0] * f / c.aspect / -p[2]
+		y := p[1] * f / -p[2]
+		rect.left, rect.right = math.Min(rect.left, x), math.Max(rect.right, x)
+		rect.bottom, rect.top = math.Min(rect.bottom, y), math.Max(rect.top, y)
+	}
+
+	rect = rect.intersect(fullScreen)
+	return rect, !rect.empty()
+}
+
+// Cut away the part of a polygon in eye space that is in front of the near
+// plane, so everything left can be projected
+func clipNear(points []vec3, near float64) []vec3 {
+	var clipped []vec3
+	inside := func(p vec3) bool { return p[2] <= -near }
+
+	for i, a := range points {
+		b := points[(i+1)%len(points)]
+		if inside(a) {
+			clipped = append(clipped, a)
+		}
+		if inside(a) != inside(b) {
+			t := (-near - a[2]) / (b[2] - a[2])
+			clipped = append(clipped, a.add(b.sub(a).scale(t)))
+		}
+	}
+
+	return clipped
+}
+
+// Find the sectors seen by the camera standing in sector start, by looking
+// through the portals that are on screen, and through the portals seen
+// through those, narrowing the view each time.
+func (w *World) VisibleSectors(start *Sector, camera *Camera) []*Sector {
+	if start == nil {
+		return w.sectors
+	}
+
+	visible := []*Sector{start}
+	seen := map[*Sector]bool{start: true}
+	onPath := map[*Sector]bool{start: true}
+
+	var walk func(sector *Sector, rect screenRect, depth int)
+	walk = func(sector *Sector, rect screenRect, depth int) {
+		if depth >= MAX_PORTAL_DEPTH {
+			return
+		}
+
+		for _, portal := range sector.portals {
+			// don't look back into sectors we are looking out of
+			if onPath[portal.target] {
+				continue
+			}
+
+			portalRect, ok := camera.portalRect(portal)
+			if !ok {
+				continue
+			}
+			portalRect = portalRect.intersect(rect)
+			if portalRect.empty() {
+				continue
+			}
+
+			if !seen[portal.target] {
+				seen[portal.target] = true
+				visible = append(visible, portal.target)
+			}
+
+			onPath[portal.target] = true
+			walk(portal.target, portalRect, depth+1)
+			onPath[portal.target] = false
+		}
+	}
+	walk(start, fullScreen, 0)
+
+	return visible
+}
+
+// The bounding box of all triangles in a sector
+func (s *Sector) bounds() bounds {
+	b := emptyBounds()
+	for _, triangle := range s.triangles {
+		for _, vertex := range triangle.vertices {
+			b.extend(vertex.pos())
+		}
+	}
+	return b
+}
+
+// Guess the sector a point is in, from the bounding boxes of the sectors.
+// Returns the first sector if no box contains the point.
+func (w *World) Locate(p vec3) *Sector {
+	for _, sector := range w.sectors {
+		if sector.bounds().contains(p) {
+			return sector
+		}
+	}
+	if len(w.sectors) == 0 {
+		return nil
+	}
+	return w.sectors[0]
+}
+
+// The sector we end up in when moving from one point to another, starting
+// in sector current. Walking through a portal enters its target sector.
+func (w *World) Move(current *Sector, from, to vec3) *Sector {
+	if current == nil {
+		return w.Locate(to)
+	}
+
+	for _, portal := range current.portals {
+		corners := portal.corners()
+		normal := polygonNormal(corners)
+
+		a := from.sub(corners[0]).dot(normal)
+		b := to.sub(corners[0]).dot(normal)
+		if a == b || (a < 0) == (b < 0) && a != 0 && b != 0 {
+			continue // the move doesn't cross the plane of the portal
+		}
+
+		crossing := from.add(to.sub(from).scale(a / (a - b)))
+		if convexContains(corners, normal, crossing) {
+			return portal.target
+		}
+	}
+
+	return current
+}
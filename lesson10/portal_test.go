@@ -0,0 +1,224 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// The hall, corridor and room of data/sectors.txt. The corridor leads
+// north (-z) out of the hall through a portal at z -3, and into the room
+// through one at z -9.
+func loadSectors(t *testing.T) *World {
+	world, err := SetupWorld("data/sectors.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return world
+}
+
+// A camera looking like the one in lesson10 does, yaw 0 facing north
+func testCamera(x, y, z, yaw float64) *Camera {
+	return &Camera{pos: vec3{x, y, z}, yaw: yaw, fov: FIELD_OF_VIEW, aspect: 4.0 / 3.0, near: NEAR_PLANE}
+}
+
+// The sector called name, nil if there is none
+func findSector(world *World, name string) *Sector {
+	for _, sector := range world.sectors {
+		if sector.name == name {
+			return sector
+		}
+	}
+	return nil
+}
+
+// The name of a sector, for messages
+func sectorName(sector *Sector) string {
+	if sector == nil {
+		return "no sector"
+	}
+	return sector.name
+}
+
+func sectorNames(sectors []*Sector) []string {
+	names := make([]string, len(sectors))
+	for i, sector := range sectors {
+		names[i] = sector.name
+	}
+	return names
+}
+
+func sameNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestVisibleSectors(t *testing.T) {
+	world := loadSectors(t)
+	tests := []struct {
+		name   string
+		start  string
+		camera *Camera
+		want   []string
+	}{
+		{"hall facing the corridor", "hall", testCamera(0, 0.5, 0, 0), []string{"hall", "corridor", "room"}},
+		{"hall facing away", "hall", testCamera(0, 0.5, 0, 180), []string{"hall"}},
+		{"hall facing west", "hall", testCamera(0, 0.5, 0, 90), []string{"hall"}},
+		// the corridor is seen through the portal, but not the room
+		// through the one at its end
+		{"hall, corridor off to the side", "hall", testCamera(2.5, 0.5, 0, 20), []string{"hall", "corridor"}},
+		{"corridor facing the room", "corridor", testCamera(0, 0.5, -6, 0), []string{"corridor", "room"}},
+		{"corridor facing the hall", "corridor", testCamera(0, 0.5, -6, 180), []string{"corridor", "hall"}},
+		{"room facing the corridor", "room", testCamera(0, 0.5, -12, 180), []string{"room", "corridor", "hall"}},
+		{"room facing away", "room", testCamera(0, 0.5, -10, 0), []string{"room"}},
+		// standing in a portal sees through it whichever way we face
+		{"in the portal", "hall", testCamera(0, 0.5, -3.05, 90), []string{"hall", "corridor"}},
+	}
+
+	for _, test := range tests {
+		start := findSector(world, test.start)
+		got := sectorNames(world.VisibleSectors(start, test.camera))
+		if !sameNames(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	if got := world.VisibleSectors(nil, testCamera(0, 0.5, 0, 0)); len(got) != len(world.sectors) {
+		t.Errorf("without a sector got %v, want all of them", sectorNames(got))
+	}
+}
+
+// A portal partly behind the camera covers what the part in front of the
+// near plane covers, found here by projecting many points of the portal
+func TestPortalRectNearPlane(t *testing.T) {
+	world := loadSectors(t)
+	portal := findSector(world, "hall").portals[0]
+	corners := portal.corners()
+
+	cameras := []*Camera{
+		testCamera(0.3, 0.5, -2.7, 60),
+		testCamera(-0.3, 0.4, -2.8, -50),
+		testCamera(0.6, 0.5, -2.95, 30),
+		testCamera(0, 0.5, -2.5, 0), // all in front, for comparison
+	}
+
+	for _, camera := range cameras {
+		behind := 0
+		for _, corner := range corners {
+			if camera.toEye(corner)[2] > -camera.near {
+				behind++
+			}
+		}
+
+		// the edges of the portal are 0 to 1 along a and b
+		a, b := corners[1].sub(corners[0]), corners[3].sub(corners[0])
+		want := screenRect{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+		f := 1.0 / math.Tan(camera.fov*math.Pi/360.0)
+		const N = 400
+		for i := 0; i <= N; i++ {
+			for j := 0; j <= N; j++ {
+				p := camera.toEye(corners[0].add(a.scale(float64(i) / N)).add(b.scale(float64(j) / N)))
+				if p[2] > -camera.near {
+					continue
+				}
+				x, y := p[0]*f/camera.aspect/-p[2], p[1]*f/-p[2]
+				want.left, want.right = math.Min(want.left, x), math.Max(want.right, x)
+				want.bottom, want.top = math.Min(want.bottom, y), math.Max(want.top, y)
+			}
+		}
+		want = want.intersect(fullScreen)
+
+		got, ok := camera.portalRect(portal)
+		if ok != !want.empty() {
+			t.Errorf("camera at %v with %d corners behind: got visible %v, want %v", camera.pos, behind, ok, !want.empty())
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if math.Abs(got.left-want.left) > 0.02 || math.Abs(got.right-want.right) > 0.02 ||
+			math.Abs(got.bottom-want.bottom) > 0.02 || math.Abs(got.top-want.top) > 0.02 {
+			t.Errorf("camera at %v with %d corners behind: got %v, want %v", camera.pos, behind, got, want)
+		}
+	}
+
+	// the first two cameras have to see a portal partly behind them
+	for _, camera := range cameras[:2] {
+		behind := 0
+		for _, corner := range corners {
+			if camera.toEye(corner)[2] > -camera.near {
+				behind++
+			}
+		}
+		if _, ok := camera.portalRect(portal); !ok || behind == 0 || behind == len(corners) {
+			t.Errorf("camera at %v: %d of %d corners are behind it and it sees the portal: %v", camera.pos, behind, len(corners), ok)
+		}
+	}
+}
+
+func TestMove(t *testing.T) {
+	world := loadSectors(t)
+	tests := []struct {
+		name     string
+		current  string
+		from, to vec3
+		want     string
+	}{
+		{"into the corridor", "hall", vec3{0, 0.5, -2.9}, vec3{0, 0.5, -3.1}, "corridor"},
+		{"back into the hall", "corridor", vec3{0.2, 0.3, -3.1}, vec3{0.2, 0.3, -2.9}, "hall"},
+		{"into the room", "corridor", vec3{0, 0.5, -8.9}, vec3{0, 0.5, -9.2}, "room"},
+		{"onto the portal", "hall", vec3{0, 0.5, -2.9}, vec3{0, 0.5, -3}, "corridor"},
+		{"along the wall", "hall", vec3{0.8, 0.5, -2.95}, vec3{2, 0.5, -2.95}, "hall"},
+		{"past the portal", "hall", vec3{-1, 0.5, -2.99}, vec3{1, 0.5, -2.99}, "hall"},
+		{"in the plane of the portal", "hall", vec3{1, 0.5, -3}, vec3{0, 0.5, -3}, "hall"},
+		{"through the wall beside it", "hall", vec3{0.8, 0.5, -2.9}, vec3{0.8, 0.5, -3.1}, "hall"},
+		{"over it", "hall", vec3{0, 1.2, -2.9}, vec3{0, 1.2, -3.1}, "hall"},
+		{"away from it", "hall", vec3{0, 0.5, -2.9}, vec3{0, 0.5, -2}, "hall"},
+	}
+
+	for _, test := range tests {
+		got := world.Move(findSector(world, test.current), test.from, test.to)
+		if got := sectorName(got); got != test.want {
+			t.Errorf("%s: ended up in %s, want %s", test.name, got, test.want)
+		}
+	}
+
+	if got := sectorName(world.Move(nil, vec3{}, vec3{0, 0.5, -11})); got != "room" {
+		t.Errorf("without a sector: ended up in %s, want the room", got)
+	}
+}
+
+func TestLocate(t *testing.T) {
+	world := loadSectors(t)
+	tests := []struct {
+		p    vec3
+		want string
+	}{
+		{vec3{0, 0.5, 0}, "hall"},
+		{vec3{-2.9, 0.1, 2.9}, "hall"},
+		{vec3{0, 0.5, -6}, "corridor"},
+		{vec3{0.4, 0.9, -8.9}, "corridor"},
+		{vec3{0, 0.5, -11}, "room"},
+		{vec3{1.9, 0.1, -12.9}, "room"},
+		// outside every sector the first is the best guess
+		{vec3{0, 0.5, 10}, "hall"},
+		{vec3{2, 0.5, -6}, "hall"},
+		{vec3{0, 5, -11}, "hall"},
+	}
+
+	for _, test := range tests {
+		if got := sectorName(world.Locate(test.p)); got != test.want {
+			t.Errorf("%v: got %s, want %s", test.p, got, test.want)
+		}
+	}
+
+	if got := (&World{}).Locate(vec3{}); got != nil {
+		t.Errorf("an empty world located %s", got.name)
+	}
+}
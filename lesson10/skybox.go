@@ -0,0 +1,74 @@
+package main
+
+import (
+	"github.com/banthar/gl"
+	"github.com/manveru/opengl-go-tutorials/sky"
+)
+
+// A Skybox is a cube of six textures around the camera, drawn behind
+// everything else instead of clearing to black
+type Skybox struct {
+	faces [6]gl.Texture
+}
+
+// Load a skybox from six images if path has a *, which is replaced by the
+// names of the faces, or else from one image holding them as a cross
+func LoadSkybox(path string) (*Skybox, error) {
+	faces, err := sky.Load(path, loadRGBA)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Skybox{}
+	for face, f := range faces {
+		s.faces[face] = skyTexture(f.Pixels, f.Width, f.Height)
+	}
+	return s, nil
+}
+
+// Load an image as tightly packed RGBA with ReadImage, for sky.Load
+func loadRGBA(path string) (pixels []byte, width, height int, err error) {
+	image, err := ReadImage(path)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return image.RGBA().Pix, image.width, image.height, nil
+}
+
+// Upload a face, clamped so the edges don't blend with the other side
+func skyTexture(pixels []byte, width, height int) gl.Texture {
+	textures := make([]gl.Texture, 1)
+	gl.GenTextures(textures)
+	genTexture(textures[0], &Image{width: width, height: height, format: gl.RGBA, pixels: pixels})
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	return textures[0]
+}
+
+// Draw the sky around the camera, unlit and without writing depth, so
+// everything drawn after it is in front. The modelview matrix should only
+// turn the camera and not move it, to keep the sky centred on it.
+func (s *Skybox) draw() {
+	gl.Disable(gl.LIGHTING)
+	gl.Disable(gl.DEPTH_TEST)
+	gl.DepthMask(false)
+	gl.Color4f(1.0, 1.0, 1.0, 1.0)
+
+	for face, corners := range sky.Corners {
+		gl.BindTexture(gl.TEXTURE_2D, uint(s.faces[face]))
+		gl.Begin(gl.QUADS)
+		for i, corner := range corners {
+			gl.TexCoord2f(sky.TexCoords[i][0], sky.TexCoords[i][1])
+			gl.Vertex3f(corner[0], corner[1], corner[2])
+		}
+		gl.End()
+	}
+
+	gl.DepthMask(true)
+	gl.Enable(gl.DEPTH_TEST)
+	if light {
+		gl.Enable(gl.LIGHTING)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"github.com/banthar/gl"
+	"math"
+)
+
+// A vec3 is a point or direction in world space
+type vec3 [3]float64
+
+func (a vec3) add(b vec3) vec3 { return vec3{a[0] + b[0], a[1] + b[1], a[2] + b[2]} }
+func (a vec3) sub(b vec3) vec3 { return vec3{a[0] - b[0], a[1] - b[1], a[2] - b[2]} }
+func (a vec3) scale(s float64) vec3 {
+	return vec3{a[0] * s, a[1] * s, a[2] * s}
+}
+func (a vec3) dot(b vec3) float64 { return a[0]*b[0] + a[1]*b[1] + a[2]*b[2] }
+func (a vec3) cross(b vec3) vec3 {
+	return vec3{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+func (a vec3) length() float64 { return math.Sqrt(a.dot(a)) }
+
+// a unit vector in the same direction, or zero for the zero vector
+func (a vec3) normalize() vec3 {
+	if l := a.length(); l > 0 {
+		return a.scale(1 / l)
+	}
+	return a
+}
+
+// the position of a vertex
+func (v *Vertex) pos() vec3 {
+	return vec3{float64(v.x), float64(v.y), float64(v.z)}
+}
+
+func toVec3(p [3]gl.GLfloat) vec3 {
+	return vec3{float64(p[0]), float64(p[1]), float64(p[2])}
+}
+
+// The normal of a polygon, using Newell's method so that slightly bent or
+// degenerate corners don't matter. It points to the side the corners are
+// seen counter-clockwise from.
+func polygonNormal(points []vec3) vec3 {
+	var n vec3
+	for i, a := range points {
+		b := points[(i+1)%len(points)]
+		n[0] += (a[1] - b[1]) * (a[2] + b[2])
+		n[1] += (a[2] - b[2]) * (a[0] + b[0])
+		n[2] += (a[0] - b[0]) * (a[1] + b[1])
+	}
+	return n.normalize()
+}
+
+// Whether p, which lies in the plane of the convex polygon, is inside it
+func convexContains(points []vec3, normal, p vec3) bool {
+	for i, a := range points {
+		b := points[(i+1)%len(points)]
+		if b.sub(a).cross(p.sub(a)).dot(normal) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// An axis aligned box
+type bounds struct {
+	min, max vec3
+}
+
+func emptyBounds() bounds {
+	inf := math.Inf(1)
+	return bounds{min: vec3{inf, inf, inf}, max: vec3{-inf, -inf, -inf}}
+}
+
+// grow the box to include p
+func (b *bounds) extend(p vec3) {
+	for i := range p {
+		b.min[i] = math.Min(b.min[i], p[i])
+		b.max[i] = math.Max(b.max[i], p[i])
+	}
+}
+
+func (b bounds) contains(p vec3) bool {
+	for i := range p {
+		if p[i] < b.min[i] || p[i] > b.max[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,834 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/banthar/gl"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A WorldError describes a problem found while reading a world file
+type WorldError struct {
+	Path         string // file the error was found in, if known
+	Line, Column int    // 1-based position of the problem
+	Msg          string
+}
+
+func (e *WorldError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.Path, e.Line, e.Column, e.Msg)
+}
+
+// A field is a whitespace separated word of a line, and where it starts
+type field struct {
+	text   string
+	column int
+}
+
+// Split a line into fields, dropping everything after // or #
+func splitFields(line string) []field {
+	if i := strings.Index(line, "//"); i >= 0 {
+		line = line[:i]
+	}
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+
+	return splitWords(line)
+}
+
+// Split a line into whitespace separated fields
+func splitWords(line string) []field {
+	var fields []field
+	start := -1
+	for i := 0; i <= len(line); i++ {
+		blank := i == len(line) || line[i] == ' ' || line[i] == '\t' || line[i] == '\r'
+		if blank && start >= 0 {
+			fields = append(fields, field{text: line[start:i], column: start + 1})
+			start = -1
+		} else if !blank && start < 0 {
+			start = i
+		}
+	}
+
+	return fields
+}
+
+// Load a world from a world.txt, OBJ or binary world file, depending on
+// the extension
+func LoadWorld(path string) (*World, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".obj":
+		return LoadOBJ(path)
+	case ".wld":
+		return LoadBinaryWorld(path)
+	}
+	return SetupWorld(path)
+}
+
+// Load the world from the file at path
+func SetupWorld(path string) (*World, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	world, err := ParseWorld(file, filepath.Dir(path))
+	if werr, ok := err.(*WorldError); ok {
+		werr.Path = path
+	}
+	return world, err
+}
+
+// Parse a world in the format of data/world.txt. Texture paths are
+// relative to dir.
+//
+// Every line holds either a directive, or one vertex given as "x y z u v".
+// Each three vertices in a row make up a triangle. Comments start with //
+// or # and run to the end of the line. The directives are:
+//
+//	SECTOR name                  start a new sector
+//	NUMPOLLIES n                 the current sector has n triangles
+//	PORTAL name x y z x y z ...  an opening of 3 or more corners through
+//	                             which sector name is seen
+//	MATERIAL name texture [r g b a] [blend]
+//	                             define a material, with texture - for
+//	                             none and blend one of none, alpha or
+//	                             additive
+//	USE name                     the following triangles use a material,
+//	                             default for the default material
+//	LIGHT x y z r g b [radius]   a point light baked into the lightmap,
+//	                             reaching 5 units unless radius is given
+//	FOG mode density start end [r g b]
+//	                             fog of mode none, linear, exp or exp2,
+//	                             exp and exp2 thickening by density and
+//	                             linear going from start to end, grey
+//	                             unless r g b is given
+//	DOOR name dx dy dz [speed]   the triangles up to END slide by dx dy dz
+//	                             when used with the u key or triggered
+//	LIFT name dy [speed]         the triangles up to END rise by dy when
+//	                             stood on
+//	PICKUP name                  the triangles up to END are taken when
+//	                             touched
+//	END                          end a DOOR, LIFT or PICKUP
+//	TRIGGER name target x y z x y z
+//	                             entering the box between the two
+//	                             corners opens door or lift target
+//
+// Triangles before the first SECTOR go into a sector named "default".
+// Portals only lead one way, so neighbouring sectors each need one.
+// Doors and lifts move at 1 unit per second unless speed is given.
+func ParseWorld(r io.Reader, dir string) (*World, error) {
+	p := &worldParser{world: &World{}, dir: dir, materials: map[string]*Material{}}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		p.line++
+		if err := p.parseLine(scanner.Text()); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return p.finish()
+}
+
+// state kept while reading a world file line by line
+type worldParser struct {
+	line  int
+	world *World
+	dir   string
+
+	materials map[string]*Material
+	material  *Material // used by new triangles, nil for the default
+
+	// whole line comments, kept with what follows them
+	comments []string
+	started  bool // past the comments at the top of the file
+
+	sector       *Sector // the sector being filled
+	declared     int     // triangles declared by NUMPOLLIES, -1 if none
+	declaredLine int
+
+	triangle     Triangle // the triangle being filled
+	nVertices    int      // how many vertices of triangle are set
+	triangleLine int      // the line its first vertex was on
+
+	// portals are resolved at the end, as they may lead to later sectors
+	portals []pendingPortal
+
+	entity     *Entity // the entity new triangles are part of, until END
+	entityLine int
+
+	// triggers are resolved at the end too, their targets may come later
+	triggers []pendingTrigger
+
+	fogLine int
+}
+
+type pendingPortal struct {
+	portal *Portal
+	target field
+	line   int
+}
+
+type pendingTrigger struct {
+	trigger *Entity
+	target  field
+	line    int
+}
+
+func (p *worldParser) errorf(column int, format string, a ...interface{}) error {
+	return &WorldError{Line: p.line, Column: column, Msg: fmt.Sprintf(format, a...)}
+}
+
+func (p *worldParser) parseLine(line string) error {
+	if text := strings.TrimSpace(line); strings.HasPrefix(text, "//") || strings.HasPrefix(text, "#") {
+		p.comments = append(p.comments, text)
+		return nil
+	}
+
+	fields := splitFields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	// the comments at the top describe the whole world
+	if !p.started {
+		p.world.comments = p.takeComments()
+		p.started = true
+	}
+
+	first := fields[0].text[0]
+	if first >= 'A' && first <= 'Z' || first >= 'a' && first <= 'z' {
+		return p.parseDirective(fields)
+	}
+
+	return p.parseVertex(fields)
+}
+
+// The comments read since the last call
+func (p *worldParser) takeComments() []string {
+	comments := p.comments
+	p.comments = nil
+	return comments
+}
+
+// Start a new sector, after checking the current one is complete
+func (p *worldParser) startSector(name string) error {
+	if p.entity != nil {
+		p.line = p.entityLine
+		return p.errorf(1, "%s %s has no END", entityNames[p.entity.kind], p.entity.name)
+	}
+	if err := p.endSector(); err != nil {
+		return err
+	}
+
+	p.sector = &Sector{name: name}
+	p.world.sectors = append(p.world.sectors, p.sector)
+	p.declared = -1
+	return nil
+}
+
+func (p *worldParser) endSector() error {
+	if p.nVertices != 0 {
+		p.line = p.triangleLine
+		return p.errorf(1, "triangle has only %d of 3 vertices", p.nVertices)
+	}
+
+	if p.sector == nil {
+		return nil
+	}
+
+	if p.declared >= 0 && p.declared != len(p.sector.triangles) {
+		p.line = p.declaredLine
+		return p.errorf(1, "NUMPOLLIES declares %d polygons, but %d were found", p.declared, len(p.sector.triangles))
+	}
+
+	return nil
+}
+
+// The sector things are added to, creating the default one if needed
+func (p *worldParser) currentSector() *Sector {
+	if p.sector == nil {
+		p.sector = &Sector{name: "default"}
+		p.world.sectors = append(p.world.sectors, p.sector)
+		p.declared = -1
+	}
+	return p.sector
+}
+
+func (p *worldParser) parseDirective(fields []field) error {
+	name, args := fields[0], fields[1:]
+
+	switch name.text {
+	case "SECTOR":
+		if len(args) != 1 {
+			return p.errorf(name.column, "SECTOR takes 1 argument, got %d", len(args))
+		}
+		if p.world.Sector(args[0].text) != nil {
+			return p.errorf(args[0].column, "sector %q already exists", args[0].text)
+		}
+		if err := p.startSector(args[0].text); err != nil {
+			return err
+		}
+		p.sector.comments = p.takeComments()
+	case "NUMPOLLIES":
+		p.currentSector()
+		if p.declared >= 0 {
+			return p.errorf(name.column, "NUMPOLLIES already given on line %d", p.declaredLine)
+		}
+		if len(args) != 1 {
+			return p.errorf(name.column, "NUMPOLLIES takes 1 argument, got %d", len(args))
+		}
+		n, err := strconv.Atoi(args[0].text)
+		if err != nil || n < 0 {
+			return p.errorf(args[0].column, "invalid polygon count %q", args[0].text)
+		}
+		p.declared, p.declaredLine = n, p.line
+	case "PORTAL":
+		if len(args) < 10 || (len(args)-1)%3 != 0 {
+			return p.errorf(name.column, "PORTAL takes a sector and at least 3 corners of x y z")
+		}
+		portal := &Portal{}
+		for i := 1; i < len(args); i += 3 {
+			var point [3]gl.GLfloat
+			for c := range point {
+				value, ok := parseNumber(args[i+c].text)
+				if !ok {
+					return p.errorf(args[i+c].column, "invalid number %q", args[i+c].text)
+				}
+				point[c] = gl.GLfloat(value)
+			}
+			portal.points = append(portal.points, point)
+		}
+		sector := p.currentSector()
+		sector.portals = append(sector.portals, portal)
+		p.portals = append(p.portals, pendingPortal{portal, args[0], p.line})
+	case "MATERIAL":
+		return p.parseMaterial(name, args)
+	case "DOOR", "LIFT", "PICKUP", "TRIGGER":
+		return p.parseEntity(name, args)
+	case "END":
+		if len(args) != 0 {
+			return p.errorf(name.column, "END takes no arguments, got %d", len(args))
+		}
+		if p.entity == nil {
+			return p.errorf(name.column, "END without DOOR, LIFT or PICKUP")
+		}
+		if p.nVertices != 0 {
+			return p.errorf(name.column, "triangle has only %d of 3 vertices", p.nVertices)
+		}
+		if len(p.entity.triangles) == 0 {
+			return p.errorf(name.column, "%s %s has no triangles", entityNames[p.entity.kind], p.entity.name)
+		}
+		p.entity = nil
+	case "LIGHT":
+		if len(args) != 6 && len(args) != 7 {
+			return p.errorf(name.column, "LIGHT takes a position of x y z, a color of r g b and optionally a radius")
+		}
+		values := make([]float64, len(args))
+		for i, arg := range args {
+			value, ok := parseNumber(arg.text)
+			if !ok {
+				return p.errorf(arg.column, "invalid number %q", arg.text)
+			}
+			values[i] = value
+		}
+		light := &Light{
+			pos:      vec3{values[0], values[1], values[2]},
+			color:    vec3{values[3], values[4], values[5]},
+			radius:   DEFAULT_LIGHT_RADIUS,
+			comments: p.takeComments(),
+		}
+		if len(values) == 7 {
+			if values[6] <= 0 {
+				return p.errorf(args[6].column, "light radius must be positive, got %s", args[6].text)
+			}
+			light.radius = values[6]
+		}
+		p.world.lights = append(p.world.lights, light)
+	case "FOG":
+		return p.parseFog(name, args)
+	case "USE":
+		if len(args) != 1 {
+			return p.errorf(name.column, "USE takes 1 argument, got %d", len(args))
+		}
+		if args[0].text == "default" {
+			p.material = nil
+			break
+		}
+		material, ok := p.materials[args[0].text]
+		if !ok {
+			return p.errorf(args[0].column, "unknown material %q", args[0].text)
+		}
+		p.material = material
+	default:
+		return p.errorf(name.column, "unknown directive %q", name.text)
+	}
+
+	return nil
+}
+
+// MATERIAL name texture [r g b a] [blend]
+func (p *worldParser) parseMaterial(name field, args []field) error {
+	switch len(args) {
+	case 2, 3, 6, 7:
+	default:
+		return p.errorf(name.column, "MATERIAL takes a name, a texture, and optionally a color of r g b a and a blend mode")
+	}
+
+	if args[0].text == "default" {
+		return p.errorf(args[0].column, "the default material can't be redefined")
+	}
+	if _, ok := p.materials[args[0].text]; ok {
+		return p.errorf(args[0].column, "material %q already exists", args[0].text)
+	}
+
+	material := &Material{name: args[0].text, color: [4]gl.GLfloat{1, 1, 1, 1}}
+	if args[1].text != "-" {
+		material.texture = filepath.Join(p.dir, filepath.FromSlash(args[1].text))
+	}
+
+	rest := args[2:]
+	if len(rest) >= 4 {
+		for i := range material.color {
+			value, ok := parseNumber(rest[i].text)
+			if !ok {
+				return p.errorf(rest[i].column, "invalid number %q", rest[i].text)
+			}
+			material.color[i] = gl.GLfloat(value)
+		}
+		rest = rest[4:]
+	}
+
+	if len(rest) == 1 {
+		material.blend = -1
+		for mode, blendName := range blendNames {
+			if rest[0].text == blendName {
+				material.blend = mode
+			}
+		}
+		if material.blend < 0 {
+			return p.errorf(rest[0].column, "unknown blend mode %q", rest[0].text)
+		}
+	}
+
+	p.materials[material.name] = material
+	return nil
+}
+
+// FOG mode density start end [r g b]
+func (p *worldParser) parseFog(name field, args []field) error {
+	if p.world.fog != nil {
+		return p.errorf(name.column, "FOG already given on line %d", p.fogLine)
+	}
+	if len(args) != 4 && len(args) != 7 {
+		return p.errorf(name.column, "FOG takes a mode, a density, a start and an end, and optionally a color of r g b")
+	}
+
+	fog := defaultFog
+	fog.mode = -1
+	for mode, fogName := range fogNames {
+		if args[0].text == fogName {
+			fog.mode = mode
+		}
+	}
+	if fog.mode < 0 {
+		return p.errorf(args[0].column, "unknown fog mode %q", args[0].text)
+	}
+
+	values := make([]float64, len(args)-1)
+	for i, arg := range args[1:] {
+		value, ok := parseNumber(arg.text)
+		if !ok {
+			return p.errorf(arg.column, "invalid number %q", arg.text)
+		}
+		values[i] = value
+	}
+	if values[0] < 0 {
+		return p.errorf(args[1].column, "fog density can't be negative, got %s", args[1].text)
+	}
+	if values[2] <= values[1] {
+		return p.errorf(args[3].column, "fog must end after it starts at %s", args[2].text)
+	}
+	fog.density, fog.start, fog.end = values[0], values[1], values[2]
+	if len(values) == 6 {
+		fog.color = [3]float32{float32(values[3]), float32(values[4]), float32(values[5])}
+	}
+
+	fog.comments = p.takeComments()
+	p.world.fog, p.fogLine = &fog, p.line
+	return nil
+}
+
+// DOOR name dx dy dz [speed], LIFT name dy [speed], PICKUP name or
+// TRIGGER name target x y z x y z
+func (p *worldParser) parseEntity(name field, args []field) error {
+	if p.entity != nil {
+		return p.errorf(name.column, "%s inside %s %s", name.text, entityNames[p.entity.kind], p.entity.name)
+	}
+
+	e := &Entity{speed: DEFAULT_MOVER_SPEED}
+	var min, max int // arguments allowed
+	var usage string
+	switch name.text {
+	case "DOOR":
+		e.kind, min, max = ENTITY_DOOR, 4, 5
+		usage = "DOOR takes a name, a move of dx dy dz and optionally a speed"
+	case "LIFT":
+		e.kind, min, max = ENTITY_LIFT, 2, 3
+		usage = "LIFT takes a name, a height and optionally a speed"
+	case "PICKUP":
+		e.kind, min, max = ENTITY_PICKUP, 1, 1
+		usage = "PICKUP takes a name"
+	case "TRIGGER":
+		e.kind, min, max = ENTITY_TRIGGER, 8, 8
+		usage = "TRIGGER takes a name, a target and two corners of x y z"
+	}
+	if len(args) < min || len(args) > max {
+		return p.errorf(name.column, "%s", usage)
+	}
+
+	e.name = args[0].text
+	if p.world.Entity(e.name) != nil {
+		return p.errorf(args[0].column, "entity %q already exists", e.name)
+	}
+
+	// the numbers after the name, and the target of a trigger
+	rest := args[1:]
+	if e.kind == ENTITY_TRIGGER {
+		rest = args[2:]
+	}
+	values := make([]float64, len(rest))
+	for i, arg := range rest {
+		value, ok := parseNumber(arg.text)
+		if !ok {
+			return p.errorf(arg.column, "invalid number %q", arg.text)
+		}
+		values[i] = value
+	}
+
+	switch e.kind {
+	case ENTITY_DOOR:
+		e.move = vec3{values[0], values[1], values[2]}
+		values = values[3:]
+	case ENTITY_LIFT:
+		e.move = vec3{0, values[0], 0}
+		values = values[1:]
+	case ENTITY_TRIGGER:
+		e.box = emptyBounds()
+		e.box.extend(vec3{values[0], values[1], values[2]})
+		e.box.extend(vec3{values[3], values[4], values[5]})
+		values = nil
+	}
+	if (e.kind == ENTITY_DOOR || e.kind == ENTITY_LIFT) && e.move.length() == 0 {
+		return p.errorf(args[1].column, "%s %s doesn't move", name.text, e.name)
+	}
+	if len(values) == 1 {
+		if values[0] <= 0 {
+			return p.errorf(args[len(args)-1].column, "speed must be positive, got %s", args[len(args)-1].text)
+		}
+		e.speed = values[0]
+	}
+
+	e.sector = p.currentSector()
+	e.comments = p.takeComments()
+	p.world.entities = append(p.world.entities, e)
+
+	if e.kind == ENTITY_TRIGGER {
+		p.triggers = append(p.triggers, pendingTrigger{e, args[1], p.line})
+	} else {
+		p.entity, p.entityLine = e, p.line
+	}
+	return nil
+}
+
+func (p *worldParser) parseVertex(fields []field) error {
+	if len(fields) < 5 {
+		return p.errorf(fields[0].column, "vertex needs 5 numbers (x y z u v), got %d", len(fields))
+	}
+	if len(fields) > 5 {
+		return p.errorf(fields[5].column, "vertex needs 5 numbers (x y z u v), got %d", len(fields))
+	}
+
+	var values [5]float64
+	for i, f := range fields {
+		value, ok := parseNumber(f.text)
+		if !ok {
+			return p.errorf(f.column, "invalid number %q", f.text)
+		}
+		values[i] = value
+	}
+
+	if p.nVertices == 0 {
+		p.triangle = Triangle{material: p.material, comments: p.takeComments()}
+		p.triangleLine = p.line
+	}
+
+	p.triangle.vertices[p.nVertices] = &Vertex{
+		x: gl.GLfloat(values[0]), y: gl.GLfloat(values[1]), z: gl.GLfloat(values[2]),
+		u: gl.GLfloat(values[3]), v: gl.GLfloat(values[4]),
+	}
+	p.nVertices++
+
+	if p.nVertices == 3 {
+		triangle := p.triangle
+		sector := p.currentSector()
+		sector.triangles = append(sector.triangles, &triangle)
+		if p.entity != nil {
+			triangle.entity = p.entity
+			p.entity.triangles = append(p.entity.triangles, &triangle)
+		}
+		p.nVertices = 0
+	}
+
+	return nil
+}
+
+// check the world is complete once all lines are read
+func (p *worldParser) finish() (*World, error) {
+	if p.entity != nil {
+		p.line = p.entityLine
+		return nil, p.errorf(1, "%s %s has no END", entityNames[p.entity.kind], p.entity.name)
+	}
+	if err := p.endSector(); err != nil {
+		return nil, err
+	}
+
+	for _, pending := range p.triggers {
+		target := p.world.Entity(pending.target.text)
+		if target == nil || target.kind != ENTITY_DOOR && target.kind != ENTITY_LIFT {
+			p.line = pending.line
+			return nil, p.errorf(pending.target.column, "trigger target %q is no door or lift", pending.target.text)
+		}
+		pending.trigger.target = target
+	}
+
+	for _, pending := range p.portals {
+		pending.portal.target = p.world.Sector(pending.target.text)
+		if pending.portal.target == nil {
+			p.line = pending.line
+			return nil, p.errorf(pending.target.column, "portal to unknown sector %q", pending.target.text)
+		}
+	}
+
+	// world files have no normals, so lighting needs them computed
+	p.world.ComputeNormals(creaseAngle)
+
+	return p.world, nil
+}
+
+// Write a world in the format of data/world.txt, which ParseWorld reads
+// back. Comments kept from the file it was read from are written before
+// the world, sector or triangle they were found before. Texture paths are
+// made relative to dir, the directory the file is written to.
+func WriteWorld(w io.Writer, world *World, dir string) error {
+	var buf bytes.Buffer
+	writeComments := func(comments []string) {
+		for _, comment := range comments {
+			fmt.Fprintln(&buf, comment)
+		}
+	}
+
+	writeComments(world.comments)
+	if len(world.comments) > 0 {
+		fmt.Fprintln(&buf)
+	}
+
+	// the default material is always there, all others are defined first
+	triangles := world.Triangles()
+	for _, e := range world.entities {
+		if e.taken {
+			triangles = append(triangles, e.triangles...)
+		}
+	}
+	byName := map[string]*Material{"default": defaultMaterial}
+	var materials []*Material
+	for _, material := range usedMaterials(triangles) {
+		if material == defaultMaterial {
+			continue
+		}
+		if other, ok := byName[material.name]; ok && other != material {
+			return fmt.Errorf("there is more than one material named %q", material.name)
+		}
+		byName[material.name] = material
+		materials = append(materials, material)
+	}
+
+	for _, material := range materials {
+		texture := "-"
+		if material.texture != "" {
+			relative, err := relativePath(dir, material.texture)
+			if err != nil {
+				return err
+			}
+			texture = filepath.ToSlash(relative)
+		}
+
+		fmt.Fprintf(&buf, "MATERIAL %s %s", material.name, texture)
+		c := material.color
+		if c != [4]gl.GLfloat{1, 1, 1, 1} || material.blend != BLEND_NONE {
+			fmt.Fprintf(&buf, " %s %s %s %s", formatNumber(c[0]), formatNumber(c[1]), formatNumber(c[2]), formatNumber(c[3]))
+		}
+		if material.blend != BLEND_NONE {
+			fmt.Fprintf(&buf, " %s", blendNames[material.blend])
+		}
+		fmt.Fprintln(&buf)
+	}
+	if len(materials) > 0 {
+		fmt.Fprintln(&buf)
+	}
+
+	for _, light := range world.lights {
+		writeComments(light.comments)
+		fmt.Fprint(&buf, "LIGHT")
+		for _, value := range []float64{
+			light.pos[0], light.pos[1], light.pos[2],
+			light.color[0], light.color[1], light.color[2],
+		} {
+			fmt.Fprintf(&buf, " %s", formatNumber(gl.GLfloat(value)))
+		}
+		if light.radius != DEFAULT_LIGHT_RADIUS {
+			fmt.Fprintf(&buf, " %s", formatNumber(gl.GLfloat(light.radius)))
+		}
+		fmt.Fprintln(&buf)
+	}
+	if len(world.lights) > 0 {
+		fmt.Fprintln(&buf)
+	}
+
+	if fog := world.fog; fog != nil {
+		writeComments(fog.comments)
+		fmt.Fprintf(&buf, "FOG %s", fogNames[fog.mode])
+		for _, value := range []float64{fog.density, fog.start, fog.end} {
+			fmt.Fprintf(&buf, " %s", formatNumber(gl.GLfloat(value)))
+		}
+		if fog.color != defaultFog.color {
+			for _, c := range fog.color {
+				fmt.Fprintf(&buf, " %s", formatNumber(gl.GLfloat(c)))
+			}
+		}
+		fmt.Fprint(&buf, "\n\n")
+	}
+
+	// a lone default sector needs no SECTOR line, like data/world.txt
+	named := len(world.sectors) != 1 || world.sectors[0].name != "default" || len(world.sectors[0].portals) > 0
+
+	current := defaultMaterial
+	writeTriangle := func(triangle *Triangle, offset vec3) {
+		if material := triangleMaterial(triangle); material != current {
+			name := material.name
+			if material == defaultMaterial {
+				name = "default"
+			}
+			fmt.Fprintf(&buf, "USE %s\n\n", name)
+			current = material
+		}
+
+		writeComments(triangle.comments)
+		for _, v := range triangle.vertices {
+			fmt.Fprintf(&buf, "%5s %5s %5s %5s %5s\n",
+				formatNumber(v.x-gl.GLfloat(offset[0])), formatNumber(v.y-gl.GLfloat(offset[1])),
+				formatNumber(v.z-gl.GLfloat(offset[2])), formatNumber(v.u), formatNumber(v.v))
+		}
+		fmt.Fprintln(&buf)
+	}
+
+	for _, sector := range world.sectors {
+		// entities are written after the other triangles, as they were
+		// before they moved or were taken
+		var plain []*Triangle
+		for _, triangle := range sector.triangles {
+			if triangle.entity == nil {
+				plain = append(plain, triangle)
+			}
+		}
+		var entities []*Entity
+		count := len(plain)
+		for _, e := range world.entities {
+			if e.sector == sector {
+				entities = append(entities, e)
+				count += len(e.triangles)
+			}
+		}
+
+		writeComments(sector.comments)
+		if named {
+			fmt.Fprintf(&buf, "SECTOR %s\n", sector.name)
+		}
+		fmt.Fprintf(&buf, "NUMPOLLIES %d\n", count)
+		for _, portal := range sector.portals {
+			fmt.Fprintf(&buf, "PORTAL %s", portal.target.name)
+			for _, point := range portal.points {
+				fmt.Fprintf(&buf, "  %s %s %s", formatNumber(point[0]), formatNumber(point[1]), formatNumber(point[2]))
+			}
+			fmt.Fprintln(&buf)
+		}
+		fmt.Fprintln(&buf)
+
+		for _, triangle := range plain {
+			writeTriangle(triangle, vec3{})
+		}
+
+		for _, e := range entities {
+			writeComments(e.comments)
+			fmt.Fprintf(&buf, "%s %s", entityNames[e.kind], e.name)
+			var numbers []float64
+			switch e.kind {
+			case ENTITY_DOOR:
+				numbers = e.move[:]
+			case ENTITY_LIFT:
+				numbers = e.move[1:2]
+			case ENTITY_TRIGGER:
+				fmt.Fprintf(&buf, " %s", e.target.name)
+				numbers = append(e.box.min[:], e.box.max[:]...)
+			}
+			if (e.kind == ENTITY_DOOR || e.kind == ENTITY_LIFT) && e.speed != DEFAULT_MOVER_SPEED {
+				numbers = append(numbers[:len(numbers):len(numbers)], e.speed)
+			}
+			for _, number := range numbers {
+				fmt.Fprintf(&buf, " %s", formatNumber(gl.GLfloat(number)))
+			}
+			fmt.Fprint(&buf, "\n\n")
+
+			if e.kind == ENTITY_TRIGGER {
+				continue
+			}
+			for _, triangle := range e.triangles {
+				writeTriangle(triangle, e.move.scale(e.position))
+			}
+			fmt.Fprint(&buf, "END\n\n")
+		}
+	}
+
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// Parse a number of a world or OBJ file. Only finite numbers are valid,
+// NaN and infinities couldn't be written back.
+func parseNumber(text string) (float64, bool) {
+	value, err := strconv.ParseFloat(text, 32)
+	return value, err == nil && !math.IsNaN(value) && !math.IsInf(value, 0)
+}
+
+// Format a number as short as possible, but always with a decimal point
+func formatNumber(f gl.GLfloat) string {
+	s := strconv.FormatFloat(float64(f), 'f', -1, 32)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
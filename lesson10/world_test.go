@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// a room of two triangles, the smallest world worth writing down
+const twoTriangles = `
+-1 0 -1 0 1
+-1 0 1 0 0
+1 0 1 1 0
+-1 0 -1 0 1
+1 0 -1 1 1
+1 0 1 1 0
+`
+
+func TestParseWorld(t *testing.T) {
+	tests := []struct {
+		name      string
+		src       string
+		sectors   int
+		triangles int // in the first sector
+		lights    int
+		entities  int
+		fog       bool
+	}{
+		{"triangles", twoTriangles, 1, 2, 0, 0, false},
+		{"comments and blank lines", "// only\n#comments\n\n  \n" + twoTriangles + "// after\n", 1, 2, 0, 0, false},
+		{"comment after a vertex", "0 0 0 0 0 # here\n1 0 0 1 0 // there\n0 0 1 0 1\n", 1, 1, 0, 0, false},
+		{"polygon count", "NUMPOLLIES 2\n" + twoTriangles, 1, 2, 0, 0, false},
+		{"sectors", "SECTOR a\n" + twoTriangles + "SECTOR b\n" + twoTriangles +
+			"PORTAL b 0 0 0 1 0 0 0 1 0\n", 2, 2, 0, 0, false},
+		{"light", "LIGHT 0 0.5 0 1 1 1\nLIGHT 1 1 1 1 0 0 2.5\n" + twoTriangles, 1, 2, 2, 0, false},
+		{"fog", "FOG linear 0.1 1 10 0.5 0.5 0.5\n" + twoTriangles, 1, 2, 0, 0, true},
+		{"door", "DOOR gate 0 1 0\n" + twoTriangles + "END\nTRIGGER t gate -1 0 -1 1 1 1\n", 1, 2, 0, 2, false},
+		{"lift and pickup", "LIFT up 1 2\n" + twoTriangles + "END\nPICKUP coin\n0 0 0 0 0\n1 0 0 1 0\n0 0 1 0 1\nEND\n", 1, 3, 0, 2, false},
+		{"material", "MATERIAL glass - 0.5 0.5 1 0.5 alpha\nUSE glass\n" + twoTriangles + "USE default\n", 1, 2, 0, 0, false},
+	}
+
+	world, err := ParseWorld(strings.NewReader(""), "data")
+	if err != nil || len(world.sectors) != 0 {
+		t.Errorf("empty: got %v sectors and error %v, want none", len(world.sectors), err)
+	}
+
+	for _, test := range tests {
+		world, err := ParseWorld(strings.NewReader(test.src), "data")
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if len(world.sectors) != test.sectors {
+			t.Errorf("%s: got %d sectors, want %d", test.name, len(world.sectors), test.sectors)
+			continue
+		}
+		if got := len(world.sectors[0].triangles); got != test.triangles {
+			t.Errorf("%s: got %d triangles, want %d", test.name, got, test.triangles)
+		}
+		if len(world.lights) != test.lights {
+			t.Errorf("%s: got %d lights, want %d", test.name, len(world.lights), test.lights)
+		}
+		if len(world.entities) != test.entities {
+			t.Errorf("%s: got %d entities, want %d", test.name, len(world.entities), test.entities)
+		}
+		if (world.fog != nil) != test.fog {
+			t.Errorf("%s: got fog %v, want %v", test.name, world.fog != nil, test.fog)
+		}
+	}
+}
+
+func TestParseWorldErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string // the start of the error, with its line and column
+	}{
+		{"bad number", "0 0 0 0 x\n", "1:9: invalid number"},
+		{"NaN", "0 0 NaN 0 0\n", "1:5: invalid number"},
+		{"infinity", "\n0 0 0 +Inf 0\n", "2:7: invalid number"},
+		{"too large", "1e39 0 0 0 0\n", "1:1: invalid number"},
+		{"NaN light", "LIGHT 0 0 0 1 1 nan\n", "1:17: invalid number"},
+		{"NaN fog", "FOG exp NaN 1 10\n", "1:9: invalid number"},
+		{"NaN portal", "PORTAL a 0 0 0 1 0 0 0 inf 0\n", "1:24: invalid number"},
+		{"NaN material", "MATERIAL m - 1 1 NaN 1\n", "1:18: invalid number"},
+		{"NaN door", "DOOR d 0 NaN 0\n", "1:10: invalid number"},
+		{"short vertex", "0 0 0 0\n", "1:1: vertex needs 5 numbers"},
+		{"long vertex", "0 0 0 0 0 0\n", "1:11: vertex needs 5 numbers"},
+		{"half a triangle", "0 0 0 0 0\n1 0 0 0 0\n", "1:1: triangle has only 2 of 3 vertices"},
+		{"polygon count", "NUMPOLLIES 3\n" + twoTriangles, "1:1: NUMPOLLIES declares 3 polygons, but 2 were found"},
+		{"bad polygon count", "NUMPOLLIES many\n", "1:12: invalid polygon count"},
+		{"unknown directive", "\n  JUMP 1\n", "2:3: unknown directive"},
+		{"duplicate sector", "SECTOR a\nSECTOR a\n", "2:8: sector \"a\" already exists"},
+		{"unknown portal", "PORTAL nowhere 0 0 0 1 0 0 0 1 0\n", "1:8: portal to unknown sector"},
+		{"unknown material", "USE gold\n", "1:5: unknown material"},
+		{"negative fog", "FOG exp -1 1 10\n", "1:9: fog density can't be negative"},
+		{"door without END", "DOOR d 0 1 0\n" + twoTriangles, "1:1: DOOR d has no END"},
+		{"empty door", "DOOR d 0 1 0\nEND\n", "2:1: DOOR d has no triangles"},
+	}
+
+	for _, test := range tests {
+		_, err := ParseWorld(strings.NewReader(test.src), "data")
+		if err == nil || !strings.HasPrefix(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestSetupWorldErrorPath(t *testing.T) {
+	path := t.TempDir() + "/broken.txt"
+	if err := os.WriteFile(path, []byte("0 0 NaN 0 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := SetupWorld(path)
+	if err == nil || !strings.HasPrefix(err.Error(), path+":1:5: ") {
+		t.Errorf("got error %v, want one at %s:1:5", err, path)
+	}
+}
+
+// Writing a world and reading it back has to give the same world, which
+// is written the same way again.
+func TestWriteWorld(t *testing.T) {
+	for _, name := range []string{"world.txt", "sectors.txt", "levels.txt"} {
+		world, err := SetupWorld("data/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkRoundTrip(t, name, world)
+	}
+}
+
+func FuzzParseWorld(f *testing.F) {
+	for _, name := range []string{"world.txt", "sectors.txt", "levels.txt"} {
+		src, err := os.ReadFile("data/" + name)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(src))
+	}
+	f.Add(twoTriangles)
+	f.Add("FOG exp2 0.5 1 2\nLIGHT 0 1 0 1 1 1 3\nDOOR d 0 1 0 2\n" + twoTriangles + "END\n")
+
+	f.Fuzz(func(t *testing.T, src string) {
+		world, err := ParseWorld(strings.NewReader(src), "data")
+		if err != nil {
+			return
+		}
+		checkRoundTrip(t, "fuzz", world)
+	})
+}
+
+// Write world, parse it and write it again, failing unless both writes
+// are the same
+func checkRoundTrip(t *testing.T, name string, world *World) {
+	var first bytes.Buffer
+	if err := WriteWorld(&first, world, "data"); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+
+	again, err := ParseWorld(bytes.NewReader(first.Bytes()), "data")
+	if err != nil {
+		t.Fatalf("%s: can't read the written world back: %v\n%s", name, err, first.String())
+	}
+
+	var second bytes.Buffer
+	if err := WriteWorld(&second, again, "data"); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Errorf("%s: the world changed after writing it twice:\n%s\n----\n%s", name, first.String(), second.String())
+	}
+}
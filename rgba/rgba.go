@@ -0,0 +1,106 @@
+// Package rgba turns images and SDL surfaces into tightly packed RGBA
+// pixels, and gives them an alpha channel, for the lessons that blend their
+// textures.
+package rgba
+
+import (
+	"fmt"
+	"github.com/banthar/Go-SDL/sdl"
+)
+
+// How to generate the alpha channel of a texture
+const (
+	ALPHA_NONE      = iota // keep the alpha of the image, opaque if it has none
+	ALPHA_COLORKEY         // pixels matching a color key become transparent
+	ALPHA_LUMINANCE        // alpha is the brightness of the pixel
+)
+
+// Load an image file as tightly packed RGBA, with its size
+func Load(path string) (pixels []byte, width, height int, err error) {
+	image := sdl.Load(path)
+	if image == nil {
+		return nil, 0, 0, fmt.Errorf("%s: %s", path, sdl.GetError())
+	}
+	defer image.Free()
+
+	if n := image.Format.BytesPerPixel; n != 4 && n != 3 {
+		return nil, 0, 0, fmt.Errorf("%s: not a truecolor image", path)
+	}
+	return FromSurface(image), int(image.W), int(image.H), nil
+}
+
+// Copy the pixels of a truecolor surface into a tightly packed RGBA slice,
+// using the channel masks of the surface to find each component.
+func FromSurface(image *sdl.Surface) []byte {
+	format := image.Format
+	bpp := int(format.BytesPerPixel)
+	width, height, pitch := int(image.W), int(image.H), int(image.Pitch)
+
+	src := (*[1 << 30]byte)(image.Pixels)[: pitch*height : pitch*height]
+	dst := make([]byte, width*height*4)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			// SDL stores pixels in native (little endian) byte order
+			var pixel uint32
+			offset := y*pitch + x*bpp
+			for b := 0; b < bpp; b++ {
+				pixel |= uint32(src[offset+b]) << uint(8*b)
+			}
+
+			i := (y*width + x) * 4
+			dst[i+0] = uint8((pixel & format.Rmask) >> format.Rshift << format.Rloss)
+			dst[i+1] = uint8((pixel & format.Gmask) >> format.Gshift << format.Gloss)
+			dst[i+2] = uint8((pixel & format.Bmask) >> format.Bshift << format.Bloss)
+			if format.Amask == 0 {
+				dst[i+3] = 255
+			} else {
+				dst[i+3] = uint8((pixel & format.Amask) >> format.Ashift << format.Aloss)
+			}
+		}
+	}
+
+	return dst
+}
+
+// Generate the alpha channel of pixels as mode says, key being the color
+// ALPHA_COLORKEY makes transparent
+func GenerateAlpha(pixels []byte, mode int, key [3]uint8) {
+	switch mode {
+	case ALPHA_COLORKEY:
+		ColorKeyAlpha(pixels, key)
+	case ALPHA_LUMINANCE:
+		LuminanceAlpha(pixels)
+	}
+}
+
+// Make every pixel that matches key fully transparent, and all others opaque
+func ColorKeyAlpha(pixels []byte, key [3]uint8) {
+	for i := 0; i < len(pixels); i += 4 {
+		if pixels[i] == key[0] && pixels[i+1] == key[1] && pixels[i+2] == key[2] {
+			pixels[i+3] = 0
+		} else {
+			pixels[i+3] = 255
+		}
+	}
+}
+
+// Use the brightness of every pixel as its alpha, so black is transparent
+func LuminanceAlpha(pixels []byte) {
+	for i := 0; i < len(pixels); i += 4 {
+		r, g, b := uint32(pixels[i]), uint32(pixels[i+1]), uint32(pixels[i+2])
+		// Rec. 601 luma weights, scaled by 1000
+		pixels[i+3] = uint8((299*r + 587*g + 114*b) / 1000)
+	}
+}
+
+// Multiply the color of every pixel with its alpha, for blending with a
+// source factor of one
+func Premultiply(pixels []byte) {
+	for i := 0; i < len(pixels); i += 4 {
+		a := uint32(pixels[i+3])
+		pixels[i+0] = uint8(uint32(pixels[i+0]) * a / 255)
+		pixels[i+1] = uint8(uint32(pixels[i+1]) * a / 255)
+		pixels[i+2] = uint8(uint32(pixels[i+2]) * a / 255)
+	}
+}
@@ -0,0 +1,70 @@
+package rgba
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGenerateAlpha(t *testing.T) {
+	magenta := [3]uint8{255, 0, 255}
+	tests := []struct {
+		name string
+		mode int
+		key  [3]uint8
+		in   []byte
+		want []byte
+	}{
+		{"none keeps the alpha", ALPHA_NONE, magenta,
+			[]byte{255, 0, 255, 77, 10, 20, 30, 0},
+			[]byte{255, 0, 255, 77, 10, 20, 30, 0}},
+		{"key match", ALPHA_COLORKEY, magenta,
+			[]byte{255, 0, 255, 77},
+			[]byte{255, 0, 255, 0}},
+		{"key near miss", ALPHA_COLORKEY, magenta,
+			[]byte{254, 0, 255, 0, 255, 1, 255, 0, 255, 0, 254, 0},
+			[]byte{254, 0, 255, 255, 255, 1, 255, 255, 255, 0, 254, 255}},
+		{"black key", ALPHA_COLORKEY, [3]uint8{0, 0, 0},
+			[]byte{0, 0, 0, 255, 1, 1, 1, 0},
+			[]byte{0, 0, 0, 0, 1, 1, 1, 255}},
+		{"black and white", ALPHA_LUMINANCE, magenta,
+			[]byte{0, 0, 0, 255, 255, 255, 255, 0},
+			[]byte{0, 0, 0, 0, 255, 255, 255, 255}},
+		{"grey", ALPHA_LUMINANCE, magenta,
+			[]byte{128, 128, 128, 255, 1, 1, 1, 255},
+			[]byte{128, 128, 128, 128, 1, 1, 1, 1}},
+		{"luma weights", ALPHA_LUMINANCE, magenta,
+			[]byte{255, 0, 0, 0, 0, 255, 0, 0, 0, 0, 255, 0},
+			[]byte{255, 0, 0, 76, 0, 255, 0, 149, 0, 0, 255, 29}},
+	}
+
+	for _, test := range tests {
+		pixels := append([]byte(nil), test.in...)
+		GenerateAlpha(pixels, test.mode, test.key)
+		if !bytes.Equal(pixels, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, pixels, test.want)
+		}
+	}
+}
+
+// Premultiplying rounds down, so a color never gets brighter than its alpha
+// allows
+func TestPremultiply(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		want []byte
+	}{
+		{"opaque", []byte{200, 100, 50, 255}, []byte{200, 100, 50, 255}},
+		{"transparent", []byte{200, 100, 50, 0}, []byte{0, 0, 0, 0}},
+		{"half", []byte{255, 128, 1, 128}, []byte{128, 64, 0, 128}},
+		{"rounding", []byte{255, 254, 3, 254}, []byte{254, 253, 2, 254}},
+	}
+
+	for _, test := range tests {
+		pixels := append([]byte(nil), test.in...)
+		Premultiply(pixels)
+		if !bytes.Equal(pixels, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, pixels, test.want)
+		}
+	}
+}
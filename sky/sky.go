@@ -0,0 +1,111 @@
+// Package sky finds the six faces of a skybox in its images, for the
+// lessons that draw one around the camera. Loading the images and drawing
+// the faces is up to each lesson.
+package sky
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The faces of a skybox. The front is what the camera looks at before it
+// turns, down -z.
+const (
+	FRONT = iota
+	BACK
+	LEFT
+	RIGHT
+	TOP
+	BOTTOM
+)
+
+// the names of the faces, which replace the * in the path of six images
+var faceNames = []string{
+	FRONT:  "front",
+	BACK:   "back",
+	LEFT:   "left",
+	RIGHT:  "right",
+	TOP:    "top",
+	BOTTOM: "bottom",
+}
+
+// where the faces are in a cube map image, counted in faces from the top
+// left of a cross of four by three:
+//
+//	     top
+//	left front right back
+//	     bottom
+var cross = [][2]int{
+	FRONT:  {1, 1},
+	BACK:   {3, 1},
+	LEFT:   {0, 1},
+	RIGHT:  {2, 1},
+	TOP:    {1, 0},
+	BOTTOM: {1, 2},
+}
+
+// The corners of the faces seen from inside the box, where the top left,
+// top right, bottom right and bottom left of their images go
+var Corners = [6][4][3]float32{
+	FRONT:  {{-1, 1, -1}, {1, 1, -1}, {1, -1, -1}, {-1, -1, -1}},
+	BACK:   {{1, 1, 1}, {-1, 1, 1}, {-1, -1, 1}, {1, -1, 1}},
+	LEFT:   {{-1, 1, 1}, {-1, 1, -1}, {-1, -1, -1}, {-1, -1, 1}},
+	RIGHT:  {{1, 1, -1}, {1, 1, 1}, {1, -1, 1}, {1, -1, -1}},
+	TOP:    {{-1, 1, 1}, {1, 1, 1}, {1, 1, -1}, {-1, 1, -1}},
+	BOTTOM: {{-1, -1, -1}, {1, -1, -1}, {1, -1, 1}, {-1, -1, 1}},
+}
+
+// The texture coordinates of the corners, the first row of an image being
+// the first row of its texture
+var TexCoords = [4][2]float32{{0, 0}, {1, 0}, {1, 1}, {0, 1}}
+
+// A Face is an image of one side of the sky as tightly packed RGBA
+type Face struct {
+	Pixels        []byte
+	Width, Height int
+}
+
+// A Loader reads an image as tightly packed RGBA, the way a lesson loads
+// its textures
+type Loader func(path string) (pixels []byte, width, height int, err error)
+
+// Load the faces of a skybox from six images if path has a *, which is
+// replaced by the names of the faces, or else from one image holding them
+// as a cross
+func Load(path string, load Loader) ([6]Face, error) {
+	var faces [6]Face
+
+	if strings.Contains(path, "*") {
+		for face, name := range faceNames {
+			pixels, width, height, err := load(strings.Replace(path, "*", name, -1))
+			if err != nil {
+				return faces, err
+			}
+			faces[face] = Face{pixels, width, height}
+		}
+		return faces, nil
+	}
+
+	pixels, width, height, err := load(path)
+	if err != nil {
+		return faces, err
+	}
+	size := width / 4
+	if size == 0 || width != 4*size || height != 3*size {
+		return faces, fmt.Errorf("%s: a cross of faces is 4 faces wide and 3 high, not %dx%d pixels", path, width, height)
+	}
+	for face, cell := range cross {
+		faces[face] = Face{crop(pixels, width, cell[0]*size, cell[1]*size, size), size, size}
+	}
+	return faces, nil
+}
+
+// The size by size square at x, y of RGBA pixels width wide
+func crop(pixels []byte, width, x, y, size int) []byte {
+	square := make([]byte, size*size*4)
+	for row := 0; row < size; row++ {
+		start := ((y+row)*width + x) * 4
+		copy(square[row*size*4:(row+1)*size*4], pixels[start:])
+	}
+	return square
+}
@@ -0,0 +1,78 @@
+package sky
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// A cross of faces size pixels wide, each pixel red with the number of
+// the face it belongs to, 255 where there is none
+func testCross(size int) []byte {
+	pixels := make([]byte, 4*size*3*size*4)
+	for i := 0; i < len(pixels); i += 4 {
+		x, y := i/4%(4*size)/size, i/4/(4*size)/size
+		pixels[i] = 255
+		for face, cell := range cross {
+			if cell == [2]int{x, y} {
+				pixels[i] = byte(face)
+			}
+		}
+	}
+	return pixels
+}
+
+func TestLoad(t *testing.T) {
+	images := map[string][]byte{"cross.png": testCross(2), "wide.png": make([]byte, 5*3*4)}
+	sizes := map[string][2]int{"cross.png": {8, 6}, "wide.png": {5, 3}}
+	for face, name := range faceNames {
+		images[name+".png"] = []byte{byte(face), 0, 0, 255}
+		sizes[name+".png"] = [2]int{1, 1}
+	}
+	load := func(path string) ([]byte, int, int, error) {
+		pixels, ok := images[path]
+		if !ok {
+			return nil, 0, 0, errors.New(path + ": no such file")
+		}
+		return pixels, sizes[path][0], sizes[path][1], nil
+	}
+
+	tests := []struct {
+		path string
+		size int
+		err  string
+	}{
+		{"cross.png", 2, ""},
+		{"*.png", 1, ""},
+		{"wide.png", 0, "4 faces wide and 3 high, not 5x3 pixels"},
+		{"missing.png", 0, "no such file"},
+		{"*.jpg", 0, "front.jpg: no such file"},
+	}
+
+	for _, test := range tests {
+		faces, err := Load(test.path, load)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got error %v, want %q", test.path, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.path, err)
+			continue
+		}
+
+		for face, f := range faces {
+			if f.Width != test.size || f.Height != test.size || len(f.Pixels) != test.size*test.size*4 {
+				t.Errorf("%s: face %s is %dx%d with %d bytes, want %dx%d", test.path, faceNames[face], f.Width, f.Height, len(f.Pixels), test.size, test.size)
+				continue
+			}
+			for i := 0; i < len(f.Pixels); i += 4 {
+				if f.Pixels[i] != byte(face) {
+					t.Errorf("%s: face %s has a pixel of face %d", test.path, faceNames[face], f.Pixels[i])
+					break
+				}
+			}
+		}
+	}
+}